@@ -0,0 +1,190 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	gourl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// runSpec is the test a coordinator sends to its agents.
+type runSpec struct {
+	Method             string
+	URL                string
+	Header             http.Header
+	Host               string
+	Body               string
+	N                  int
+	C                  int
+	QPS                float64
+	Burst              int
+	Duration           time.Duration
+	Timeout            int
+	H2                 bool
+	DisableCompression bool
+	DisableKeepAlives  bool
+	DisableRedirects   bool
+}
+
+// validate checks the values of spec as validateFlags checks the flags
+// they come from, anyone reaching the agent being able to send a spec.
+func (spec *runSpec) validate() error {
+	switch {
+	case spec.C < 1:
+		return errors.New("c cannot be smaller than 1")
+	case spec.N < 1:
+		return errors.New("n cannot be smaller than 1")
+	case spec.QPS < 0:
+		return errors.New("qps cannot be negative")
+	case spec.QPS == 0 && spec.N < spec.C:
+		return errors.New("n cannot be less than c")
+	case spec.Burst < 0 || spec.Burst > 1 && spec.QPS == 0:
+		return errors.New("burst cannot be negative, and requires qps")
+	case spec.Timeout < 0:
+		return errors.New("timeout cannot be negative")
+	case spec.Duration < 0:
+		return errors.New("duration cannot be negative")
+	}
+	u, err := gourl.Parse(spec.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid url %q", spec.URL)
+	}
+	return nil
+}
+
+// runAgent serves the tests sent by a coordinator on addr, one at a time,
+// answering each with the summary of its run. Anyone reaching addr can
+// run tests, there is no authentication.
+func runAgent(addr string) {
+	log.Printf("agent listening on %s", addr)
+	errAndExit(http.ListenAndServe(addr, agentMux()).Error())
+}
+
+// agentMux returns the handler of the agent, serving only /run, not what
+// other packages register on the default mux.
+func agentMux() *http.ServeMux {
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		var spec runSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := spec.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := http.NewRequest(spec.Method, spec.URL, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.ContentLength = int64(len(spec.Body))
+		req.Header = spec.Header
+		req.Host = spec.Host
+
+		mu.Lock()
+		defer mu.Unlock()
+		log.Printf("running %s %s, n=%d c=%d", spec.Method, spec.URL, spec.N, spec.C)
+		work := &requester.Work{
+			Request:            req,
+			RequestBody:        spec.Body,
+			N:                  spec.N,
+			C:                  spec.C,
+			QPS:                spec.QPS,
+			Burst:              spec.Burst,
+			Timeout:            spec.Timeout,
+			H2:                 spec.H2,
+			DisableCompression: spec.DisableCompression,
+			DisableKeepAlives:  spec.DisableKeepAlives,
+			DisableRedirects:   spec.DisableRedirects,
+			Duration:           spec.Duration,
+			Writer:             ioutil.Discard,
+		}
+		work.Run()
+		json.NewEncoder(w).Encode(work.Summary())
+	})
+	return mux
+}
+
+// coordinate runs spec on every agent at once, and prints the merged
+// summary of their runs.
+func coordinate(agents []string, spec runSpec) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	summaries := make([]*requester.Summary, len(agents))
+	errs := make([]error, len(agents))
+	var wg sync.WaitGroup
+	for i, agent := range agents {
+		wg.Add(1)
+		go func(i int, agent string) {
+			defer wg.Done()
+			summaries[i], errs[i] = runOnAgent(agent, data)
+		}(i, agent)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			errAndExit(fmt.Sprintf("agent %s: %v", agents[i], err))
+		}
+	}
+	requester.PrintSummaries(os.Stdout, *output, summaries)
+
+	merged := &requester.Summary{}
+	for _, s := range summaries {
+		merged.NumRes += s.NumRes
+		merged.Errors += s.Errors
+		merged.RespCheckFailures += s.RespCheckFailures
+		if s.Aborted != "" {
+			merged.Aborted = s.Aborted
+		}
+	}
+	recordOutcome(merged)
+}
+
+func runOnAgent(agent string, spec []byte) (*requester.Summary, error) {
+	if !strings.Contains(agent, "://") {
+		agent = "http://" + agent
+	}
+	resp, err := http.Post(agent+"/run", "application/json", bytes.NewReader(spec))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var s requester.Summary
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
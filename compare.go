@@ -0,0 +1,56 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// compareKeepAlives runs the tests of newWork without keep-alive, then with
+// it, and prints the difference.
+func compareKeepAlives(newWork func() *requester.Work, dur time.Duration) {
+	var off, on *requester.Summary
+	for _, disable := range []bool{true, false} {
+		w := newWork()
+		w.DisableKeepAlives = disable
+		w.Writer = ioutil.Discard
+		runWork(w, dur)
+		if disable {
+			off = w.Summary()
+		} else {
+			on = w.Summary()
+		}
+	}
+	printComparison(os.Stdout, off, on)
+}
+
+func printComparison(w io.Writer, off, on *requester.Summary) {
+	fmt.Fprintf(w, "%-24s%12s%12s%10s\n", "Keep-alive comparison:", "off", "on", "delta")
+	row := func(name string, a, b float64) {
+		delta := "-"
+		if a > 0 {
+			delta = fmt.Sprintf("%+.2f%%", (b-a)*100/a)
+		}
+		fmt.Fprintf(w, "  %-22s%12.4f%12.4f%10s\n", name, a, b, delta)
+	}
+	row("Requests/sec:", off.Rps(), on.Rps())
+	row("99% in (secs):", off.Quantile(0.99), on.Quantile(0.99))
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// running are the tests in progress, controlled by the keyboard commands.
+var (
+	runningMu sync.Mutex
+	running   = make(map[*requester.Work]bool)
+)
+
+func setRunning(w *requester.Work, on bool) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if on {
+		running[w] = true
+	} else {
+		delete(running, w)
+	}
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readControls applies the commands typed on r, one per line, to the tests
+// in progress: p pauses them, r resumes them and s prints their progress
+// to out. Ctrl-C still stops them, the terminal stays in line mode.
+func readControls(r io.Reader, out io.Writer) {
+	fmt.Fprintln(out, "Type p to pause, r to resume, s for a snapshot, then Enter.")
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" {
+			continue
+		}
+		runningMu.Lock()
+		for w := range running {
+			switch cmd {
+			case "p":
+				w.Pause()
+			case "r":
+				w.Resume()
+			case "s":
+				w.Progress(out)
+			}
+		}
+		runningMu.Unlock()
+		switch cmd {
+		case "p":
+			fmt.Fprintln(out, "Paused, type r to resume.")
+		case "r":
+			fmt.Fprintln(out, "Resumed.")
+		case "s":
+		default:
+			fmt.Fprintf(out, "Unknown command %q, type p, r or s.\n", cmd)
+		}
+	}
+}
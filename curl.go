@@ -0,0 +1,204 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// curlRequest is the request described by a curl command line.
+type curlRequest struct {
+	method  string
+	url     string
+	headers []string // "Name: Value", as given to -H
+	body    string
+	user    string // username:password
+}
+
+// curlIgnored are the curl options without a value that don't change the
+// request hey sends: hey follows redirects, skips TLS verification and
+// handles compression itself.
+var curlIgnored = map[string]bool{
+	"-s": true, "--silent": true,
+	"-S": true, "--show-error": true,
+	"-v": true, "--verbose": true,
+	"-L": true, "--location": true,
+	"-k": true, "--insecure": true,
+	"--compressed": true,
+}
+
+// parseCurl parses a curl command line like
+// `curl -X POST -H "Content-Type: application/json" -d '{}' https://host/`.
+// Options that can't be mapped to the request are reported as errors.
+func parseCurl(cmd string) (*curlRequest, error) {
+	args, err := splitShell(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 && args[0] == "curl" {
+		args = args[1:]
+	}
+	cr := &curlRequest{}
+	var data []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			if cr.url != "" {
+				return nil, fmt.Errorf("curl: more than one url, %q and %q", cr.url, arg)
+			}
+			cr.url = arg
+			continue
+		}
+		if curlIgnored[arg] || isIgnoredShortGroup(arg) {
+			continue
+		}
+		if arg == "-I" || arg == "--head" {
+			cr.method = "HEAD"
+			continue
+		}
+
+		// options with a value, either attached (-XPOST) or as the next arg
+		opt, val := arg, ""
+		if !strings.HasPrefix(arg, "--") && len(arg) > 2 {
+			opt, val = arg[:2], arg[2:]
+		} else {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("curl: option %s needs a value", arg)
+			}
+			i++
+			val = args[i]
+		}
+		switch opt {
+		case "-X", "--request":
+			cr.method = strings.ToUpper(val)
+		case "-H", "--header":
+			cr.headers = append(cr.headers, val)
+		case "-A", "--user-agent":
+			cr.headers = append(cr.headers, "User-Agent: "+val)
+		case "-e", "--referer":
+			cr.headers = append(cr.headers, "Referer: "+val)
+		case "-b", "--cookie":
+			if !strings.Contains(val, "=") {
+				return nil, fmt.Errorf("curl: cookie files are not supported, %s %s", opt, val)
+			}
+			cr.headers = append(cr.headers, "Cookie: "+val)
+		case "-u", "--user":
+			cr.user = val
+		case "--url":
+			cr.url = val
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii":
+			if strings.HasPrefix(val, "@") && opt != "--data-raw" {
+				b, err := ioutil.ReadFile(val[1:])
+				if err != nil {
+					return nil, fmt.Errorf("curl: %v", err)
+				}
+				val = string(b)
+			}
+			data = append(data, val)
+		default:
+			return nil, fmt.Errorf("curl: unsupported option %s", opt)
+		}
+	}
+	if cr.url == "" {
+		return nil, errors.New("curl: no url given")
+	}
+	if len(data) > 0 {
+		cr.body = strings.Join(data, "&")
+		if cr.method == "" {
+			cr.method = "POST"
+		}
+	}
+	if cr.method == "" {
+		cr.method = "GET"
+	}
+	return cr, nil
+}
+
+// isIgnoredShortGroup reports whether arg is a group of ignored short
+// options, like -sSL.
+func isIgnoredShortGroup(arg string) bool {
+	if strings.HasPrefix(arg, "--") || len(arg) < 3 {
+		return false
+	}
+	for _, c := range arg[1:] {
+		if !curlIgnored["-"+string(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitShell splits s into words the way a POSIX shell does, handling
+// single and double quotes, backslash escapes and line continuations.
+func splitShell(s string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+	for _, r := range s {
+		switch {
+		case escape:
+			escape = false
+			if r == '\n' {
+				continue
+			}
+			// inside double quotes the backslash is only special before a few characters
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			inWord = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escape = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escape {
+		return nil, errors.New("curl: unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// harLog is the part of a HAR file needed to replay its requests.
+type harLog struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// harSkipHeaders are recorded headers that net/http sets itself.
+var harSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// loadHAR reads the requests recorded in file, keeping the ones whose host
+// and path match filter if it's not empty. rf is applied to each request.
+func loadHAR(file, filter string, rf *requestFlags) ([]*http.Request, []string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var har harLog
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var re *regexp.Regexp
+	if filter != "" {
+		if re, err = regexp.Compile(filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var reqs []*http.Request
+	var bodies []string
+	for i, e := range har.Log.Entries {
+		var body string
+		if e.Request.PostData != nil {
+			body = e.Request.PostData.Text
+		}
+		req, err := http.NewRequest(e.Request.Method, e.Request.URL, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: entry %d: %v", file, i, err)
+		}
+		if re != nil && !re.MatchString(req.URL.Host+req.URL.Path) {
+			continue
+		}
+		req.ContentLength = int64(len(body))
+		for _, h := range e.Request.Headers {
+			// HTTP/2 pseudo headers like :authority
+			if strings.HasPrefix(h.Name, ":") || harSkipHeaders[http.CanonicalHeaderKey(h.Name)] {
+				continue
+			}
+			req.Header.Add(h.Name, h.Value)
+		}
+		rf.apply(req)
+		reqs = append(reqs, req)
+		bodies = append(bodies, body)
+	}
+	if len(reqs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no requests to replay", file)
+	}
+	return reqs, bodies, nil
+}
@@ -16,27 +16,38 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"math"
+	"net"
 	"net/http"
 	gourl "net/url"
 	"os"
 	"os/signal"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/pengzhimou/hey/requester"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
 const (
 	headerRegexp = `^([\w-]+):\s*(.+)`
 	authRegexp   = `^(.+):([^\s].+)`
 	heyUA        = "hey/0.0.2"
+	seqToken     = "{{seq}}"
 )
 
 var (
@@ -64,59 +75,502 @@ var (
 
 	disableCompression = flag.Bool("disable-compression", false, "")
 	disableKeepAlives  = flag.Bool("disable-keepalive", false, "")
+	connClose          = flag.Bool("conn-close", false, "")
+	idleTimeout        = flag.Duration("idle-timeout", 0, "")
 	disableRedirects   = flag.Bool("disable-redirects", false, "")
+	keepAuthOnRedirect = flag.Bool("keep-auth-on-redirect", false, "")
 	proxyAddr          = flag.String("x", "", "")
+	proxyAuth          = flag.String("proxy-auth", "", "")
 	urlFile            = flag.String("urlfile", "", "")
 	url                = flag.String("url", "", "")
 	round              = flag.Int("r", 1, "")
 	roundsleep         = flag.Int("rs", 0, "")
 	randmark           = flag.String("randmark", "", "")
+	ntlm               = flag.String("ntlm", "", "")
+	perURL             = flag.Bool("per-url", false, "")
+	perWorker          = flag.Bool("per-worker-stats", false, "")
+	portRange          = flag.String("port-range", "", "")
+	hosts              = flag.String("hosts", "", "")
+	csvHeaders         = flag.String("csv-headers", "", "")
+	warmConns          = flag.Bool("warm-conns", false, "")
+	faultSpec          = flag.String("fault", "", "")
+	burst              = flag.Int("burst", 1, "")
+	debugRuntime       = flag.Bool("debug-runtime", false, "")
+	name               = flag.String("name", "", "")
+	noUA               = flag.Bool("no-ua", false, "")
+	headerFile         = flag.String("H-file", "", "")
+	curlCmd            = flag.String("curl", "", "")
+	harFile            = flag.String("har", "", "")
+	harFilter          = flag.String("har-filter", "", "")
+	harRandom          = flag.Bool("har-random", false, "")
+	requestsFile       = flag.String("requests-file", "", "")
+	requestsRandom     = flag.Bool("requests-random", false, "")
+	requestsOnce       = flag.Bool("requests-once", false, "")
+	streamStdin        = flag.Bool("stream-stdin", false, "")
+	fast               = flag.Bool("fast", false, "")
+	resultsOverflow    = flag.String("results-overflow", "block", "")
+	reporters          = flag.Int("reporters", 1, "")
+	exact              = flag.Bool("exact", false, "")
+	hdrFile            = flag.String("hdr", "", "")
+	influxURL          = flag.String("influx", "", "")
+	agentAddr          = flag.String("agent", "", "")
+	coordinator        = flag.String("coordinator", "", "")
+	traceRedirects     = flag.Bool("trace-redirects", false, "")
+	countHops          = flag.Bool("count-redirect-hops", false, "")
+	compareKeepAlive   = flag.Bool("compare-keepalive", false, "")
+	startJitter        = flag.Duration("start-jitter", 0, "")
+	resolveOnce        = flag.Bool("resolve-once", false, "")
+	waitReady          = flag.Duration("wait-ready", 0, "")
+	parseServerTiming  = flag.Bool("parse-server-timing", false, "")
+	okStatus           = flag.String("ok-status", "", "")
+	verifyOnly         = flag.Bool("verify-only", false, "")
+	success            = flag.String("success", "", "")
+	validateCache      = flag.Bool("validate-cache", false, "")
+	cacheHeader        = flag.String("cache-header", "", "")
+	rangeSpec          = flag.String("range", "", "")
+	methodMixSpec      = flag.String("method-mix", "", "")
+	schemaFile         = flag.String("schema", "", "")
+	seed               = flag.Int64("seed", 0, "")
+	summaryTmplFile    = flag.String("summary-template", "", "")
+	cpuProfile         = flag.String("pprof", "", "")
+	memProfile         = flag.String("memprofile", "", "")
+	replayFile         = flag.String("replay", "", "")
+	oauth2TokenURL     = flag.String("oauth2-token-url", "", "")
+	oauth2ClientID     = flag.String("oauth2-client-id", "", "")
+	oauth2Secret       = flag.String("oauth2-client-secret", "", "")
+	abortErrorRate     = flag.String("abort-error-rate", "", "")
+	abortWindow        = flag.Int("abort-window", 100, "")
+	noResumption       = flag.Bool("no-session-resumption", false, "")
+	connReuse          = flag.Bool("conn-reuse", false, "")
+	quiet              = flag.Bool("quiet", false, "")
+	color              = flag.String("color", "auto", "")
+	rotateHeader       = flag.String("rotate-header", "", "")
+	distinctBodies     = flag.Bool("distinct-bodies", false, "")
+	nSuccess           = flag.Int("n-success", 0, "")
+	acceptEncoding     = flag.String("accept-encoding", "", "")
+	idempotencyKey     = flag.String("idempotency-key-header", "", "")
+	bodyDigest         = flag.String("body-digest", "", "")
+	bodyStream         = flag.String("D-stream", "", "")
+	strict             = flag.Bool("strict", false, "")
+	requireCompression = flag.Bool("require-compression", false, "")
+	failOnEmptyBody    = flag.Bool("fail-on-empty-body", false, "")
+	maxInFlight        = flag.Int("max-inflight", 0, "")
+	hedge              = flag.Duration("hedge", 0, "")
+	sndbuf             = flag.Int("sndbuf", 0, "")
+	rcvbuf             = flag.Int("rcvbuf", 0, "")
+	shuffleHeaders     = flag.Bool("shuffle-headers", false, "")
+	raw                = flag.Bool("raw", false, "")
+	rawRead            = flag.Int("raw-read", 0, "")
+	repeatSpec         = flag.String("repeat-body", "", "")
+	repeatRandmark     = flag.String("repeat-randmark", "after", "")
+	slowestN           = flag.Int("slowest", 0, "")
+	alpn               = flag.String("alpn", "", "")
 )
 
+// Exit codes, documented in the usage.
+const (
+	exitError     = 1 // hey itself failed, e.g. a file couldn't be read
+	exitUsage     = 2
+	exitSLO       = 3 // reserved for SLO checks
+	exitRespCheck = 4
+	exitAllFailed = 5
+	exitTLS       = 6 // -verify-only found an invalid certificate
+	exitAborted   = 7
+)
+
+// exitCode is the worst outcome of the tests run, set by recordOutcome.
+var (
+	exitMu   sync.Mutex
+	exitCode int
+)
+
+var ntlmUser, ntlmPassword string
+
+// basicAuthUser and basicAuthPassword are the credentials of -a when they
+// hold {{seq}} or the -randmark.
+var basicAuthUser, basicAuthPassword string
+
+var fault *requester.Fault
+
+// schema generates the bodies of -schema.
+var schema *schemaGen
+
+// byteRange is the range of -range.
+var byteRange *requester.ByteRange
+
+// okStatusFunc is the status code allow-list of -ok-status.
+var okStatusFunc func(code int) bool
+
+// abortRate is the error rate of -abort-error-rate.
+var abortRate float64
+
+// successCond is the condition of -success.
+var successCond *requester.Predicate
+
+// tokenSource provides the tokens of the -oauth2 flags.
+var tokenSource oauth2.TokenSource
+
+// summaryTmpl is the template of -summary-template.
+var summaryTmpl *template.Template
+
+// rotation is the header of -rotate-header and its values.
+var rotation *headerRotation
+
+// mix and mixBodies are the methods of -method-mix and their bodies.
+var (
+	mix       *methodMix
+	mixBodies = make(methodBodies)
+)
+
+// requests loaded with -har or -requests-file, their bodies, and whether
+// to pick them at random
+var (
+	replayReqs   []*http.Request
+	replayBodies []string
+	replayRandom bool
+)
+
+// replaySchedule are the requests of -replay, at their offsets.
+var replaySchedule []requester.Scheduled
+
+// replayStream are the requests of -stream-stdin, as they're read.
+var replayStream <-chan requester.Prepared
+
+// repeatBody repeats the bodies of -repeat-body once the -randmark is
+// replaced in them, with -repeat-randmark before.
+var repeatBody func(body string) string
+
+// info prints the informational messages, silenced by -quiet.
+var info = log.New(os.Stdout, "", 0)
+
 var usage = `Usage: hey [options...]
 
 Options:
   -n  Number of requests to run. Default is 200.
+  -n-success  Stop once this many responses succeeded, i.e. not errors and
+      passing -ok-status, -success and -respcheck, reporting the number of
+      requests it took. -n is ignored unless given, then it caps the requests.
   -c  Number of workers to run concurrently. Total number of requests cannot
       be smaller than the concurrency level. Default is 50. Will ignore when -q used.
   -q  Rate limit, in queries per second (QPS). Default is no rate limit. Can't use with -c.
+      The summary reports the rate achieved in each second of the run, with a
+      warning when it was more than 10%% off, e.g. the client couldn't keep up.
+  -max-inflight  Maximum number of requests outstanding at once, whatever -c
+      or -q, e.g. to test servers with a request queue limit. The summary
+      reports the most that were.
+  -burst  Number of requests -q may send at once while keeping the average
+      rate, to model spiky traffic. Default is 1, evenly spaced requests.
+  -start-jitter  Delay the first request of each worker by a random duration
+      up to this one, to smooth the start, e.g. -start-jitter 1s. Can't use with -q.
   -z  Duration of application to send requests. When duration is reached,
-      application stops and exits. If duration is specified, n is ignored.
-      Examples: -z 10s -z 3m.
-  -o  Output type. If none provided, a summary is printed.
-      "csv" is the only supported alternative. Dumps the response
-      metrics in comma-separated values format.
+      application stops and exits. If duration is specified, n is ignored
+      unless given explicitly, then whichever limit is reached first stops.
+      The limit in effect is printed at startup. Examples: -z 10s -z 3m.
+  -o  Output type. If none provided, a summary is printed, see -color.
+      "csv" dumps the response metrics in comma-separated values format,
+      a header row then a row per response, with the columns response-time,
+      DNS+dialup, DNS, Request-write, Response-delay, Response-read (in
+      seconds, 0 with -fast), status-code and offset, then the -csv-headers
+      and the -name if set. Requests failing without a response are left out.
+      "json" prints the summary as a json object.
+      "ab" and "wrk" print the summary like ApacheBench and wrk do, for the
+      scripts parsing their output.
+      "influx" prints InfluxDB line protocol points, one per second of the
+      run, status code and method, with tags status and method and the
+      fields count, errors, mean and max (response times in seconds).
+  -csv-headers  Comma separated response headers to add as csv columns,
+      e.g. -csv-headers X-Request-Id,Server.
+  -quiet  Only print the -o output, without the summary before it and the
+      informational messages, e.g. for hey -o json -quiet | jq.
+  -color  Whether the summary is colored: "auto" (default) on a terminal
+      unless NO_COLOR is set, "always", e.g. for less -R, or "never".
+
+  -summary-template  File of a Go text/template rendering the summary in place
+      of the built-in one, e.g. for markdown. It gets the fields of the report,
+      like {{ .NumRes }}, {{ .Rps }}, {{ .LatencyDistribution }} and
+      {{ .StatusCodeDist }}, and the formatNumber function.
+
+  -name  Label of the run, included in the summary, csv and json outputs.
 
   -m  HTTP method, one of GET, POST, PUT, DELETE, HEAD, OPTIONS.
   -H  Custom HTTP header. You can specify as many as needed by repeating the flag.
       For example, -H "Accept: text/html" -H "Content-Type: application/xml" .
+  -H-file  File of headers, one "Name: Value" per line, e.g. captured browser
+      headers. -H flags override the headers from the file.
+  -idempotency-key-header  Header to set to a new random UUID on every request,
+      e.g. -idempotency-key-header Idempotency-Key. Hedged requests and
+      redirects reuse the key of their request.
+  -body-digest  Set the digest of the body of each request, once -randmark
+      and {{seq}} are replaced in it, both base64 encoded: "md5" in
+      Content-MD5, "sha256" in Digest, as SHA-256=<digest>. Can't use with
+      -D-stream or -raw.
   -t  Timeout for each request in seconds. Default is 20, use 0 for infinite.
+  -hedge  Send a second, identical request when a request has no response after
+      this long, e.g. -hedge 50ms, keeping the first response and cancelling
+      the other request, to measure this tail-cutting technique. The summary
+      counts the hedged requests. Can't use with -ntlm or -warm-conns.
   -A  HTTP Accept header.
   -d  HTTP request body, better with -randmark.
-  -D  HTTP request body from file. better with -randmark.
+  -D  HTTP request body from file. better with -randmark. Can't use with -d.
+  -D-stream  HTTP request body from file, sent as it's read instead of loaded
+      in memory, for large uploads. The file is opened again for each request.
+      {{seq}} is only replaced in the url and headers, -randmark not at all.
   -T  Content-type, defaults to "text/html".
   -U  User-Agent, defaults to version "hey/0.0.2".
-  -a  Basic authentication, username:password.
+  -no-ua  Send no User-Agent header at all. Takes precedence over -U.
+  -a  Basic authentication, username:password. {{seq}} and the -randmark
+      are replaced in them on each request, e.g. -a "user{{seq}}:pass{{seq}}".
   -x  HTTP Proxy address as host:port.
+  -proxy-auth  Proxy credentials, username:password, kept out of the -x URL.
   -h2 Enable HTTP/2.
 
   -host	HTTP Host header.
 
   -disable-compression  Disable compression.
+  -require-compression  Count the responses with a body as errors unless they
+                        are compressed, and with -accept-encoding, smaller
+                        than decompressed.
+  -fail-on-empty-body   Count the responses without a byte of body as errors,
+                        even with a 200, e.g. to catch cache misses served
+                        empty. HEAD requests, 204 and 304 are left out. The
+                        summary reports them apart from the other errors.
+  -accept-encoding      Send this Accept-Encoding, e.g. gzip, and report the
+                        size of the responses on the wire and decompressed,
+                        with the compression ratio. Can't use with
+                        -disable-compression.
   -disable-keepalive    Disable keep-alive, prevents re-use of TCP
-                        connections between different HTTP requests.
+                        connections between different HTTP requests. The
+                        summary reports the connections/sec, e.g. to
+                        benchmark TLS handshakes.
+  -conn-close           Send Connection: close with each request, asking the
+                        server to close the connection after responding,
+                        with keep-alive still on in hey. The summary reports
+                        the share of the responses on a new connection.
+                        Can't use with -warm-conns, -raw or -ntlm.
+  -idle-timeout         Close the keep-alive connections idle for this long,
+                        e.g. -idle-timeout 90s to match the keep-alive timeout
+                        of the server, opening new ones when needed; see
+                        -conn-reuse. Default is no limit.
   -disable-redirects    Disable following of HTTP redirects
+  -sndbuf               Size of the send buffer of the sockets in bytes,
+                        e.g. -sndbuf 4194304, set before connecting. With
+                        -rcvbuf, for links with a high bandwidth-delay
+                        product. The system may cap them, e.g. to
+                        net.core.wmem_max on Linux. Linux and macOS only.
+  -rcvbuf               Size of the receive buffer of the sockets in bytes.
+  -shuffle-headers      Write the headers of each request in a random order,
+                        e.g. to exercise servers sensitive to it. HTTP/1.1
+                        only, can't use with -h2, -alpn, -x or -warm-conns.
+  -raw                  Send the body of -d or -D as is over a new TCP
+                        connection to the host of -url, with TLS for https,
+                        in place of each HTTP request, e.g. for other
+                        protocols or malformed requests. The response is read
+                        until the server closes the connection, the response
+                        delay is the time to its first byte.
+  -raw-read             With -raw, stop reading the response after this many
+                        bytes, for servers keeping the connection open.
+  -alpn                 Protocols offered by the TLS handshakes, e.g.
+                        -alpn h2,http/1.1, in place of those of -h2, reporting
+                        the protocol negotiated by each connection. Without
+                        -h2, connections negotiating h2 still speak HTTP/1.1.
+  -no-session-resumption  Make a full TLS handshake for every new connection.
+                        By default, the TLS sessions of earlier connections
+                        are resumed. The summary counts both handshakes.
+  -resolve-once         Resolve the host once before the run and dial the
+                        resolved IP, keeping the Host header and TLS server
+                        name, so DNS lookups don't add to response times.
+  -wait-ready           Before the run, probe the URL with GET requests until
+                        one gets a response below 500, for up to this long,
+                        e.g. -wait-ready 30s. The time waited is reported and
+                        isn't part of the run or of -z.
+  -compare-keepalive    Run the test twice, without keep-alive then with it,
+                        and print the requests/sec and 99%% latency of both.
+                        Can't use with -exact.
+  -slowest              List this many of the slowest requests in the summary,
+                        with their URL, status and phases, e.g. -slowest 10.
+  -distinct-bodies      Hash the response bodies and report how many
+                        different ones were received, with the most common,
+                        e.g. to catch error pages served with a 200.
+  -conn-reuse           Report the distribution of the number of requests
+                        sent on each connection, to spot keep-alive issues
+                        like many single-use connections. Can't use with -fast.
+  -warm-conns           Dial -c keep-alive connections before the run and
+                        reuse only those; requests needing a new connection
+                        fail and are reported.
+  -parse-server-timing  Report the average durations of the metrics of the
+                        Server-Timing response headers, like "db;dur=12".
+  -validate-cache       Send the ETag of the last response of each worker in
+                        If-None-Match, reporting the ratio of 304 responses
+                        to these conditional requests. Can't use with -q.
+  -cache-header         Response header telling whether a cache served the
+                        response, e.g. -cache-header X-Cache, reporting the
+                        latency percentiles of the HIT, MISS and other
+                        responses, to compare warm and cold cache.
+  -range                Range header to send, with a single range, e.g.
+                        -range bytes=0-1023, reporting how many responses
+                        honored it with a 206 and the Content-Range asked
+                        for, and how many were 200 with the full body. 206
+                        responses with another Content-Range are errors.
+  -trace-redirects      Time each hop of the redirects followed, reporting
+                        the average number of redirects and hop latencies.
+  -count-redirect-hops  Count each redirect followed as a request of its own,
+                        with its status code and latency, so the summary has
+                        more responses than requests sent with -n. Response
+                        checks like -ok-status only apply to the final ones.
+  -keep-auth-on-redirect  Resend the Authorization header when following
+                        redirects to another host. Use with care.
   -cpus                 Number of used cpu cores.
                         (default for current machine is %d cores)
+  -strict               Refuse to run when -c may exceed the limit of open
+                        files, after trying to raise it to the hard limit,
+                        rather than warning. Linux and macOS only.
+  -results-overflow     What workers do when the reporter falls behind:
+                        "block" (default) waits, which can slow the request
+                        rate down; "drop" drops and counts the results, which
+                        keeps the rate but leaves them out of the statistics.
+  -reporters            Number of goroutines aggregating the results, merged
+                        for the summary, e.g. -reporters 4 when a single one
+                        falls behind the workers at high rates. Can't use
+                        with -o csv, -abort-error-rate or -n-success.
+  -exact                Compute the percentiles from all response times, up
+                        to 1M of them. By default they are estimated to
+                        within 1%% with bounded memory, for long -z runs.
+  -hdr                  File to write the response time distribution to, in
+                        the .hgrm format of HdrHistogram, in milliseconds.
+  -influx               InfluxDB write endpoint to push the points of the
+                        influx output to at the end of the run, in batches,
+                        e.g. -influx 'http://host:8086/write?db=hey'.
+  -fast                 Skip tracing the phases of the requests, recording
+                        only total durations and status codes, for the
+                        highest request rate. No details in the summary.
+  -debug-runtime        Print goroutines and open connections before and
+                        after the run, and the peak heap size.
+  -pprof                File to write the CPU profile of hey itself to,
+                        while the workers run, for go tool pprof.
+  -memprofile           File to write the heap profile of hey to, once the
+                        workers are done. Both are written on Ctrl-C too.
+  -verify-only          Only check the TLS certificates of the https hosts
+                        of -url or -urlfile, with a handshake per host and
+                        full verification, reporting their expiry, invalid
+                        chains and SNI mismatches. No HTTP request is sent.
 
   -cert certfile location
   -key keyfile location
   -urlfile urlfile location
+  -per-url with -urlfile, run all urls in turn within one test and report
+           count, error rate and p95 for each url
+  -port-range send the requests of -url or -curl to each port of the range in
+              turn, e.g. -port-range 8001-8010 for shards without a load
+              balancer, reporting count, error rate and p95 for each port
+  -hosts send the requests of -url to each host of the comma separated list in
+         turn, e.g. -hosts web1,web2,web3:8080 -url /health, reporting count,
+         error rate and p95 for each host. The hosts without a port keep the
+         one of -url, which may be only a path, then sent over http.
+  -per-worker-stats report count, error rate, mean and p95 latency for each of the
+         -c workers, to spot imbalance, e.g. one pinned to a slow connection.
+         Can't use with -q, -replay, -raw or -coordinator.
   -url url link
-  -r rounds, should with method GET only
-  -rs each round skip time, should with method GET only
+  -curl run the request of a curl command, e.g.
+        -curl 'curl -X POST -H "Content-Type: application/json" -d "{}" https://host/'
+        Method, url, headers, body and basic auth are taken from it, -H flags
+        override its headers. Unsupported curl options are reported.
+  -har replay the requests recorded in a HAR file, in turn, with their
+       methods, urls, headers and bodies. The header flags (-H, -H-file, -A,
+       -T, -U, -no-ua) override their headers, -a and -host apply too.
+  -har-filter only replay the HAR requests whose host and path match this
+       regular expression, e.g. -har-filter "api.example.com/v1/"
+  -har-random pick the HAR requests at random instead of in turn
+  -r rounds, method GET only
+  -rs each round skip time, method GET only
   -randmark replace HEY mark from url, header, payload with goroutine number
+  -repeat-body repeat the -d or -D body a number of times, e.g. 100, or up to
+               a size, e.g. 1MB. Up to 256MB, use -D-stream for more.
+  -repeat-randmark replace the -randmark in the body "after" repeating it
+               (default), or "before", so that the body has exactly the
+               -repeat-body size.
+  {{seq}} in the url, headers, body or -a is replaced by the sequence number
+          of the request, increasing from 1 across all workers, e.g. -H "X-Seq: {{seq}}"
+  -abort-error-rate stop the run once more than this share of the latest
+             -abort-window results are errors, e.g. -abort-error-rate 50%%.
+             The run is reported as aborted.
+  -abort-window number of latest results -abort-error-rate is computed over,
+             checked once that many results are in. Default is 100.
+  -ok-status status codes counted as successes, e.g. -ok-status 200-299,304.
+             Responses with other codes are counted as errors. Default is any.
+  -success condition responses must meet to be counted as successes, e.g.
+           -success 'status == 200 && body contains "ok" && latency < 300ms'
+           Compares status, size, latency and body, with &&, ||, ! and ( ).
+  -method-mix send methods picked at random by weight, e.g. -method-mix GET:80,POST:20,
+              reporting count, error rate and p95 for each method. Only with -url.
+  -method-d body of a method of -method-mix, e.g. -method-d 'POST:{"a": 1}'.
+            Repeatable. Other methods send the -d or -D body.
+  -schema send a new random JSON body valid against the JSON Schema of this
+          file with each request, e.g. to fuzz the validation of an API. It
+          supports type, properties, required, items, enum, const, anyOf,
+          oneOf, allOf, local $ref and the bounds of strings, numbers and
+          arrays; pattern is ignored. The nesting and sizes are capped. The
+          Content-Type is application/json unless -T is given.
+  -seed seed of the random choices, like the -schema bodies, the
+        -method-mix methods, the -har-random and -requests-random requests,
+        the -shuffle-headers orders, the -start-jitter delays and the -fault
+        faults. The same seed makes the same choices
+        in the same order. Default is random.
+  -rotate-header cycle the value of a header, one per request, e.g.
+                 -rotate-header 'X-Api-Key: key1,key2,key3', reporting count,
+                 error rate and p95 for each value.
   -respcheck check response body, like -respcheck "\"code\":201" -respcheck "\"msg\":\"good\""
+  -fault inject synthetic faults for testing, e.g. -fault "delay=200ms:0.1,error=0.05"
+         delays 10%% of the requests by 200ms and fails 5%% without sending them.
+         Results are marked as synthetic in the summary.
+  -requests-file replay the requests of a file, one json object per line, e.g.
+       {"method": "POST", "url": "https://host/", "headers": {"Content-Type":
+       "application/json"}, "body": "{}"}, in turn. The header flags (-H, -H-file,
+       -A, -T, -U, -no-ua) override their headers, -a and -host apply too. A
+       "Host" header sets the Host of the request.
+  -requests-random pick the requests of -requests-file at random instead of in turn
+  -requests-once send each request of -requests-file or -har exactly once, in the
+       order of the file, the workers taking the next one in turn. -n is the
+       number of requests of the file.
+  -stream-stdin send the requests read from stdin as they arrive, one json object
+       per line as in -requests-file, each exactly once, by the first of the -c
+       workers free, until EOF or -z, e.g. to mirror live traffic. Invalid
+       lines are reported and skipped. The flags apply as with -requests-file.
+  -replay replay the requests of a file at their recorded timing, e.g. from an
+       access log. Each line has the offset of the request from the start, like
+       1.5s, a tab, and the request as in -requests-file, the flags applying
+       the same way. The summary reports how late the requests were sent.
+  -agent serve as an agent on the address, e.g. -agent :7000, running the
+         tests sent by a coordinator. The agent is not authenticated, anyone
+         reaching the address can run tests from it: only listen on a
+         trusted network.
+  -coordinator run the test on the comma separated agents at once, e.g.
+         -coordinator host1:7000,host2:7000, and print the merged summary.
+         Each agent runs the whole -n and -c. Only with -url or -curl, the
+         flags setting the request (-m, -H, -H-file, -A, -T, -a, -host, -U,
+         -no-ua, -d, -D), -n, -c, -q, -burst, -z, -t, -h2, the -disable
+         flags and -o other than csv and influx.
+  -oauth2-token-url token endpoint of the OAuth2 client credentials flow. The
+       token is fetched before the run, refreshed when it expires, and sent
+       in the Authorization header. Needs the two flags below.
+  -oauth2-client-id OAuth2 client id.
+  -oauth2-client-secret OAuth2 client secret.
+  -ntlm NTLM authentication, [domain\]username:password. Each worker is pinned
+        to its own connection, can't use with -q or -h2.
+
+Interactive controls:
+  When run on a terminal, type p and Enter to pause the workers, r to resume
+  them and s to print a snapshot of the results so far. The time paused is
+  left out of the run duration and of -z, which is extended by it.
+
+Exit status:
+  0  the test ran, see the summary
+  1  hey failed, e.g. a file couldn't be read
+  2  usage error
+  3  SLO violation, reserved for SLO checks
+  4  responses failed -respcheck
+  5  all requests failed without a response
+  6  -verify-only found an invalid certificate
+  7  the run was aborted by -abort-error-rate or interrupted by Ctrl-C
 `
 
 func main() {
@@ -131,6 +585,8 @@ func main() {
 	rc := make(respCheck, 0)
 	flag.Var(&rc, "respcheck", "")
 
+	flag.Var(mixBodies, "method-d", "")
+
 	flag.Parse()
 
 	// 没有 <url> 的，现已经切换为-url 不需要此处逻辑
@@ -142,37 +598,90 @@ func main() {
 		usageAndExit("")
 	}
 
+	if *agentAddr != "" {
+		runAgent(*agentAddr)
+	}
+
 	runtime.GOMAXPROCS(*cpus)
 	num := *n
 	conc := *c
 	q := *q
 	dur := *z
 
-	if dur > 0 { //当有 -z的时候，-n失效，会默认给一个极大值2147483647
-		num = math.MaxInt32
-		if conc <= 0 {
-			usageAndExit("-c cannot be smaller than 1.")
+	if err := validateFlags(); err != nil {
+		usageAndExit(err.Error())
+	}
+	if *quiet {
+		info.SetOutput(ioutil.Discard)
+	}
+	if *portRange != "" {
+		if _, _, err := parsePortRange(*portRange); err != nil {
+			usageAndExit(err.Error())
 		}
-	} else {
-		if num <= 0 || conc <= 0 {
-			usageAndExit("-n and -c cannot be smaller than 1.")
+	}
+	if *coordinator == "" {
+		if err := checkFileLimit(conc); err != nil {
+			if *strict {
+				errAndExit(err.Error())
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v.\n", err)
 		}
-
-		if num < conc {
-			usageAndExit("-n cannot be less than -c.")
+	}
+	if dur > 0 && !isFlagSet("n") { //当有 -z的时候，未指定-n则默认给一个极大值2147483647；指定了-n则作为上限，时间或次数先到者结束
+		num = math.MaxInt32
+	}
+	if *nSuccess > 0 && !isFlagSet("n") {
+		num = math.MaxInt32
+	}
+	if dur > 0 {
+		if isFlagSet("n") {
+			info.Printf("Running for %v or %d requests, whichever comes first.", dur, num)
+		} else {
+			info.Printf("Running for %v, without a limit on the number of requests.", dur)
 		}
 	}
 
 	// url := flag.Args()[0]
 	method := strings.ToUpper(*m)
 
+	var curl *curlRequest
+	if *curlCmd != "" {
+		var err error
+		if curl, err = parseCurl(*curlCmd); err != nil {
+			usageAndExit(err.Error())
+		}
+		if *round > 1 && curl.method != "GET" {
+			usageAndExit("-r can only be used with -m GET.")
+		}
+		method, *url = curl.method, curl.url
+		// -H flags override the headers of the curl command
+		hs = append(headerSlice(curl.headers), hs...)
+	}
+
 	// set content-type
 	header := make(http.Header)
 	header.Set("Content-Type", *contentType)
+	if *schemaFile != "" && !isFlagSet("T") {
+		header.Set("Content-Type", "application/json")
+	}
+	// the headers given by the flags, overriding the ones of the requests
+	// read from files, see requestFlags
+	given := map[string]bool{"Content-Type": isFlagSet("T")}
 	// set any other additional headers
 	// if *headers != "" {
 	// 	usageAndExit("Flag '-h' is deprecated, please use '-H' instead.")
 	// }
+	// headers from file first, so -H can override them
+	if *headerFile != "" {
+		fileHeader := make(http.Header)
+		if err := readHeaderFile(*headerFile, fileHeader); err != nil {
+			usageAndExit(err.Error())
+		}
+		for k, v := range fileHeader {
+			header[k] = v
+			given[k] = true
+		}
+	}
 	// set any other additional repeatable headers
 	for _, h := range hs {
 		match, err := parseInputWithRegexp(h, headerRegexp)
@@ -180,10 +689,37 @@ func main() {
 			usageAndExit(err.Error())
 		}
 		header.Set(match[1], match[2])
+		given[http.CanonicalHeaderKey(match[1])] = true
 	}
 
 	if *accept != "" {
 		header.Set("Accept", *accept)
+		given["Accept"] = true
+	}
+
+	ua := header.Get("User-Agent")
+	if ua == "" {
+		ua = heyUA
+	} else {
+		ua += " " + heyUA
+	}
+	header.Set("User-Agent", ua)
+
+	// set userAgent header if set
+	if *userAgent != "" {
+		ua = *userAgent + " " + heyUA
+		header.Set("User-Agent", ua)
+		given["User-Agent"] = true
+	}
+
+	if *noUA {
+		if *userAgent != "" {
+			fmt.Fprintln(os.Stderr, "Warning: -no-ua is set, ignoring -U.")
+		}
+		// an empty value stops net/http from adding its default User-Agent,
+		// deleting the key alone isn't enough
+		header["User-Agent"] = []string{""}
+		given["User-Agent"] = true
 	}
 
 	// set basic auth if set
@@ -195,6 +731,106 @@ func main() {
 		}
 		username, password = match[1], match[2]
 	}
+	if curl != nil && curl.user != "" {
+		match, err := parseInputWithRegexp(curl.user, authRegexp)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		username, password = match[1], match[2]
+	}
+	// templated credentials are set on each request by the Work
+	if strings.Contains(username+password, seqToken) || (*randmark != "" && strings.Contains(username+password, *randmark)) {
+		basicAuthUser, basicAuthPassword = username, password
+		username, password = "", ""
+	}
+
+	rf := &requestFlags{header: header, given: given, username: username, password: password, host: *hostHeader}
+
+	if *ntlm != "" {
+		match, err := parseInputWithRegexp(*ntlm, authRegexp)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		ntlmUser, ntlmPassword = match[1], match[2]
+	}
+
+	if *okStatus != "" {
+		var err error
+		if okStatusFunc, err = parseStatusList(*okStatus); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	if *rotateHeader != "" {
+		var err error
+		if rotation, err = parseHeaderRotation(*rotateHeader); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	if *methodMixSpec != "" {
+		var err error
+		if mix, err = parseMethodMix(*methodMixSpec); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	if *schemaFile != "" {
+		var err error
+		if schema, err = loadSchema(*schemaFile); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	if *oauth2TokenURL != "" {
+		cc := &clientcredentials.Config{
+			ClientID:     *oauth2ClientID,
+			ClientSecret: *oauth2Secret,
+			TokenURL:     *oauth2TokenURL,
+		}
+		tokenSource = cc.TokenSource(context.Background())
+		// fail before the run on bad credentials
+		if _, err := tokenSource.Token(); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	if *summaryTmplFile != "" {
+		text, err := ioutil.ReadFile(*summaryTmplFile)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		if summaryTmpl, err = requester.ParseSummaryTemplate(string(text)); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	if *abortErrorRate != "" {
+		var err error
+		if abortRate, err = parsePercent(*abortErrorRate); err != nil {
+			usageAndExit("-abort-error-rate: " + err.Error())
+		}
+	}
+
+	if *success != "" {
+		var err error
+		if successCond, err = requester.ParsePredicate(*success); err != nil {
+			usageAndExit("-success: " + err.Error())
+		}
+	}
+
+	if *rangeSpec != "" {
+		var err error
+		if byteRange, err = requester.ParseByteRange(*rangeSpec); err != nil {
+			usageAndExit("-range: " + err.Error())
+		}
+	}
+
+	if *faultSpec != "" {
+		var err error
+		if fault, err = parseFault(*faultSpec); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
 
 	var bodyAll string
 	if *body != "" {
@@ -207,16 +843,142 @@ func main() {
 		}
 		bodyAll = string(slurp)
 	}
+	if curl != nil {
+		bodyAll = curl.body
+	}
+	if *repeatSpec != "" {
+		br, err := parseBodyRepeat(*repeatSpec)
+		var repeated string
+		if err == nil {
+			repeated, err = br.apply(bodyAll)
+		}
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		if *randmark != "" && *repeatRandmark == "before" {
+			// repeated for each request, once the randmark is replaced
+			repeatBody = func(body string) string {
+				body, _ = br.apply(body)
+				return body
+			}
+		} else {
+			bodyAll = repeated
+		}
+	}
+	if *bodyStream != "" {
+		// fail now rather than on every request
+		f, _, err := openStream(*bodyStream)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		f.Close()
+	}
+
+	if *harFile != "" {
+		var err error
+		if replayReqs, replayBodies, err = loadHAR(*harFile, *harFilter, rf); err != nil {
+			usageAndExit(err.Error())
+		}
+		replayRandom = *harRandom
+	}
+	if *requestsFile != "" {
+		var err error
+		if replayReqs, replayBodies, err = loadRequestsFile(*requestsFile, rf); err != nil {
+			usageAndExit(err.Error())
+		}
+		replayRandom = *requestsRandom
+	}
+	if *requestsOnce {
+		num = len(replayReqs)
+	}
+	if *streamStdin {
+		first, stream, err := streamRequests(os.Stdin, rf)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		replayReqs = []*http.Request{first.Request}
+		replayBodies = []string{first.Body}
+		replayStream = stream
+	}
+	if *replayFile != "" {
+		var err error
+		if replaySchedule, err = loadReplayFile(*replayFile, rf); err != nil {
+			usageAndExit(err.Error())
+		}
+		replayReqs = []*http.Request{replaySchedule[0].Request}
+		replayBodies = []string{replaySchedule[0].Body}
+		num = len(replaySchedule)
+	}
 
 	var proxyURL *gourl.URL
 	if *proxyAddr != "" {
 		var err error
-		proxyURL, err = gourl.Parse(*proxyAddr)
+		proxyURL, err = parseProxy(*proxyAddr, *proxyAuth)
 		if err != nil {
 			usageAndExit(err.Error())
 		}
 	}
 
+	if *verifyOnly {
+		urls := []string{*url}
+		if *hosts != "" {
+			var err error
+			if urls, err = hostURLs(*url, splitList(*hosts)); err != nil {
+				usageAndExit(err.Error())
+			}
+		}
+		if *urlFile != "" {
+			var err error
+			if urls, err = readURLFile(*urlFile); err != nil {
+				errAndExit(err.Error())
+			}
+		}
+		var certs []tls.Certificate
+		if *certfile != "" && *keyfile != "" {
+			cert, err := tls.LoadX509KeyPair(*certfile, *keyfile)
+			if err != nil {
+				errAndExit(err.Error())
+			}
+			certs = append(certs, cert)
+		}
+		// -host is sent as SNI, as in the test
+		serverName := *hostHeader
+		if h, _, err := net.SplitHostPort(serverName); err == nil {
+			serverName = h
+		}
+		if !verifyTLS(os.Stdout, urls, serverName, conc, certs, time.Duration(*t)*time.Second) {
+			exitCode = exitTLS
+		}
+		os.Exit(exitCode)
+	}
+
+	if *coordinator != "" {
+		req := newRequest(method, *url, bodyAll, header, username, password)
+		coordinate(splitList(*coordinator), runSpec{
+			Method:             method,
+			URL:                *url,
+			Header:             req.Header,
+			Host:               req.Host,
+			Body:               bodyAll,
+			N:                  num,
+			C:                  conc,
+			QPS:                q,
+			Burst:              *burst,
+			Duration:           dur,
+			Timeout:            *t,
+			H2:                 *h2,
+			DisableCompression: *disableCompression,
+			DisableKeepAlives:  *disableKeepAlives,
+			DisableRedirects:   *disableRedirects,
+		})
+		os.Exit(exitCode)
+	}
+
+	// keyboard controls, only when someone is typing
+	if isTerminal(os.Stdin) && isTerminal(os.Stderr) && !*streamStdin {
+		go readControls(os.Stdin, os.Stderr)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	brk := false
@@ -230,8 +992,8 @@ func main() {
 			default:
 				jobFunc(method, *url, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &rc)
 				if *round > 1 {
-					fmt.Printf("Finished Round: %v, start to sleep:%v second\n", r+1, *roundsleep)
-					fmt.Println("---------------------------------")
+					info.Printf("Finished Round: %v, start to sleep:%v second", r+1, *roundsleep)
+					info.Println("---------------------------------")
 				}
 			}
 			if brk {
@@ -240,36 +1002,78 @@ func main() {
 			time.Sleep(time.Duration(*roundsleep) * time.Second)
 		}
 	}
+	os.Exit(exitCode)
 }
 
 func jobFunc(method string, url string, bodyAll string, header http.Header, username, password string, num, conc int, q float64, proxyURL *gourl.URL, dur time.Duration, rc *respCheck) {
+	if replayReqs != nil {
+		runRequests(replayReqs, replayBodies, replayRandom, num, conc, q, proxyURL, dur, rc)
+		return
+	}
 	wg := sync.WaitGroup{}
 	if *urlFile == "" {
+		urls := []string{url}
+		if *portRange != "" {
+			// validated in main
+			first, last, _ := parsePortRange(*portRange)
+			var err error
+			if urls, err = portURLs(url, first, last); err != nil {
+				usageAndExit(err.Error())
+			}
+		}
+		if *hosts != "" {
+			var err error
+			if urls, err = hostURLs(url, splitList(*hosts)); err != nil {
+				usageAndExit(err.Error())
+			}
+		}
 		wg.Add(1)
-		go requestFunc(method, url, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &wg, rc)
+		go requestFunc(method, urls, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &wg, rc)
 		wg.Wait()
 	} else {
-		data, err := ioutil.ReadFile(*urlFile)
+		urls, err := readURLFile(*urlFile)
 		if err != nil {
 			errAndExit(fmt.Sprintf("---read fail: %s", err.Error()))
 		}
-		for _, line := range strings.Split(string(data), "\n") {
-			if !strings.Contains(line, "http") { //处理空行和换行符
-				continue
-			}
+		if *perURL {
+			// 所有url在同一个测试中轮流访问，汇总后按url分别统计
 			wg.Add(1)
-			go requestFunc(method, line, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &wg, rc)
+			go requestFunc(method, urls, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &wg, rc)
+		} else {
+			for _, u := range urls {
+				wg.Add(1)
+				go requestFunc(method, []string{u}, bodyAll, header, username, password, num, conc, q, proxyURL, dur, &wg, rc)
+			}
 		}
 		wg.Wait()
 	}
 }
 
-func requestFunc(method string, url string, bodyAll string, header http.Header, username, password string, num, conc int, q float64, proxyURL *gourl.URL, dur time.Duration, waitg *sync.WaitGroup, rc *respCheck) {
+// readURLFile returns the urls listed in file, one per line.
+func readURLFile(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.Contains(line, "http") { //处理空行和换行符
+			continue
+		}
+		urls = append(urls, strings.TrimSpace(line))
+	}
+	return urls, nil
+}
+
+// newRequest builds the request sent to url. The header is copied, so
+// requests built from the same header can be modified independently.
+func newRequest(method, url, bodyAll string, header http.Header, username, password string) *http.Request {
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
 		usageAndExit(err.Error())
 	}
 	req.ContentLength = int64(len(bodyAll))
+	req.Header = header.Clone()
 	if username != "" || password != "" {
 		req.SetBasicAuth(username, password)
 	}
@@ -278,74 +1082,314 @@ func requestFunc(method string, url string, bodyAll string, header http.Header,
 	if *hostHeader != "" {
 		req.Host = *hostHeader
 	}
+	return req
+}
 
-	ua := header.Get("User-Agent")
-	if ua == "" {
-		ua = heyUA
-	} else {
-		ua += " " + heyUA
+// requestFunc runs the test against urls. Multiple urls are requested in
+// turn within the same test.
+func requestFunc(method string, urls []string, bodyAll string, header http.Header, username, password string, num, conc int, q float64, proxyURL *gourl.URL, dur time.Duration, waitg *sync.WaitGroup, rc *respCheck) {
+	defer waitg.Done()
+	reqs := make([]*http.Request, len(urls))
+	bodies := make([]string, len(urls))
+	for i, u := range urls {
+		reqs[i] = newRequest(method, u, bodyAll, header, username, password)
+		bodies[i] = bodyAll
 	}
-	header.Set("User-Agent", ua)
+	runRequests(reqs, bodies, false, num, conc, q, proxyURL, dur, rc)
+}
 
-	// set userAgent header if set
-	if *userAgent != "" {
-		ua = *userAgent + " " + heyUA
-		header.Set("User-Agent", ua)
+// runRequests runs the test sending reqs with the matching bodies, in turn,
+// or picked at random if random is set.
+func runRequests(reqs []*http.Request, bodies []string, random bool, num, conc int, q float64, proxyURL *gourl.URL, dur time.Duration, rc *respCheck) {
+	if *compareKeepAlive {
+		compareKeepAlives(func() *requester.Work {
+			return newWork(reqs, bodies, random, num, conc, q, proxyURL, rc)
+		}, dur)
+		return
 	}
+	runWork(newWork(reqs, bodies, random, num, conc, q, proxyURL, rc), dur)
+}
 
-	req.Header = header
-
+// newWork returns the test of reqs configured by the flags.
+func newWork(reqs []*http.Request, bodies []string, random bool, num, conc int, q float64, proxyURL *gourl.URL, rc *respCheck) *requester.Work {
 	w := &requester.Work{
-		Request:            req,
-		RequestBody:        bodyAll,
+		Request:            reqs[0],
+		RequestBody:        bodies[0],
 		N:                  num,
 		C:                  conc,
 		QPS:                q,
+		Burst:              *burst,
+		StartJitter:        *startJitter,
 		Timeout:            *t,
+		ResolveOnce:        *resolveOnce,
+		WaitReady:          *waitReady,
 		DisableCompression: *disableCompression,
 		DisableKeepAlives:  *disableKeepAlives,
+		IdleTimeout:        *idleTimeout,
+		ConnClose:          *connClose,
 		DisableRedirects:   *disableRedirects,
+		DisableResumption:  *noResumption,
+		ALPN:               splitList(*alpn),
+		ConnReuse:          *connReuse,
+		DistinctBodies:     *distinctBodies,
+		AcceptEncoding:     *acceptEncoding,
+		IdempotencyKey:     *idempotencyKey,
+		BodyDigest:         *bodyDigest,
+		RequireCompression: *requireCompression,
+		FailOnEmptyBody:    *failOnEmptyBody,
+		MaxInFlight:        *maxInFlight,
+		Hedge:              *hedge,
+		SendBuffer:         *sndbuf,
+		RecvBuffer:         *rcvbuf,
+		ShuffleHeaders:     *shuffleHeaders,
+		RawReadBytes:       *rawRead,
+		SlowRequests:       *slowestN,
+		KeepAuthOnRedirect: *keepAuthOnRedirect,
+		TraceRedirects:     *traceRedirects,
+		CountRedirectHops:  *countHops,
+		ValidateCache:      *validateCache,
+		CacheHeader:        *cacheHeader,
+		Range:              byteRange,
+		Replay:             replaySchedule,
 		H2:                 *h2,
 		ProxyAddr:          proxyURL,
 		Output:             *output,
+		Name:               *name,
 		Certfile:           *certfile,
 		Keyfile:            *keyfile,
 		RandMark:           *randmark,
+		RepeatBody:         repeatBody,
+		SeqMark:            seqMark(reqs, bodies),
+		BasicAuthUser:      basicAuthUser,
+		BasicAuthPassword:  basicAuthPassword,
 		RespCheck:          *rc,
+		OKStatus:           okStatusFunc,
+		Success:            successCond,
+		NSuccess:           *nSuccess,
+		AbortErrorRate:     abortRate,
+		AbortWindow:        *abortWindow,
+		SummaryTemplate:    summaryTmpl,
+		Quiet:              *quiet,
+		Color:              *color,
+		NTLMUser:           ntlmUser,
+		NTLMPassword:       ntlmPassword,
+		TokenSource:        tokenSource,
+		PerURL:             *perURL,
+		PerPort:            *portRange != "",
+		PerHost:            *hosts != "",
+		PerWorker:          *perWorker,
+		CSVHeaders:         splitList(*csvHeaders),
+		WarmConns:          *warmConns,
+		Fault:              fault,
+		DebugRuntime:       *debugRuntime,
+		CPUProfile:         *cpuProfile,
+		MemProfile:         *memProfile,
+		Fast:               *fast,
+		ParseServerTiming:  *parseServerTiming,
+		DropResults:        *resultsOverflow == "drop",
+		Reporters:          *reporters,
+		Exact:              *exact,
+		HDRFile:            *hdrFile,
+		InfluxURL:          *influxURL,
 	}
+	if *seed != 0 {
+		w.Rand = requester.NewRand(*seed)
+	} else {
+		w.Rand = requester.NewRand(time.Now().UnixNano())
+	}
+	if *raw {
+		w.Raw = []byte(bodies[0])
+	}
+	if replayStream != nil {
+		w.Stream = replayStream
+	}
+	if *requestsOnce {
+		for i, r := range reqs {
+			w.Corpus = append(w.Corpus, requester.Prepared{Request: r, Body: bodies[i]})
+		}
+	}
+	if mix != nil {
+		w.PerMethod = true
+		w.RequestFunc = func() *http.Request {
+			r := reqs[0].Clone(context.Background())
+			r.Method = mix.pick(w.Rand)
+			body, ok := mixBodies[r.Method]
+			if !ok {
+				body = bodies[0]
+			}
+			r.ContentLength = int64(len(body))
+			if body != "" {
+				r.Body = ioutil.NopCloser(strings.NewReader(body))
+			}
+			return r
+		}
+	}
+	if len(reqs) > 1 {
+		var next uint64
+		w.RequestFunc = func() *http.Request {
+			i := int((atomic.AddUint64(&next, 1) - 1) % uint64(len(reqs)))
+			if random {
+				i = w.Rand.Intn(len(reqs))
+			}
+			r := reqs[i].Clone(context.Background())
+			if bodies[i] != "" {
+				r.Body = ioutil.NopCloser(strings.NewReader(bodies[i]))
+			}
+			return r
+		}
+	}
+	if schema != nil {
+		gen := schema.withRand(w.Rand)
+		// the requests of -hosts and -port-range are still taken in turn
+		next := w.RequestFunc
+		w.RequestFunc = func() *http.Request {
+			var r *http.Request
+			if next != nil {
+				r = next()
+			} else {
+				r = reqs[0].Clone(context.Background())
+			}
+			body := gen.generate()
+			r.ContentLength = int64(len(body))
+			r.Body = ioutil.NopCloser(strings.NewReader(body))
+			return r
+		}
+	}
+	if *bodyStream != "" {
+		w.StreamBody = true
+		w.RequestFunc = func() *http.Request {
+			return streamRequest(reqs[0], *bodyStream)
+		}
+	}
+	if rotation != nil {
+		next := w.RequestFunc
+		if next == nil {
+			next = func() *http.Request {
+				r := reqs[0].Clone(context.Background())
+				if bodies[0] != "" {
+					r.Body = ioutil.NopCloser(strings.NewReader(bodies[0]))
+				}
+				return r
+			}
+		}
+		w.PerHeader = rotation.name
+		w.RequestFunc = func() *http.Request {
+			r := next()
+			rotation.set(r)
+			return r
+		}
+	}
+	return w
+}
+
+// runWork runs w, for dur at most if it's not 0.
+func runWork(w *requester.Work, dur time.Duration) {
 	// 初始化results 和stopCh
 	w.Init()
 
 	// 处理用户终止ctrl-c，调用stop
-	userKill(w)
+	stopKill := userKill(w)
+	defer stopKill()
 
-	// 与-n 次数互斥，为运行的时间到了之后的handle
-	if dur > 0 {
-		go func() {
-			time.Sleep(dur)
-			w.Stop()
-		}()
-	}
+	// 等待目标就绪，不计入-z的时间
+	w.WaitUntilReady()
+
+	// 运行的时间，与-n 次数先到者结束，不计入等待就绪的时间
+	w.Duration = dur
 
+	setRunning(w, true)
 	w.Run()
+	setRunning(w, false)
+	recordOutcome(w.Summary())
+}
 
-	defer waitg.Done()
+// seqMark returns seqToken if it's used by any of the requests.
+func seqMark(reqs []*http.Request, bodies []string) string {
+	if strings.Contains(basicAuthUser+basicAuthPassword, seqToken) {
+		return seqToken
+	}
+	for i, r := range reqs {
+		// the url is checked unescaped
+		if strings.Contains(r.URL.Host+r.URL.Path+r.URL.RawQuery+r.Host+bodies[i], seqToken) {
+			return seqToken
+		}
+		for _, v := range r.Header {
+			if strings.Contains(strings.Join(v, ""), seqToken) {
+				return seqToken
+			}
+		}
+	}
+	return ""
 }
 
-func userKill(w *requester.Work) {
-	// 处理用户终止ctrl-c，调用stop
+// recordOutcome sets the exit code from the summary of a test, keeping the
+// worst of the tests run.
+func recordOutcome(s *requester.Summary) {
+	code := 0
+	switch {
+	case s.Aborted != "" || s.Interrupted:
+		code = exitAborted
+	case s.NumRes > 0 && s.Errors == s.NumRes:
+		code = exitAllFailed
+	case s.RespCheckFailures > 0:
+		code = exitRespCheck
+	}
+	exitMu.Lock()
+	if code > exitCode {
+		exitCode = code
+	}
+	exitMu.Unlock()
+}
+
+// userKill interrupts w on Ctrl-C, still printing the summary of the
+// requests made so far. A second Ctrl-C exits without waiting for the
+// requests in flight. The returned function stops watching for Ctrl-C.
+func userKill(w *requester.Work) func() {
+	// 处理用户终止ctrl-c，调用Interrupt
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
+	done := make(chan struct{})
 	go func() {
-		<-c
-		w.Stop()
+		select {
+		case <-c:
+		case <-done:
+			return
+		}
+		w.Interrupt()
+		select {
+		case <-c:
+			fmt.Fprintln(os.Stderr, "Interrupted again, exiting without a summary.")
+			os.Exit(exitAborted)
+		case <-done:
+		}
 	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// parseProxy parses the proxy address of -x, with the user:pass of
+// -proxy-auth if set. The transport sends them in Proxy-Authorization, for
+// both the proxied requests and the CONNECT of https ones, and uses them
+// with socks5 proxies.
+func parseProxy(addr, auth string) (*gourl.URL, error) {
+	u, err := gourl.Parse(addr)
+	if err != nil || auth == "" {
+		return u, err
+	}
+	match, err := parseInputWithRegexp(auth, authRegexp)
+	if err != nil {
+		return nil, err
+	}
+	u.User = gourl.UserPassword(match[1], match[2])
+	return u, nil
 }
 
 func errAndExit(msg string) {
 	fmt.Fprintf(os.Stderr, msg)
 	fmt.Fprintf(os.Stderr, "\n")
-	os.Exit(1)
+	os.Exit(exitError)
 }
 
 func usageAndExit(msg string) {
@@ -355,7 +1399,7 @@ func usageAndExit(msg string) {
 	}
 	flag.Usage()
 	fmt.Fprintf(os.Stderr, "\n")
-	os.Exit(1)
+	os.Exit(exitUsage)
 }
 
 func parseInputWithRegexp(input, regx string) ([]string, error) {
@@ -367,6 +1411,342 @@ func parseInputWithRegexp(input, regx string) ([]string, error) {
 	return matches, nil
 }
 
+// readHeaderFile sets the headers listed in file, one "Name: Value" per line.
+// Blank lines are skipped.
+func readHeaderFile(file string, header http.Header) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		match, err := parseInputWithRegexp(line, headerRegexp)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		header.Set(match[1], match[2])
+	}
+	return nil
+}
+
+// validateFlags checks the flags for values and combinations that can't be
+// used, and returns an error describing the first one found.
+func validateFlags() error {
+	if *c <= 0 {
+		return errors.New("-c cannot be smaller than 1.")
+	}
+	// -z 0 would otherwise silently mean no duration at all
+	if *z < 0 || *z == 0 && isFlagSet("z") {
+		return errors.New("-z must be a positive duration.")
+	}
+	// -n is unbounded in -z and -n-success modes unless given, unused with
+	// -stream-stdin
+	if (*z <= 0 && *nSuccess <= 0 || isFlagSet("n")) && !*streamStdin {
+		if *n <= 0 {
+			return errors.New("-n cannot be smaller than 1.")
+		}
+		if *q <= 0 && *n < *c {
+			return errors.New("-n cannot be less than -c.")
+		}
+	}
+	if err := exclusiveFlags(requestSources); err != nil {
+		return err
+	}
+	if err := exclusiveFlags(bodySources); err != nil {
+		return err
+	}
+	for _, b := range usedFlags(bodySources) {
+		for _, r := range usedFlags(fullRequestSources) {
+			// the -schema bodies replace the one of -curl
+			if b != "schema" || r != "curl" {
+				return fmt.Errorf("-%s cannot be used with -%s, which gives the bodies.", b, r)
+			}
+		}
+	}
+	switch {
+	case len(usedFlags(requestSources)) == 0:
+		return errors.New("-url, -urlfile, -curl, -har, -requests-file, -replay or -stream-stdin is required.")
+	case isFlagSet("m") && len(usedFlags(fullRequestSources)) > 0:
+		return errors.New("-m cannot be used with -curl, -har, -requests-file, -replay or -stream-stdin.")
+	case *randmark != "" && (*harFile != "" || *requestsFile != "" || *replayFile != "" || *streamStdin):
+		return errors.New("-randmark cannot be used with -har, -requests-file, -replay or -stream-stdin.")
+	case *curlCmd != "" && *authHeader != "":
+		return errors.New("-curl cannot be used with -a.")
+	case (*harFilter != "" || *harRandom) && *harFile == "":
+		return errors.New("-har-filter and -har-random require -har.")
+	case *requestsRandom && *requestsFile == "":
+		return errors.New("-requests-random requires -requests-file.")
+	case *proxyAuth != "" && *proxyAddr == "":
+		return errors.New("-proxy-auth requires -x.")
+	case *sndbuf < 0 || *rcvbuf < 0:
+		return errors.New("-sndbuf and -rcvbuf cannot be negative.")
+	case *shuffleHeaders && (*h2 || *alpn != "" || *proxyAddr != "" || *warmConns):
+		return errors.New("-shuffle-headers cannot be used with -h2, -alpn, -x or -warm-conns.")
+	case *raw && (*url == "" || *body == "" && *bodyFile == ""):
+		return errors.New("-raw requires -url and -d or -D.")
+	case *raw && (*h2 || *proxyAddr != "" || *fast || *warmConns || *hedge > 0 || *shuffleHeaders || *randmark != "" || *bodyStream != "" || *methodMixSpec != "" || *portRange != ""):
+		return errors.New("-raw cannot be used with -h2, -x, -fast, -warm-conns, -hedge, -shuffle-headers, -randmark, -D-stream, -method-mix or -port-range.")
+	case *rawRead < 0 || *rawRead > 0 && !*raw:
+		return errors.New("-raw-read cannot be negative and requires -raw.")
+	case *hedge < 0 || *hedge > 0 && (*ntlm != "" || *warmConns):
+		return errors.New("-hedge cannot be negative or used with -ntlm or -warm-conns.")
+	case *hosts != "" && (*url == "" || *portRange != "" || *raw || *methodMixSpec != "" || *bodyStream != ""):
+		return errors.New("-hosts requires -url, and cannot be used with -port-range, -raw, -method-mix or -D-stream.")
+	case *portRange != "" && (*urlFile != "" || *harFile != "" || *requestsFile != "" || *replayFile != "" || *methodMixSpec != "" || *bodyStream != ""):
+		return errors.New("-port-range cannot be used with -urlfile, -har, -requests-file, -replay, -method-mix or -D-stream.")
+	case *requestsOnce && (*requestsFile == "" && *harFile == "" || *requestsRandom || *harRandom):
+		return errors.New("-requests-once requires -requests-file or -har, without -requests-random or -har-random.")
+	case *requestsOnce && (isFlagSet("n") || *nSuccess > 0 || *rotateHeader != ""):
+		return errors.New("-requests-once cannot be used with -n, -n-success or -rotate-header.")
+	case *replayFile != "" && (*q > 0 || *round > 1 || *methodMixSpec != "" || *validateCache || *compareKeepAlive):
+		return errors.New("-replay cannot be used with -q, -r, -method-mix, -validate-cache or -compare-keepalive.")
+	case *streamStdin && (isFlagSet("n") || *nSuccess > 0 || *q > 0 || *round > 1 || *requestsOnce || *methodMixSpec != "" || *compareKeepAlive || *raw || *verifyOnly):
+		return errors.New("-stream-stdin cannot be used with -n, -n-success, -q, -r, -requests-once, -method-mix, -compare-keepalive, -raw or -verify-only.")
+	case *q > 0 && isFlagSet("c"):
+		return errors.New("-c and -q cannot be used together.")
+	case *waitReady < 0:
+		return errors.New("-wait-ready cannot be negative.")
+	case *startJitter != 0 && (*q > 0 || *startJitter < 0):
+		return errors.New("-start-jitter cannot be used with -q or be negative.")
+	case isFlagSet("burst") && (*q <= 0 || *burst < 1):
+		return errors.New("-burst requires -q and cannot be smaller than 1.")
+	case *round > 1 && strings.ToUpper(*m) != "GET":
+		return errors.New("-r can only be used with -m GET.")
+	case *methodMixSpec != "" && (isFlagSet("m") || *urlFile != "" || *curlCmd != "" || *harFile != "" || *requestsFile != "" || *randmark != "" || *round > 1):
+		return errors.New("-method-mix cannot be used with -m, -urlfile, -curl, -har, -requests-file, -randmark or -r.")
+	case (*oauth2TokenURL != "" || *oauth2ClientID != "" || *oauth2Secret != "") && (*oauth2TokenURL == "" || *oauth2ClientID == ""):
+		return errors.New("-oauth2-token-url and -oauth2-client-id must be used together.")
+	case *oauth2TokenURL != "" && (*authHeader != "" || *ntlm != ""):
+		return errors.New("-oauth2-token-url cannot be used with -a or -ntlm.")
+	case *validateCache && (*q > 0 || *perURL || *harFile != "" || *requestsFile != ""):
+		return errors.New("-validate-cache cannot be used with -q, -per-url, -har or -requests-file.")
+	case *bodyStream != "" && (*urlFile != "" || *methodMixSpec != "" || *randmark != ""):
+		return errors.New("-D-stream cannot be used with -urlfile, -method-mix or -randmark.")
+	case (*acceptEncoding != "" || *requireCompression) && *disableCompression:
+		return errors.New("-accept-encoding and -require-compression cannot be used with -disable-compression.")
+	case *repeatSpec != "" && (*bodyStream != "" || *harFile != "" || *requestsFile != "" || *replayFile != "" || *methodMixSpec != ""):
+		return errors.New("-repeat-body cannot be used with -D-stream, -har, -requests-file, -replay or -method-mix.")
+	case *repeatRandmark != "before" && *repeatRandmark != "after":
+		return errors.New("-repeat-randmark must be before or after.")
+	case *bodyDigest != "" && *bodyDigest != "md5" && *bodyDigest != "sha256":
+		return errors.New("-body-digest must be md5 or sha256.")
+	case *schemaFile != "" && (*url == "" && *curlCmd == "" || *randmark != "" || *repeatSpec != "" || *methodMixSpec != "" || *raw):
+		return errors.New("-schema needs -url or -curl, and cannot be used with -randmark, -repeat-body, -method-mix or -raw.")
+	case *connClose && (*warmConns || *raw || *ntlm != ""):
+		return errors.New("-conn-close cannot be used with -warm-conns, -raw or -ntlm.")
+	case *perWorker && (*q > 0 || *replayFile != "" || *raw):
+		return errors.New("-per-worker-stats cannot be used with -q, -replay or -raw.")
+	case *rangeSpec != "" && *raw:
+		return errors.New("-range cannot be used with -raw.")
+	case *bodyDigest != "" && (*bodyStream != "" || *raw):
+		return errors.New("-body-digest cannot be used with -D-stream or -raw.")
+	case *color != "auto" && *color != "always" && *color != "never":
+		return errors.New("-color must be auto, always or never.")
+	case *alpn != "" && (*fast || *warmConns):
+		return errors.New("-alpn cannot be used with -fast or -warm-conns.")
+	case *slowestN < 0:
+		return errors.New("-slowest cannot be negative.")
+	case *maxInFlight < 0:
+		return errors.New("-max-inflight cannot be negative.")
+	case *nSuccess < 0 || *nSuccess > 0 && *replayFile != "":
+		return errors.New("-n-success cannot be negative or used with -replay.")
+	case *rotateHeader != "" && *replayFile != "":
+		return errors.New("-rotate-header cannot be used with -replay.")
+	case *quiet && *output == "":
+		return errors.New("-quiet requires -o.")
+	case *connReuse && *fast:
+		return errors.New("-conn-reuse cannot be used with -fast.")
+	case *abortWindow < 1:
+		return errors.New("-abort-window cannot be smaller than 1.")
+	case *verifyOnly && (*harFile != "" || *requestsFile != "" || *compareKeepAlive):
+		return errors.New("-verify-only cannot be used with -har, -requests-file, -coordinator or -compare-keepalive.")
+	case *coordinator != "" && uncarriedFlag() != "":
+		return fmt.Errorf("-%s cannot be used with -coordinator, the agents only get the request, -n, -c, -q, -burst, -z, -t, -h2 and the -disable flags.", uncarriedFlag())
+	case *coordinator != "" && (*output == "csv" || *output == "influx"):
+		return errors.New("-o csv and -o influx cannot be used with -coordinator.")
+	case *compareKeepAlive && (*disableKeepAlives || *warmConns || *output != "" || *exact):
+		return errors.New("-compare-keepalive cannot be used with -disable-keepalive, -warm-conns, -o or -exact.")
+	case *traceRedirects && *disableRedirects:
+		return errors.New("-trace-redirects cannot be used with -disable-redirects.")
+	case *countHops && *disableRedirects:
+		return errors.New("-count-redirect-hops cannot be used with -disable-redirects.")
+	case *resultsOverflow != "block" && *resultsOverflow != "drop":
+		return errors.New("-results-overflow must be block or drop.")
+	case *idleTimeout < 0 || *idleTimeout > 0 && (*disableKeepAlives || *warmConns):
+		return errors.New("-idle-timeout cannot be negative or used with -disable-keepalive or -warm-conns.")
+	case *reporters < 1:
+		return errors.New("-reporters cannot be less than 1.")
+	case *reporters > 1 && (*output == "csv" || *abortErrorRate != "" || *nSuccess > 0):
+		return errors.New("-reporters cannot be used with -o csv, -abort-error-rate or -n-success.")
+	case *ntlm != "" && (*q > 0 || *h2):
+		return errors.New("-ntlm cannot be used with -q or -h2.")
+	case *warmConns && (*q > 0 || *h2 || *proxyAddr != "" || *disableKeepAlives):
+		return errors.New("-warm-conns cannot be used with -q, -h2, -x or -disable-keepalive.")
+	}
+	return nil
+}
+
+// The groups of flags of which only one can be used.
+var (
+	// requestSources give the requests to send.
+	requestSources = []string{"url", "urlfile", "curl", "har", "requests-file", "replay", "stream-stdin"}
+	// bodySources give the bodies of the requests of -url or -urlfile.
+	bodySources = []string{"d", "D", "D-stream", "schema"}
+)
+
+// fullRequestSources are the requestSources giving the methods and bodies
+// of the requests too.
+var fullRequestSources = []string{"curl", "har", "requests-file", "replay", "stream-stdin"}
+
+// usedFlags returns the flags of names set to other than their default.
+func usedFlags(names []string) []string {
+	var used []string
+	for _, name := range names {
+		if f := flag.Lookup(name); f.Value.String() != f.DefValue {
+			used = append(used, name)
+		}
+	}
+	return used
+}
+
+// exclusiveFlags returns an error if more than one of names is used.
+func exclusiveFlags(names []string) error {
+	if used := usedFlags(names); len(used) > 1 {
+		return fmt.Errorf("-%s and -%s cannot be used together.", used[0], used[1])
+	}
+	return nil
+}
+
+// coordinatorFlags are the flags that -coordinator carries to its agents
+// in a runSpec, or uses itself.
+var coordinatorFlags = map[string]bool{
+	"m": true, "url": true, "curl": true, "H": true, "H-file": true,
+	"A": true, "T": true, "a": true, "host": true, "U": true, "no-ua": true,
+	"d": true, "D": true, "n": true, "c": true, "q": true, "burst": true,
+	"z": true, "t": true, "h2": true, "disable-compression": true,
+	"disable-keepalive": true, "disable-redirects": true, "o": true,
+	"cpus": true, "coordinator": true,
+}
+
+// uncarriedFlag returns the first flag set that -coordinator can't carry to
+// its agents, or "".
+func uncarriedFlag() string {
+	name := ""
+	flag.Visit(func(f *flag.Flag) {
+		if name == "" && !coordinatorFlags[f.Name] {
+			name = f.Name
+		}
+	})
+	return name
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseFault parses a fault injection spec like "delay=200ms:0.1,error=0.05",
+// adding 200ms to 10% of the requests and failing 5% of them.
+func parseFault(spec string) (*requester.Fault, error) {
+	f := &requester.Fault{}
+	for _, item := range splitList(spec) {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault %q", item)
+		}
+		var err error
+		switch kv[0] {
+		case "delay":
+			parts := strings.SplitN(kv[1], ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid fault %q, want delay=duration:rate", item)
+			}
+			if f.Delay, err = time.ParseDuration(parts[0]); err == nil {
+				f.DelayRate, err = parseRate(parts[1])
+			}
+		case "error":
+			f.ErrorRate, err = parseRate(kv[1])
+		default:
+			err = fmt.Errorf("unknown fault %q", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %v", item, err)
+		}
+	}
+	return f, nil
+}
+
+// parseStatusList parses a list of status codes and ranges of them, like
+// "200-299,304", into a function reporting whether a code is listed.
+func parseStatusList(s string) (func(code int) bool, error) {
+	type codeRange struct{ from, to int }
+	var ranges []codeRange
+	for _, item := range splitList(s) {
+		bounds := strings.SplitN(item, "-", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		to := from
+		if err == nil && len(bounds) == 2 {
+			to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		}
+		if err != nil || from < 100 || to > 999 || from > to {
+			return nil, fmt.Errorf("invalid status code or range %q", item)
+		}
+		ranges = append(ranges, codeRange{from, to})
+	}
+	return func(code int) bool {
+		for _, r := range ranges {
+			if code >= r.from && code <= r.to {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// parseRate parses a fraction between 0 and 1.
+func parseRate(s string) (float64, error) {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r < 0 || r > 1 {
+		return 0, fmt.Errorf("rate %v is not between 0 and 1", r)
+	}
+	return r, nil
+}
+
+// parsePercent parses a percentage like 50%, or a fraction between 0 and 1.
+func parsePercent(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, err
+		}
+		s = strconv.FormatFloat(p/100, 'g', -1, 64)
+	}
+	return parseRate(s)
+}
+
 type headerSlice []string
 
 func (h *headerSlice) String() string {
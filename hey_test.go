@@ -15,7 +15,24 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
 )
 
 func TestParseValidHeaderFlag(t *testing.T) {
@@ -64,3 +81,635 @@ func TestParseAuthMetaCharacters(t *testing.T) {
 		t.Errorf("Auth header with a plus sign in the user name errored: %v", err)
 	}
 }
+
+func TestParseFault(t *testing.T) {
+	f, err := parseFault("delay=200ms:0.1,error=0.05")
+	if err != nil {
+		t.Fatalf("parseFault errored: %v", err)
+	}
+	if f.Delay != 200*time.Millisecond || f.DelayRate != 0.1 || f.ErrorRate != 0.05 {
+		t.Errorf("got %+v; want 200ms delay at 0.1 and errors at 0.05", *f)
+	}
+	for _, spec := range []string{"delay=200ms", "error=2", "drop=0.1"} {
+		if _, err := parseFault(spec); err == nil {
+			t.Errorf("parseFault(%q) is expected to fail", spec)
+		}
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"-url", "http://localhost", "-n", "10", "-c", "2"}, true},
+		{[]string{"-n", "10", "-c", "2"}, false},
+		{[]string{"-url", "http://localhost", "-n", "1", "-c", "2"}, false},
+		{[]string{"-url", "http://localhost", "-q", "5", "-c", "2"}, false},
+		{[]string{"-url", "http://localhost", "-r", "2", "-m", "POST"}, false},
+		{[]string{"-url", "http://localhost", "-validate-cache", "-q", "5"}, false},
+		{[]string{"-url", "http://localhost", "-oauth2-client-id", "id"}, false},
+		{[]string{"-url", "http://localhost", "-method-mix", "GET:1", "-m", "POST"}, false},
+		{[]string{"-url", "http://localhost", "-urlfile", "urls.txt"}, false},
+		{[]string{"-url", "http://localhost", "-compare-keepalive", "-exact"}, false},
+		{[]string{"-url", "http://localhost", "-d", "a", "-D", "body.txt"}, false},
+		{[]string{"-curl", "curl http://localhost", "-D-stream", "body.txt"}, false},
+		{[]string{"-url", "http://localhost", "-coordinator", "h:7000", "-randmark", "X"}, false},
+		{[]string{"-url", "http://localhost", "-coordinator", "h:7000", "-o", "csv"}, false},
+		// last, since isFlagSet("z") still holds once restored
+		{[]string{"-url", "http://localhost", "-z", "10s", "-n", "1", "-c", "2"}, false},
+		{[]string{"-url", "http://localhost", "-z", "10s", "-n", "4", "-c", "2"}, true},
+		{[]string{"-url", "http://localhost", "-z", "-1s"}, false},
+	} {
+		flag.CommandLine.Parse(tt.args)
+		if err := validateFlags(); (err == nil) != tt.ok {
+			t.Errorf("validateFlags() with %v = %v; want ok %v", tt.args, err, tt.ok)
+		}
+		// restore the defaults, leaving the test flags alone
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				f.Value.Set(f.DefValue)
+			}
+		})
+	}
+}
+
+func TestReadHeaderFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "headers.txt")
+	ioutil.WriteFile(file, []byte("Accept: text/html\n\nX-Some: value\n"), 0644)
+
+	header := make(http.Header)
+	if err := readHeaderFile(file, header); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("X-Some"); got != "value" {
+		t.Errorf("X-Some header is expected to be value, %v is found", got)
+	}
+
+	ioutil.WriteFile(file, []byte("Accept: text/html\nbad header\n"), 0644)
+	err = readHeaderFile(file, header)
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("readHeaderFile() error = %v; want one naming line 2", err)
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	cr, err := parseCurl(`curl -sS -H 'Content-Type: application/json' -u "user:pw" \
+  -d '{"a": 1}' https://example.com/api`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.method != "POST" || cr.url != "https://example.com/api" || cr.body != `{"a": 1}` || cr.user != "user:pw" {
+		t.Errorf("parseCurl() = %+v", cr)
+	}
+	if len(cr.headers) != 1 || cr.headers[0] != "Content-Type: application/json" {
+		t.Errorf("parseCurl() headers = %q", cr.headers)
+	}
+
+	cr, err = parseCurl(`curl -XPUT --url http://localhost/`)
+	if err != nil || cr.method != "PUT" || cr.url != "http://localhost/" {
+		t.Errorf("parseCurl() = %+v, %v; want PUT http://localhost/", cr, err)
+	}
+
+	if _, err := parseCurl(`curl --retry 3 http://localhost/`); err == nil {
+		t.Error("parseCurl() with an unsupported option is expected to fail")
+	}
+}
+
+func TestLoadHAR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "session.har")
+	ioutil.WriteFile(file, []byte(`{"log": {"entries": [
+		{"request": {"method": "GET", "url": "https://example.com/", "headers": [{"name": ":authority", "value": "example.com"}]}},
+		{"request": {"method": "POST", "url": "https://example.com/api/login",
+			"headers": [{"name": "Content-Type", "value": "application/json"}, {"name": "X-Some", "value": "har"}],
+			"postData": {"text": "{}"}}}
+	]}}`), 0644)
+
+	reqs, bodies, err := loadHAR(file, "/api/", &requestFlags{header: http.Header{"X-Some": {"flag"}}, given: map[string]bool{"X-Some": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 || reqs[0].Method != "POST" || bodies[0] != "{}" {
+		t.Fatalf("loadHAR() = %v, %q; want the POST request only", reqs, bodies)
+	}
+	if got := reqs[0].Header.Get("X-Some"); got != "flag" {
+		t.Errorf("X-Some header is expected to be overridden by -H, %v is found", got)
+	}
+	if got := reqs[0].Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type header is expected to be application/json, %v is found", got)
+	}
+}
+
+func TestLoadRequestsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "reqs.jsonl")
+	ioutil.WriteFile(file, []byte(`{"url": "http://localhost/"}
+
+{"method": "post", "url": "http://localhost/api", "headers": {"Content-Type": "application/json"}, "body": "{}"}
+`), 0644)
+
+	reqs, bodies, err := loadRequestsFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].Method != "GET" || reqs[1].Method != "POST" || bodies[1] != "{}" {
+		t.Fatalf("loadRequestsFile() = %v, %q", reqs, bodies)
+	}
+	if got := reqs[1].Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type header is expected to be application/json, %v is found", got)
+	}
+
+	// the flags apply to the requests too, the given headers overriding theirs
+	ioutil.WriteFile(file, []byte(`{"url": "http://localhost/", "headers": {"Content-Type": "application/json", "Accept": "text/plain", "User-Agent": "app", "Host": "spec.test"}}
+`), 0644)
+	rf := &requestFlags{
+		header:   http.Header{"Content-Type": {"text/html"}, "Accept": {"*/*"}, "User-Agent": {heyUA}, "X-Some": {"flag"}},
+		given:    map[string]bool{"Accept": true, "X-Some": true},
+		username: "user",
+		password: "pass",
+	}
+	reqs, _, err = loadRequestsFile(file, rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reqs[0]
+	if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+		t.Errorf("Basic auth is %q:%q; want user:pass", user, pass)
+	}
+	want := http.Header{"Content-Type": {"application/json"}, "Accept": {"*/*"}, "User-Agent": {"app " + heyUA}, "X-Some": {"flag"}, "Authorization": r.Header["Authorization"]}
+	if !reflect.DeepEqual(r.Header, want) || r.Host != "spec.test" {
+		t.Errorf("Got headers %v and host %q; want %v and spec.test", r.Header, r.Host, want)
+	}
+	rf.host = "flag.test"
+	if reqs, _, _ = loadRequestsFile(file, rf); reqs[0].Host != "flag.test" {
+		t.Errorf("Host is %q; want the one of -host, flag.test", reqs[0].Host)
+	}
+
+	ioutil.WriteFile(file, []byte(`{"url": "http://localhost/"}
+{"uri": "http://localhost/"}
+`), 0644)
+	_, _, err = loadRequestsFile(file, nil)
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("loadRequestsFile() error = %v; want one naming line 2", err)
+	}
+}
+
+func TestStreamRequests(t *testing.T) {
+	r, w := io.Pipe()
+	go w.Write([]byte(`{"url": "http://localhost/1"}` + "\n"))
+	first, stream, err := streamRequests(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Request.URL.Path != "/1" {
+		t.Errorf("First request is %v; want /1", first.Request.URL)
+	}
+	// the first request is read before the others are written
+	go func() {
+		w.Write([]byte(`{"uri": "http://localhost/"}` + "\n\n" + `{"method": "post", "url": "http://localhost/2", "body": "{}"}` + "\n"))
+		w.Close()
+	}()
+	var paths []string
+	for p := range stream {
+		paths = append(paths, p.Request.Method+" "+p.Request.URL.Path+" "+p.Body)
+	}
+	if want := []string{"GET /1 ", "POST /2 {}"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Streamed requests are %q; want %q", paths, want)
+	}
+
+	if _, _, err := streamRequests(strings.NewReader("\n"), nil); err == nil {
+		t.Error("streamRequests() of no request is expected to fail")
+	}
+}
+
+func TestRecordOutcome(t *testing.T) {
+	defer func() { exitCode = 0 }()
+	recordOutcome(&requester.Summary{NumRes: 10, RespCheckFailures: 1})
+	if exitCode != exitRespCheck {
+		t.Errorf("exitCode = %v; want %v", exitCode, exitRespCheck)
+	}
+	recordOutcome(&requester.Summary{NumRes: 10, Errors: 10})
+	recordOutcome(&requester.Summary{NumRes: 10})
+	if exitCode != exitAllFailed {
+		t.Errorf("exitCode = %v; want %v", exitCode, exitAllFailed)
+	}
+	recordOutcome(&requester.Summary{NumRes: 10, Errors: 6, Aborted: "error rate"})
+	if exitCode != exitAborted {
+		t.Errorf("exitCode = %v; want %v", exitCode, exitAborted)
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	for s, want := range map[string]float64{"50%": 0.5, "2.5%": 0.025, "0.3": 0.3} {
+		if got, err := parsePercent(s); err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("parsePercent(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"150%", "x%", "2"} {
+		if _, err := parsePercent(s); err == nil {
+			t.Errorf("parsePercent(%q) is expected to fail", s)
+		}
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	off := &requester.Summary{NumRes: 100, Total: time.Second}
+	on := &requester.Summary{NumRes: 150, Total: time.Second}
+	var buf bytes.Buffer
+	printComparison(&buf, off, on)
+	if !strings.Contains(buf.String(), "+50.00%") {
+		t.Errorf("Expected a +50.00%% delta of requests/sec:\n%s", buf.String())
+	}
+}
+
+func TestParseStatusList(t *testing.T) {
+	ok, err := parseStatusList("200-299, 304")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]bool{200: true, 204: true, 299: true, 304: true, 301: false, 503: false} {
+		if got := ok(code); got != want {
+			t.Errorf("ok(%d) = %v; want %v", code, got, want)
+		}
+	}
+	for _, s := range []string{"2xx", "299-200", "50"} {
+		if _, err := parseStatusList(s); err == nil {
+			t.Errorf("parseStatusList(%q) is expected to fail", s)
+		}
+	}
+}
+
+func TestPrintTLSChecks(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checks := []requester.TLSCheck{
+		{Addr: "a.example:443", ServerName: "a.example", Issuer: "CN=CA", NotAfter: now.Add(30 * 24 * time.Hour)},
+		{Addr: "b.example:443", ServerName: "c.example", Issuer: "CN=CA", NotAfter: now, NameMismatch: true, Err: errors.New("x509: certificate is valid for b.example, not c.example")},
+	}
+	var buf bytes.Buffer
+	if printTLSChecks(&buf, checks, now) {
+		t.Error("printTLSChecks() = true; want false with a failing check")
+	}
+	for _, want := range []string{
+		"a.example:443  a.example  OK            2026-01-31 (30 days)  issuer CN=CA",
+		"b.example:443  c.example  SNI MISMATCH  2026-01-01 (0 days)   x509: certificate is valid for b.example, not c.example",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Output is expected to contain %q:\n%s", want, buf.String())
+		}
+	}
+	if !printTLSChecks(ioutil.Discard, checks[:1], now) {
+		t.Error("printTLSChecks() = false; want true with valid checks only")
+	}
+}
+
+func TestMethodMix(t *testing.T) {
+	mix, err := parseMethodMix("get:80, POST:20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	rnd := requester.NewRand(1)
+	for i := 0; i < 10000; i++ {
+		counts[mix.pick(rnd)]++
+	}
+	if len(counts) != 2 || counts["GET"] < 7500 || counts["GET"] > 8500 {
+		t.Errorf("Picked methods %v; want about 8000 GET and 2000 POST", counts)
+	}
+	a, b := requester.NewRand(7), requester.NewRand(7)
+	for i := 0; i < 100; i++ {
+		if m, n := mix.pick(a), mix.pick(b); m != n {
+			t.Fatalf("Pick %d is %s and %s with the same seed", i, m, n)
+		}
+	}
+	for _, s := range []string{"", "GET", "GET:0", "GET:x,POST:1"} {
+		if _, err := parseMethodMix(s); err == nil {
+			t.Errorf("parseMethodMix(%q) is expected to fail", s)
+		}
+	}
+
+	mb := make(methodBodies)
+	if err := mb.Set(`post:{"a": 1}`); err != nil || mb["POST"] != `{"a": 1}` {
+		t.Errorf("Method bodies are %v, %v; want the POST body", mb, err)
+	}
+}
+
+func TestHeaderRotation(t *testing.T) {
+	hr, err := parseHeaderRotation("x-api-key: key1, key2,key3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 4; i++ {
+		r, _ := http.NewRequest("GET", "http://example.com", nil)
+		hr.set(r)
+		got = append(got, r.Header.Get("X-Api-Key"))
+	}
+	if want := []string{"key1", "key2", "key3", "key1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rotated values are %v; want %v", got, want)
+	}
+	for _, s := range []string{"", "X-Api-Key", ": a", "X-Api-Key: ,"} {
+		if _, err := parseHeaderRotation(s); err == nil {
+			t.Errorf("parseHeaderRotation(%q) is expected to fail", s)
+		}
+	}
+}
+
+func TestLoadReplayFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "replay.tsv")
+	ioutil.WriteFile(file, []byte("0.5\t{\"url\": \"https://example.com/b\"}\n\n"+
+		"100ms\t{\"method\": \"post\", \"url\": \"https://example.com/a\", \"body\": \"{}\"}\n"), 0644)
+
+	schedule, err := loadReplayFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule) != 2 {
+		t.Fatalf("Got %d requests; want 2", len(schedule))
+	}
+	first, second := schedule[0], schedule[1]
+	if first.Offset != 100*time.Millisecond || first.Request.Method != "POST" || first.Body != "{}" {
+		t.Errorf("First request is %v %s at %v; want POST /a at 100ms", first.Request.Method, first.Request.URL, first.Offset)
+	}
+	if second.Offset != 500*time.Millisecond || second.Request.URL.Path != "/b" {
+		t.Errorf("Second request is %s at %v; want /b at 500ms", second.Request.URL, second.Offset)
+	}
+
+	for _, data := range []string{"0.5 {\"url\": \"https://example.com/\"}", "-1s\t{\"url\": \"https://example.com/\"}", "1s\t{}"} {
+		ioutil.WriteFile(file, []byte(data), 0644)
+		if _, err := loadReplayFile(file, nil); err == nil {
+			t.Errorf("loadReplayFile is expected to fail on %q", data)
+		}
+	}
+}
+
+func TestStreamRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "upload.bin")
+	ioutil.WriteFile(file, []byte("large object"), 0644)
+
+	base, _ := http.NewRequest("PUT", "http://example.com", nil)
+	for i := 0; i < 2; i++ {
+		req := streamRequest(base, file)
+		data, _ := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if string(data) != "large object" || req.ContentLength != 12 {
+			t.Errorf("Request %d has body %q of length %d; want the file", i, data, req.ContentLength)
+		}
+	}
+
+	req := streamRequest(base, filepath.Join(dir, "missing"))
+	if _, err := ioutil.ReadAll(req.Body); !os.IsNotExist(err) {
+		t.Errorf("Body of a missing file fails with %v; want not exist", err)
+	}
+}
+
+func TestCheckFileLimit(t *testing.T) {
+	if err := checkFileLimit(1); err != nil {
+		t.Errorf("checkFileLimit(1) = %v; want nil", err)
+	}
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the limit is only checked on Linux and macOS")
+	}
+	if err := checkFileLimit(1 << 30); err == nil {
+		t.Error("checkFileLimit is expected to fail above the hard limit")
+	}
+}
+
+func TestBodyRepeat(t *testing.T) {
+	for _, tt := range []struct {
+		spec, body, want string
+	}{
+		{"3", "ab", "ababab"},
+		{"5B", "ab", "ababa"},
+		{"1kb", "x", strings.Repeat("x", 1024)},
+	} {
+		br, err := parseBodyRepeat(tt.spec)
+		if err != nil {
+			t.Fatalf("parseBodyRepeat(%q) = %v", tt.spec, err)
+		}
+		if got, err := br.apply(tt.body); err != nil || got != tt.want {
+			t.Errorf("-repeat-body %s of %q = %q, %v; want %q", tt.spec, tt.body, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "0", "x", "-1MB", "1GB"} {
+		if _, err := parseBodyRepeat(s); err == nil {
+			t.Errorf("parseBodyRepeat(%q) is expected to fail", s)
+		}
+	}
+	br, _ := parseBodyRepeat("1000")
+	if _, err := br.apply(""); err == nil {
+		t.Error("Repeating an empty body is expected to fail")
+	}
+	if _, err := br.apply(strings.Repeat("x", 1<<20)); err == nil {
+		t.Error("Repeating a body beyond the cap is expected to fail")
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	var got string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	u, err := parseProxy(proxy.URL, "user:p@ss:word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	resp, err := client.Get("http://target.invalid/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := "Basic dXNlcjpwQHNzOndvcmQ="; got != want {
+		t.Errorf("Proxy-Authorization = %q; want %q", got, want)
+	}
+
+	if u, _ := parseProxy(proxy.URL, ""); u.User != nil {
+		t.Errorf("Proxy without -proxy-auth has user %v", u.User)
+	}
+	if _, err := parseProxy(proxy.URL, "nopassword"); err == nil {
+		t.Error("Expected an error for -proxy-auth without a password")
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	first, last, err := parsePortRange("8001-8003")
+	if err != nil || first != 8001 || last != 8003 {
+		t.Fatalf("parsePortRange = %d, %d, %v; want 8001, 8003", first, last, err)
+	}
+	for _, s := range []string{"8001", "8003-8001", "0-10", "1-65536", "a-b"} {
+		if _, _, err := parsePortRange(s); err == nil {
+			t.Errorf("parsePortRange(%q) succeeded; want an error", s)
+		}
+	}
+	urls, err := portURLs("http://[::1]:80/path?q=1", first, last)
+	want := []string{"http://[::1]:8001/path?q=1", "http://[::1]:8002/path?q=1", "http://[::1]:8003/path?q=1"}
+	if err != nil || !reflect.DeepEqual(urls, want) {
+		t.Errorf("portURLs = %v, %v; want %v", urls, err, want)
+	}
+}
+
+func TestHostURLs(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want []string
+	}{
+		{"https://example.com/path?q=1", []string{"https://web1/path?q=1", "https://web2:8443/path?q=1", "https://[::1]/path?q=1"}},
+		{"http://example.com:8080/", []string{"http://web1:8080/", "http://web2:8443/", "http://[::1]:8080/"}},
+		{"/health", []string{"http://web1/health", "http://web2:8443/health", "http://[::1]/health"}},
+	} {
+		urls, err := hostURLs(tt.url, []string{"web1", "web2:8443", "::1"})
+		if err != nil || !reflect.DeepEqual(urls, tt.want) {
+			t.Errorf("hostURLs(%q) = %v, %v; want %v", tt.url, urls, err, tt.want)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "schema.json")
+	ioutil.WriteFile(file, []byte(`{
+  "type": "object",
+  "required": ["id", "status", "tags", "owner"],
+  "properties": {
+    "id": {"type": "integer", "minimum": 1, "maximum": 10},
+    "status": {"enum": ["open", "closed"]},
+    "tags": {"type": "array", "items": {"type": "string", "minLength": 2, "maxLength": 4}, "minItems": 1, "maxItems": 3},
+    "owner": {"$ref": "#/$defs/user"},
+    "note": {"type": ["string", "null"]}
+  },
+  "$defs": {
+    "user": {"type": "object", "required": ["email"], "properties": {"email": {"type": "string", "format": "email"}, "manager": {"$ref": "#/$defs/user"}}}
+  }
+}`), 0644)
+
+	g, err := loadSchema(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = g.withRand(requester.NewRand(42))
+	var docs []string
+	for i := 0; i < 50; i++ {
+		doc := g.generate()
+		docs = append(docs, doc)
+		var v struct {
+			ID     int
+			Status string
+			Tags   []string
+			Owner  struct{ Email string }
+		}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatalf("Generated %s: %v", doc, err)
+		}
+		if v.ID < 1 || v.ID > 10 || v.Status != "open" && v.Status != "closed" || len(v.Tags) < 1 || len(v.Tags) > 3 || !strings.HasSuffix(v.Owner.Email, "@example.com") {
+			t.Errorf("Generated %s isn't valid", doc)
+		}
+		for _, tag := range v.Tags {
+			if len(tag) < 2 || len(tag) > 4 {
+				t.Errorf("Generated %s has a tag of invalid length", doc)
+			}
+		}
+	}
+	g, _ = loadSchema(file)
+	g = g.withRand(requester.NewRand(42))
+	for i, want := range docs {
+		if got := g.generate(); got != want {
+			t.Fatalf("Document %d is %s with the same seed; want %s", i, got, want)
+		}
+	}
+
+	ioutil.WriteFile(file, []byte(`{"type": "object", "required": ["s", "i", "j"], "properties": {
+  "s": {"type": "string", "maxLength": 50000000},
+  "i": {"type": "integer", "minimum": -9e18, "maximum": 9e18},
+  "j": {"type": "integer", "minimum": 1, "maximum": 1e300}}}`), 0644)
+	g, _ = loadSchema(file)
+	g = g.withRand(requester.NewRand(42))
+	for i := 0; i < 20; i++ {
+		var v struct {
+			S    string
+			I, J int64
+		}
+		if doc := g.generate(); json.Unmarshal([]byte(doc), &v) != nil || len(v.S) > maxSchemaString || v.I < -9e18 || v.I > 9e18 || v.J < 1 {
+			t.Fatalf("Generated %.100s isn't within the bounds", doc)
+		}
+	}
+
+	// cycles through $ref and anyOf end too
+	ioutil.WriteFile(file, []byte(`{"$defs": {"a": {"anyOf": [{"$ref": "#/$defs/a"}]}}, "$ref": "#/$defs/a"}`), 0644)
+	g, _ = loadSchema(file)
+	g = g.withRand(requester.NewRand(42))
+	if got := g.generate(); got != "null" {
+		t.Errorf("Generated %s from a cyclic schema; want null", got)
+	}
+
+	ioutil.WriteFile(file, []byte(`{"properties": {"a": {"$ref": "other.json#/a"}}}`), 0644)
+	if _, err := loadSchema(file); err == nil {
+		t.Error("loadSchema() of a remote $ref is expected to fail")
+	}
+}
+
+func TestAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	agent := httptest.NewServer(agentMux())
+	defer agent.Close()
+
+	for _, tt := range []struct {
+		spec runSpec
+		code int
+	}{
+		{runSpec{Method: "GET", URL: server.URL, N: 4, C: 2}, http.StatusOK},
+		{runSpec{Method: "GET", URL: server.URL, N: 4, C: -1}, http.StatusBadRequest},
+		{runSpec{Method: "GET", URL: server.URL, N: 0, C: 1}, http.StatusBadRequest},
+		{runSpec{Method: "GET", URL: server.URL, N: 1, C: 2}, http.StatusBadRequest},
+		{runSpec{Method: "GET", URL: server.URL, N: 4, C: 2, Timeout: -1}, http.StatusBadRequest},
+		{runSpec{Method: "GET", URL: server.URL, N: 4, C: 2, Duration: -time.Second}, http.StatusBadRequest},
+		{runSpec{Method: "GET", URL: "file:///etc/passwd", N: 4, C: 2}, http.StatusBadRequest},
+	} {
+		data, _ := json.Marshal(tt.spec)
+		resp, err := http.Post(agent.URL+"/run", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s requester.Summary
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&s)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("Agent answered %d to %+v; want %d", resp.StatusCode, tt.spec, tt.code)
+		}
+		if tt.code == http.StatusOK && s.NumRes != int64(tt.spec.N) {
+			t.Errorf("Agent ran %d requests; want %d", s.NumRes, tt.spec.N)
+		}
+	}
+}
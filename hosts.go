@@ -0,0 +1,49 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	gourl "net/url"
+	"strings"
+)
+
+// hostURLs returns url with its host replaced by each of hosts. The hosts
+// without a port keep the port of url, if any. url may be only a path, then
+// sent over http.
+func hostURLs(url string, hosts []string) ([]string, error) {
+	u, err := gourl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	port := u.Port()
+	var urls []string
+	for _, h := range hosts {
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			switch {
+			case port != "":
+				h = net.JoinHostPort(h, port)
+			case strings.Contains(h, ":"):
+				h = "[" + h + "]" // IPv6
+			}
+		}
+		u.Host = h
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
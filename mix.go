@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// methodMix picks the methods of the requests at random, by weight.
+type methodMix struct {
+	methods []string
+	cum     []int // cumulative weights
+}
+
+// parseMethodMix parses weighted methods, like "GET:80,POST:20".
+func parseMethodMix(s string) (*methodMix, error) {
+	mix := &methodMix{}
+	total := 0
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid method weight %q, e.g. GET:80", item)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid method weight %q, e.g. GET:80", item)
+		}
+		total += w
+		mix.methods = append(mix.methods, strings.ToUpper(strings.TrimSpace(parts[0])))
+		mix.cum = append(mix.cum, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no method in -method-mix")
+	}
+	return mix, nil
+}
+
+// pick returns the method of a request, chosen with rnd.
+func (m *methodMix) pick(rnd *requester.Rand) string {
+	n := rnd.Intn(m.cum[len(m.cum)-1])
+	for i, c := range m.cum {
+		if n < c {
+			return m.methods[i]
+		}
+	}
+	return m.methods[len(m.methods)-1]
+}
+
+// methodBodies are the request bodies of the methods of -method-mix, set
+// with -method-d METHOD:body.
+type methodBodies map[string]string
+
+func (mb methodBodies) String() string {
+	return fmt.Sprintf("%v", map[string]string(mb))
+}
+
+func (mb methodBodies) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected METHOD:body")
+	}
+	mb[strings.ToUpper(strings.TrimSpace(parts[0]))] = parts[1]
+	return nil
+}
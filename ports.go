@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	gourl "net/url"
+	"strconv"
+	"strings"
+)
+
+// parsePortRange parses a range of ports, like 8001-8010.
+func parsePortRange(s string) (first, last int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q, e.g. 8001-8010", s)
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	last, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q, e.g. 8001-8010", s)
+	}
+	return first, last, nil
+}
+
+// portURLs returns url with its port replaced by each port of the range.
+func portURLs(url string, first, last int) ([]string, error) {
+	u, err := gourl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for port := first; port <= last; port++ {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRepeatedBody bounds the size of the bodies of -repeat-body, which are
+// kept in memory. Larger bodies can be sent with -D-stream.
+const maxRepeatedBody = 256 << 20
+
+// bodyRepeat repeats a body a number of times, or up to a size.
+type bodyRepeat struct {
+	times int
+	size  int
+}
+
+// parseBodyRepeat parses the value of -repeat-body, a number of times like
+// 100 or a size in bytes like 64KB or 1MB.
+func parseBodyRepeat(s string) (*bodyRepeat, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := 0
+	for _, u := range []struct {
+		suffix string
+		mult   int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid -repeat-body %q, e.g. 100 or 1MB", s)
+	}
+	if mult == 0 {
+		return &bodyRepeat{times: n}, nil
+	}
+	if n > maxRepeatedBody/mult {
+		return nil, fmt.Errorf("-repeat-body is larger than %dMB, use -D-stream", maxRepeatedBody>>20)
+	}
+	return &bodyRepeat{size: n * mult}, nil
+}
+
+// apply returns body repeated, or an error if the result is too large.
+func (br *bodyRepeat) apply(body string) (string, error) {
+	if body == "" {
+		return "", fmt.Errorf("-repeat-body needs a -d or -D body")
+	}
+	if br.size > 0 {
+		return strings.Repeat(body, br.size/len(body)+1)[:br.size], nil
+	}
+	if br.times > maxRepeatedBody/len(body) {
+		return "", fmt.Errorf("-repeat-body is larger than %dMB, use -D-stream", maxRepeatedBody>>20)
+	}
+	return strings.Repeat(body, br.times), nil
+}
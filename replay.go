@@ -0,0 +1,74 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// loadReplayFile reads the requests of file to replay at their recorded
+// timing. Each line holds the offset of a request from the start, like
+// 1.5s or 1.5 seconds, a tab, and the request as in a -requests-file. rf
+// is applied to each request.
+func loadReplayFile(file string, rf *requestFlags) ([]requester.Scheduled, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var schedule []requester.Scheduled
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected an offset and a request separated by a tab", file, i+1)
+		}
+		offset, err := parseOffset(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		req, body, err := parseRequestSpec(fields[1], rf)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		schedule = append(schedule, requester.Scheduled{Offset: offset, Request: req, Body: body})
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("%s: no requests", file)
+	}
+	// logs are mostly in order already
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].Offset < schedule[j].Offset })
+	return schedule, nil
+}
+
+// parseOffset parses a duration, or a number of seconds.
+func parseOffset(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid offset %q, e.g. 1.5s", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
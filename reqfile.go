@@ -0,0 +1,179 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// requestSpec is a line of a -requests-file.
+type requestSpec struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// requestFlags are what the flags set on the requests read from -har,
+// -requests-file, -stream-stdin and -replay, as newRequest does on the one
+// of -url. The headers given by -H, -H-file, -A, -T, -U and -no-ua
+// override the ones of the requests, the defaults of header, like the
+// Content-Type, only fill in the missing ones.
+type requestFlags struct {
+	header             http.Header
+	given              map[string]bool
+	username, password string
+	host               string
+}
+
+// apply sets the flags on req. A nil requestFlags sets nothing.
+func (rf *requestFlags) apply(req *http.Request) {
+	if rf == nil {
+		return
+	}
+	// the User-Agent of the request is kept, hey's follows it
+	if ua := req.Header.Get("User-Agent"); ua != "" && !rf.given["User-Agent"] {
+		req.Header.Set("User-Agent", ua+" "+heyUA)
+	}
+	for k, v := range rf.header {
+		if _, ok := req.Header[k]; !ok || rf.given[k] {
+			req.Header[k] = append([]string(nil), v...)
+		}
+	}
+	// net/http sends req.Host, not the Host header
+	if h := req.Header.Get("Host"); h != "" {
+		req.Host = h
+		req.Header.Del("Host")
+	}
+	if rf.username != "" || rf.password != "" {
+		req.SetBasicAuth(rf.username, rf.password)
+	}
+	if rf.host != "" {
+		req.Host = rf.host
+	}
+}
+
+// loadRequestsFile reads the requests of file, a json object per line like
+// {"method": "POST", "url": "https://host/", "headers": {"Content-Type":
+// "application/json"}, "body": "{}"}. The method defaults to GET. rf is
+// applied to each request.
+func loadRequestsFile(file string, rf *requestFlags) ([]*http.Request, []string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var reqs []*http.Request
+	var bodies []string
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		req, body, err := parseRequestSpec(line, rf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		reqs = append(reqs, req)
+		bodies = append(bodies, body)
+	}
+	if len(reqs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no requests", file)
+	}
+	return reqs, bodies, nil
+}
+
+func parseRequestSpec(line string, rf *requestFlags) (*http.Request, string, error) {
+	var spec requestSpec
+	dec := json.NewDecoder(strings.NewReader(line))
+	// catch misspelled fields
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, "", err
+	}
+	if spec.URL == "" {
+		return nil, "", errors.New("no url")
+	}
+	if spec.Method == "" {
+		spec.Method = "GET"
+	}
+	req, err := http.NewRequest(strings.ToUpper(spec.Method), spec.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.ContentLength = int64(len(spec.Body))
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
+	}
+	rf.apply(req)
+	return req, spec.Body, nil
+}
+
+// maxStreamLine is the longest line of -stream-stdin, bodies included.
+const maxStreamLine = 16 << 20
+
+// streamRequests reads the requests of r as they arrive, one per line as in
+// -requests-file, and sends them to the returned channel, closed at EOF. It
+// blocks until the first request is read, returned as well. Invalid lines
+// are reported and skipped. rf is applied to each request.
+func streamRequests(r io.Reader, rf *requestFlags) (requester.Prepared, <-chan requester.Prepared, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLine)
+	line := 0
+	next := func() (requester.Prepared, bool) {
+		for scanner.Scan() {
+			line++
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			req, body, err := parseRequestSpec(scanner.Text(), rf)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "stdin:%d: %v, skipped.\n", line, err)
+				continue
+			}
+			return requester.Prepared{Request: req, Body: body}, true
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "stdin:%d: %v.\n", line+1, err)
+		}
+		return requester.Prepared{}, false
+	}
+
+	first, ok := next()
+	if !ok {
+		return first, nil, errors.New("stdin: no requests")
+	}
+	ch := make(chan requester.Prepared, 64)
+	ch <- first
+	go func() {
+		defer close(ch)
+		for {
+			p, ok := next()
+			if !ok {
+				return
+			}
+			ch <- p
+		}
+	}()
+	return first, ch, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "fmt"
+
+// defaultAbortWindow is the number of latest results the error rate of
+// AbortErrorRate is computed over, unless AbortWindow is set.
+const defaultAbortWindow = 100
+
+// errorWindow is the error rate over the latest results, for
+// AbortErrorRate.
+type errorWindow struct {
+	failed    []bool // ring of the latest outcomes
+	next      int
+	n, errors int
+	threshold float64
+}
+
+func newErrorWindow(size int, threshold float64) *errorWindow {
+	if size <= 0 {
+		size = defaultAbortWindow
+	}
+	return &errorWindow{failed: make([]bool, size), threshold: threshold}
+}
+
+// add records the outcome of a result, and returns why the run should be
+// aborted, or "" if it shouldn't. The rate is only checked once the window
+// is full, so that a few early errors don't abort the run.
+func (ew *errorWindow) add(failed bool) string {
+	if ew.n == len(ew.failed) {
+		if ew.failed[ew.next] {
+			ew.errors--
+		}
+	} else {
+		ew.n++
+	}
+	ew.failed[ew.next] = failed
+	if failed {
+		ew.errors++
+	}
+	ew.next = (ew.next + 1) % len(ew.failed)
+
+	rate := float64(ew.errors) / float64(ew.n)
+	if ew.n < len(ew.failed) || rate <= ew.threshold {
+		return ""
+	}
+	return fmt.Sprintf("error rate %.1f%% over the last %d results exceeded %.1f%%", rate*100, ew.n, ew.threshold*100)
+}
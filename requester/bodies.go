@@ -0,0 +1,129 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+const (
+	// maxDistinctBodies bounds the number of body hashes kept in memory,
+	// later bodies are only counted as untracked.
+	maxDistinctBodies = 10000
+	topBodies         = 5
+	bodySampleLen     = 60
+)
+
+// bodyDigest is the hash of a response body and its first bytes.
+type bodyDigest struct {
+	sum    uint64
+	sample []byte
+}
+
+// digestBody hashes the body read from r, until EOF.
+func digestBody(r io.Reader) (*bodyDigest, error) {
+	h := fnv.New64a()
+	s := &sampleWriter{}
+	_, err := io.Copy(io.MultiWriter(h, s), r)
+	return &bodyDigest{sum: h.Sum64(), sample: s.b}, err
+}
+
+// sampleWriter keeps the first bodySampleLen bytes written to it.
+type sampleWriter struct{ b []byte }
+
+func (s *sampleWriter) Write(p []byte) (int, error) {
+	if n := bodySampleLen - len(s.b); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		s.b = append(s.b, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// bodyStats counts the responses of each distinct body.
+type bodyStats struct {
+	counts    map[uint64]*BodyCount
+	untracked int64
+}
+
+func (bs *bodyStats) add(res *result) {
+	if res.body == nil {
+		return
+	}
+	c, ok := bs.counts[res.body.sum]
+	if !ok {
+		if len(bs.counts) >= maxDistinctBodies {
+			bs.untracked++
+			return
+		}
+		c = &BodyCount{Hash: fmt.Sprintf("%016x", res.body.sum), Sample: string(res.body.sample)}
+		bs.counts[res.body.sum] = c
+	}
+	c.Count++
+}
+
+func (bs *bodyStats) merge(o *bodyStats) {
+	bs.untracked += o.untracked
+	for sum, oc := range o.counts {
+		if c, ok := bs.counts[sum]; ok {
+			c.Count += oc.Count
+			continue
+		}
+		if len(bs.counts) >= maxDistinctBodies {
+			bs.untracked += oc.Count
+			continue
+		}
+		c := *oc
+		bs.counts[sum] = &c
+	}
+}
+
+func (bs *bodyStats) snapshot() *DistinctBodies {
+	d := &DistinctBodies{Distinct: len(bs.counts), Untracked: bs.untracked}
+	for _, c := range bs.counts {
+		d.Top = append(d.Top, *c)
+	}
+	sort.Slice(d.Top, func(i, j int) bool {
+		if d.Top[i].Count != d.Top[j].Count {
+			return d.Top[i].Count > d.Top[j].Count
+		}
+		return d.Top[i].Hash < d.Top[j].Hash
+	})
+	if len(d.Top) > topBodies {
+		d.Top = d.Top[:topBodies]
+	}
+	return d
+}
+
+// DistinctBodies summarizes the response bodies hashed with DistinctBodies.
+type DistinctBodies struct {
+	// Distinct is the number of different bodies, at most 10000. Untracked
+	// is the number of responses with a body beyond those.
+	Distinct  int
+	Untracked int64
+	// Top are the most common bodies, most common first.
+	Top []BodyCount
+}
+
+// BodyCount is the number of responses with a given body.
+type BodyCount struct {
+	Hash   string // FNV-1a, 64 bits
+	Sample string // first bytes of the body
+	Count  int64
+}
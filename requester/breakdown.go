@@ -0,0 +1,113 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sort"
+)
+
+// breakdown groups the results by a key, e.g. the requested URL, and
+// aggregates each group separately.
+type breakdown struct {
+	title   string
+	exact   bool
+	key     func(res *result) string
+	cohorts map[string]*cohort
+}
+
+type cohort struct {
+	count  int64
+	errors int64
+	lats   *estimator
+	sum    float64 // of the latencies
+}
+
+func newBreakdown(title string, exact bool, key func(res *result) string) *breakdown {
+	return &breakdown{
+		title:   title,
+		exact:   exact,
+		key:     key,
+		cohorts: make(map[string]*cohort),
+	}
+}
+
+func (bd *breakdown) add(res *result) {
+	k := bd.key(res)
+	c, ok := bd.cohorts[k]
+	if !ok {
+		c = &cohort{lats: newEstimator(bd.exact)}
+		bd.cohorts[k] = c
+	}
+	c.count++
+	if res.err != nil {
+		c.errors++
+		return
+	}
+	if res.failure != "" {
+		c.errors++
+	}
+	c.lats.add(res.duration.Seconds())
+	c.sum += res.duration.Seconds()
+}
+
+// merge adds the cohorts of o, a breakdown with the same key, to bd.
+func (bd *breakdown) merge(o *breakdown) {
+	for k, oc := range o.cohorts {
+		c, ok := bd.cohorts[k]
+		if !ok {
+			c = &cohort{lats: newEstimator(bd.exact)}
+			bd.cohorts[k] = c
+		}
+		c.count += oc.count
+		c.errors += oc.errors
+		c.lats.combine(oc.lats)
+		c.sum += oc.sum
+	}
+}
+
+func (bd *breakdown) snapshot() Breakdown {
+	s := Breakdown{Title: bd.title}
+	for k, c := range bd.cohorts {
+		cs := Cohort{
+			Key:       k,
+			Count:     c.count,
+			ErrorRate: float64(c.errors) * 100 / float64(c.count),
+		}
+		if c.lats.n > 0 {
+			cs.Mean = c.sum / float64(c.lats.n)
+			cs.P50 = c.lats.quantile(0.5)
+			cs.P95 = c.lats.quantile(0.95)
+			cs.P99 = c.lats.quantile(0.99)
+		}
+		s.Cohorts = append(s.Cohorts, cs)
+	}
+	sort.Slice(s.Cohorts, func(i, j int) bool { return s.Cohorts[i].Key < s.Cohorts[j].Key })
+	return s
+}
+
+type Breakdown struct {
+	Title   string
+	Cohorts []Cohort
+}
+
+type Cohort struct {
+	Key       string
+	Count     int64
+	ErrorRate float64 // in percent
+	Mean      float64
+	P50       float64
+	P95       float64
+	P99       float64
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is the single range of bytes requested by Work.Range.
+type ByteRange struct {
+	// First and Last are the offsets of the first and last bytes, Last
+	// is -1 for a range up to the end.
+	First, Last int64
+	// Suffix, if positive, is the length of a range at the end, in place
+	// of First and Last.
+	Suffix int64
+}
+
+// ParseByteRange parses the value of a Range header with a single range,
+// like "bytes=0-1023", "bytes=1024-" or "bytes=-500".
+func ParseByteRange(spec string) (*ByteRange, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(spec), "bytes=")
+	if s == spec || strings.Contains(s, ",") {
+		return nil, fmt.Errorf("invalid range %q, e.g. bytes=0-1023", spec)
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid range %q, e.g. bytes=0-1023", spec)
+	}
+	r := &ByteRange{Last: -1}
+	var err error
+	switch {
+	case parts[0] == "":
+		r.Suffix, err = strconv.ParseInt(parts[1], 10, 64)
+		if err == nil && r.Suffix <= 0 {
+			err = fmt.Errorf("empty suffix")
+		}
+	default:
+		r.First, err = strconv.ParseInt(parts[0], 10, 64)
+		if err == nil && parts[1] != "" {
+			r.Last, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+		if err == nil && (r.First < 0 || r.Last >= 0 && r.Last < r.First) {
+			err = fmt.Errorf("last byte before the first")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q, e.g. bytes=0-1023", spec)
+	}
+	return r, nil
+}
+
+// String returns the value of the Range header.
+func (r *ByteRange) String() string {
+	switch {
+	case r.Suffix > 0:
+		return fmt.Sprintf("bytes=-%d", r.Suffix)
+	case r.Last < 0:
+		return fmt.Sprintf("bytes=%d-", r.First)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.First, r.Last)
+}
+
+// matches reports whether the Content-Range of a 206 response, like
+// "bytes 0-1023/146515", is the range requested, shortened to the size
+// of the resource if it's known.
+func (r *ByteRange) matches(contentRange string) bool {
+	var first, last int64
+	var size string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &size); err != nil {
+		return false
+	}
+	total := int64(-1)
+	if size != "*" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return false
+		}
+		total = n
+	}
+	switch {
+	case r.Suffix > 0:
+		if total < 0 {
+			return last-first+1 == r.Suffix
+		}
+		return last == total-1 && first == max64(total-r.Suffix, 0)
+	case r.Last < 0 || total >= 0 && r.Last >= total:
+		return first == r.First && (total < 0 || last == total-1)
+	}
+	return first == r.First && last == r.Last
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// rangeOutcome is how a response honored the Range of the request.
+type rangeOutcome int
+
+const (
+	rangeNone     rangeOutcome = iota // no Range sent, or no response
+	rangeHonored                      // 206 with the Content-Range requested
+	rangeFull                         // 200 with the full body
+	rangeMismatch                     // 206 with another Content-Range
+	rangeOther                        // other status codes, like 416
+)
+
+func (r *ByteRange) outcome(resp *http.Response) rangeOutcome {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if r.matches(resp.Header.Get("Content-Range")) {
+			return rangeHonored
+		}
+		return rangeMismatch
+	case http.StatusOK:
+		return rangeFull
+	}
+	return rangeOther
+}
+
+// rangeStats counts the outcomes of the requests of Work.Range.
+type rangeStats struct {
+	spec                                 string
+	responses, honored, full, mismatched int64
+}
+
+func (rs *rangeStats) add(res *result) {
+	if res.rangeOutcome == rangeNone {
+		return
+	}
+	rs.responses++
+	switch res.rangeOutcome {
+	case rangeHonored:
+		rs.honored++
+	case rangeFull:
+		rs.full++
+	case rangeMismatch:
+		rs.mismatched++
+	}
+}
+
+func (rs *rangeStats) merge(o *rangeStats) {
+	rs.responses += o.responses
+	rs.honored += o.honored
+	rs.full += o.full
+	rs.mismatched += o.mismatched
+}
+
+func (rs *rangeStats) snapshot() *RangeRequests {
+	r := &RangeRequests{Range: rs.spec, Responses: rs.responses, Honored: rs.honored, Full: rs.full, Mismatched: rs.mismatched}
+	if rs.responses > 0 {
+		r.HonoredRatio = float64(rs.honored) * 100 / float64(rs.responses)
+		r.FullRatio = float64(rs.full) * 100 / float64(rs.responses)
+	}
+	return r
+}
+
+// RangeRequests summarizes how the responses honored Work.Range.
+type RangeRequests struct {
+	Range     string // the Range header sent
+	Responses int64
+	// Honored is the number of 206 responses with the Content-Range
+	// requested, Full of 200 responses with the full body, and Mismatched
+	// of 206 responses with another Content-Range, counted as errors.
+	Honored, Full, Mismatched int64
+	// HonoredRatio and FullRatio are the percentages of the responses
+	// honoring the range and ignoring it.
+	HonoredRatio, FullRatio float64
+}
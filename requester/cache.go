@@ -0,0 +1,95 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/http"
+	"strings"
+)
+
+// setIfNoneMatch makes req conditional on the ETag last received by the
+// worker gort, if any, and reports whether it did.
+func (b *Work) setIfNoneMatch(req *http.Request, gort int) bool {
+	if gort < 0 || b.etags[gort] == "" {
+		return false
+	}
+	req.Header.Set("If-None-Match", b.etags[gort])
+	return true
+}
+
+// keepETag keeps the ETag of resp for the next requests of the worker gort.
+// Each worker only uses its own ETag, so no locking is needed.
+func (b *Work) keepETag(resp *http.Response, gort int) {
+	if etag := resp.Header.Get("ETag"); gort >= 0 && etag != "" {
+		b.etags[gort] = etag
+	}
+}
+
+// cacheStats counts the conditional requests and their 304 responses.
+// cacheClass classifies a response by the value of its cache header, like
+// X-Cache: HIT or MISS, or the value itself for other statuses, like
+// EXPIRED. With several caches, like "MISS, HIT", the last one, closest to
+// the client, is taken.
+func cacheClass(value string) string {
+	parts := strings.Split(value, ",")
+	v := strings.ToUpper(strings.TrimSpace(parts[len(parts)-1]))
+	switch {
+	case v == "":
+		return "NONE"
+	case strings.Contains(v, "HIT"):
+		return "HIT"
+	case strings.Contains(v, "MISS"):
+		return "MISS"
+	}
+	return v
+}
+
+type cacheStats struct {
+	conditional, notModified int64
+}
+
+func (cs *cacheStats) add(res *result) {
+	if !res.conditional || res.err != nil {
+		return
+	}
+	cs.conditional++
+	if res.statusCode == http.StatusNotModified {
+		cs.notModified++
+	}
+}
+
+func (cs *cacheStats) merge(o *cacheStats) {
+	cs.conditional += o.conditional
+	cs.notModified += o.notModified
+}
+
+func (cs *cacheStats) snapshot() *CacheValidation {
+	c := &CacheValidation{Conditional: cs.conditional, NotModified: cs.notModified}
+	if cs.conditional > 0 {
+		c.HitRatio = float64(cs.notModified) * 100 / float64(cs.conditional)
+	}
+	return c
+}
+
+// CacheValidation summarizes the conditional requests of ValidateCache.
+type CacheValidation struct {
+	// Conditional is the number of requests sent with If-None-Match, and
+	// NotModified the number of them answered with 304 Not Modified.
+	Conditional int64
+	NotModified int64
+	// HitRatio is the percentage of the conditional requests answered
+	// with 304.
+	HitRatio float64
+}
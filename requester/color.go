@@ -0,0 +1,84 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+)
+
+// isColorTerminal reports whether w is a terminal colors can be used on,
+// see https://no-color.org.
+func isColorTerminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor reports whether the summary written to w is colored for the
+// Color setting, auto-detected when it's empty or "auto".
+func useColor(color string, w io.Writer) bool {
+	switch color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return isColorTerminal(w)
+}
+
+// colorize colors the summary: section headers in bold, latencies in green,
+// errors and error status codes in red.
+func colorize(summary string) string {
+	lines := strings.Split(summary, "\n")
+	var section string
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case trimmed == "":
+		case !strings.HasPrefix(l, " ") && !strings.HasPrefix(l, "\t") && strings.Contains(l, ":"):
+			section = l
+			lines[i] = colorBold + l + colorReset
+		case strings.HasPrefix(section, "Latency distribution"):
+			lines[i] = colorGreen + l + colorReset
+		case strings.HasPrefix(section, "Error distribution"):
+			lines[i] = colorRed + l + colorReset
+		case strings.HasPrefix(section, "Status code distribution"):
+			if strings.HasPrefix(trimmed, "[2") || strings.HasPrefix(trimmed, "[3") {
+				lines[i] = colorGreen + l + colorReset
+			} else {
+				lines[i] = colorRed + l + colorReset
+			}
+		case strings.Contains(l, "% errors") && !strings.Contains(l, " 0.0000% errors"):
+			// breakdowns
+			lines[i] = colorRed + l + colorReset
+		}
+	}
+	return strings.Join(lines, "\n")
+}
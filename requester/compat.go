@@ -0,0 +1,101 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// compatPercentiles are the percentiles printed by ab and wrk.
+var compatPercentiles = map[string][]int{
+	"ab":  {50, 66, 75, 80, 90, 95, 98, 99, 100},
+	"wrk": {50, 75, 90, 99},
+}
+
+// abSummary formats r like the report of ApacheBench (ab), from the
+// concurrency level down to the percentiles.
+func abSummary(r Report) string {
+	var b bytes.Buffer
+	secs := r.Total.Seconds()
+	fmt.Fprintf(&b, "Concurrency Level:      %d\n", r.Concurrency)
+	fmt.Fprintf(&b, "Time taken for tests:   %.3f seconds\n", secs)
+	fmt.Fprintf(&b, "Complete requests:      %d\n", r.NumRes)
+	fmt.Fprintf(&b, "Failed requests:        %d\n", r.NumRes-r.Successes)
+	fmt.Fprintf(&b, "Total transferred:      %d bytes\n", r.SizeTotal)
+	fmt.Fprintf(&b, "Requests per second:    %.2f [#/sec] (mean)\n", r.Rps)
+	var perReq, perReqAll float64
+	if r.NumRes > 0 {
+		perReqAll = secs * 1000 / float64(r.NumRes)
+		perReq = perReqAll * float64(r.Concurrency)
+	}
+	fmt.Fprintf(&b, "Time per request:       %.3f [ms] (mean)\n", perReq)
+	fmt.Fprintf(&b, "Time per request:       %.3f [ms] (mean, across all concurrent requests)\n", perReqAll)
+	fmt.Fprintf(&b, "Transfer rate:          %.2f [Kbytes/sec] received\n", float64(r.SizeTotal)/1024/secs)
+	fmt.Fprintf(&b, "\nPercentage of the requests served within a certain time (ms)\n")
+	for _, ld := range r.CompatDistribution {
+		fmt.Fprintf(&b, "  %3d%%  %6.0f", ld.Percentage, ld.Latency*1000)
+		if ld.Percentage == 100 {
+			b.WriteString(" (longest request)")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// wrkSummary formats r like the report of wrk with --latency, from the
+// thread stats down to the transfer rate.
+func wrkSummary(r Report) string {
+	var b bytes.Buffer
+	secs := r.Total.Seconds()
+	fmt.Fprintf(&b, "  %d threads and %d connections\n", r.Concurrency, r.Concurrency)
+	fmt.Fprintf(&b, "  Thread Stats   Avg      Stdev     Max\n")
+	fmt.Fprintf(&b, "    Latency   %8s %8s %8s\n", wrkDuration(r.Average), wrkDuration(r.LatencyStdev), wrkDuration(r.Slowest))
+	fmt.Fprintf(&b, "  Latency Distribution\n")
+	for _, ld := range r.CompatDistribution {
+		fmt.Fprintf(&b, "     %d%%  %8s\n", ld.Percentage, wrkDuration(ld.Latency))
+	}
+	fmt.Fprintf(&b, "  %d requests in %s, %s read\n", r.NumRes, wrkDuration(secs), wrkSize(float64(r.SizeTotal)))
+	if failed := r.NumRes - r.Successes; failed > 0 {
+		fmt.Fprintf(&b, "  Non-2xx or 3xx responses: %d\n", failed)
+	}
+	fmt.Fprintf(&b, "Requests/sec: %10.2f\n", r.Rps)
+	fmt.Fprintf(&b, "Transfer/sec: %10s\n", wrkSize(float64(r.SizeTotal)/secs))
+	return b.String()
+}
+
+// wrkDuration formats secs with the units of wrk.
+func wrkDuration(secs float64) string {
+	switch {
+	case secs < 1e-3:
+		return fmt.Sprintf("%.2fus", secs*1e6)
+	case secs < 1:
+		return fmt.Sprintf("%.2fms", secs*1e3)
+	case secs < 60:
+		return fmt.Sprintf("%.2fs", secs)
+	}
+	return fmt.Sprintf("%.2fm", secs/60)
+}
+
+// wrkSize formats a number of bytes with the binary units of wrk.
+func wrkSize(bytes float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%s", bytes, units[i])
+}
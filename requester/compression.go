@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// decodeBody returns a reader decompressing r, encoded with the given
+// Content-Encoding. Encodings other than gzip and deflate are read as is.
+func decodeBody(encoding string, r io.Reader) io.Reader {
+	var dec io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		dec, err = gzip.NewReader(r)
+	case "deflate":
+		dec, err = zlib.NewReader(r)
+	default:
+		return r
+	}
+	if err != nil {
+		// the header was read already, keep counting what's left
+		return r
+	}
+	return dec
+}
+
+// isEncoded reports whether a response with the given Content-Encoding
+// is compressed.
+func isEncoded(encoding string) bool {
+	e := strings.ToLower(strings.TrimSpace(encoding))
+	return e != "" && e != "identity"
+}
+
+// hasBody reports whether the response to a request with the given method
+// and status code can have a body.
+func hasBody(method string, code int) bool {
+	return method != http.MethodHead && code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// compressionFailure returns why res fails RequireCompression, or "" if it
+// doesn't. Its sizes are only compared if they were measured.
+func compressionFailure(res *result, measured bool) string {
+	switch {
+	case !res.encoded:
+		return "response not compressed"
+	case measured && res.wireSize >= res.decodedSize:
+		return "compressed response not smaller"
+	}
+	return ""
+}
+
+// compressionStats sums the sizes of the response bodies of AcceptEncoding.
+type compressionStats struct {
+	acceptEncoding        string
+	wire, decoded         int64
+	responses, compressed int64
+}
+
+func (cs *compressionStats) add(res *result) {
+	if res.err != nil {
+		return
+	}
+	cs.responses++
+	cs.wire += res.wireSize
+	cs.decoded += res.decodedSize
+	if res.encoded {
+		cs.compressed++
+	}
+}
+
+func (cs *compressionStats) merge(o *compressionStats) {
+	cs.responses += o.responses
+	cs.wire += o.wire
+	cs.decoded += o.decoded
+	cs.compressed += o.compressed
+}
+
+func (cs *compressionStats) snapshot() *Compression {
+	c := &Compression{
+		AcceptEncoding: cs.acceptEncoding,
+		WireSize:       cs.wire,
+		DecodedSize:    cs.decoded,
+		Responses:      cs.responses,
+		Compressed:     cs.compressed,
+	}
+	if cs.wire > 0 {
+		c.Ratio = float64(cs.decoded) / float64(cs.wire)
+	}
+	return c
+}
+
+// Compression compares the size of the response bodies on the wire and
+// decompressed, when AcceptEncoding is set.
+type Compression struct {
+	AcceptEncoding string
+	// WireSize and DecodedSize are the total sizes of the bodies, in bytes.
+	WireSize, DecodedSize int64
+	// Ratio is DecodedSize / WireSize.
+	Ratio float64
+	// Compressed is how many of the responses had a Content-Encoding.
+	Responses, Compressed int64
+}
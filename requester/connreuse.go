@@ -0,0 +1,87 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net"
+	"sync"
+)
+
+// connUses counts the requests sent on each connection, for ConnReuse.
+// The connections are kept until the end of the run.
+type connUses struct {
+	mu   sync.Mutex
+	uses map[net.Conn]int64
+}
+
+func (cu *connUses) add(conn net.Conn) {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+	if cu.uses == nil {
+		cu.uses = make(map[net.Conn]int64)
+	}
+	cu.uses[conn]++
+}
+
+// reuseBuckets are the upper bounds of the buckets of ConnReuse.
+var reuseBuckets = []int64{1, 10, 100, 1000}
+
+func (cu *connUses) snapshot() *ConnReuse {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+	cr := &ConnReuse{Conns: int64(len(cu.uses))}
+	counts := make([]int64, len(reuseBuckets)+1)
+	var total int64
+	for _, n := range cu.uses {
+		total += n
+		if n > cr.Max {
+			cr.Max = n
+		}
+		i := 0
+		for i < len(reuseBuckets) && n > reuseBuckets[i] {
+			i++
+		}
+		counts[i]++
+	}
+	if cr.Conns > 0 {
+		cr.Average = float64(total) / float64(cr.Conns)
+	}
+	var low int64 = 1
+	for i, c := range counts {
+		b := ReuseBucket{Conns: c, Min: low}
+		if i < len(reuseBuckets) {
+			b.Max = reuseBuckets[i]
+			low = b.Max + 1
+		}
+		cr.Buckets = append(cr.Buckets, b)
+	}
+	return cr
+}
+
+// ConnReuse is the distribution of the number of requests sent on each
+// connection.
+type ConnReuse struct {
+	Conns   int64
+	Average float64
+	Max     int64
+	Buckets []ReuseBucket
+}
+
+// ReuseBucket counts the connections that served between Min and Max
+// requests, or Min and more if Max is 0.
+type ReuseBucket struct {
+	Min, Max int64
+	Conns    int64
+}
@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Prepared is a request of Corpus.
+type Prepared struct {
+	Request *http.Request
+	Body    string
+}
+
+func (p Prepared) clone() *http.Request {
+	r := p.Request.Clone(context.Background())
+	if p.Body != "" {
+		r.Body = ioutil.NopCloser(strings.NewReader(p.Body))
+	}
+	return r
+}
+
+// nextPrepared returns the index of the next request of Corpus to send, or
+// false once all of them were taken.
+func (b *Work) nextPrepared() (int, bool) {
+	i := atomic.AddInt64(&b.cursor, 1) - 1
+	return int(i), i < int64(len(b.Corpus))
+}
+
+// runCorpusWorker sends the next request of Corpus until none is left, so
+// that the requests are sent exactly once whatever the number of workers.
+func (b *Work) runCorpusWorker(client *http.Client, gort int) {
+	for {
+		if !b.waitResume() {
+			return
+		}
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+		i, ok := b.nextPrepared()
+		if !ok {
+			return
+		}
+		b.makeRequest(gort, i, client)
+	}
+}
+
+// runStreamWorker sends the requests of Stream as it receives them, until
+// it's closed or the run is stopped.
+func (b *Work) runStreamWorker(client *http.Client, gort int) {
+	// more checks Duration, the quota of 1 is never reached
+	for b.waitResume() && b.more(0, 1) {
+		select {
+		case <-b.stopCh:
+			return
+		case p, ok := <-b.Stream:
+			if !ok {
+				return
+			}
+			b.streamed[gort] = p
+			b.makeRequest(gort, 0, client)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+const memSampleInterval = 250 * time.Millisecond
+
+// peakHeap is the largest heap sampled, in bytes, across all runs of the
+// process. Accessed atomically.
+var peakHeap int64
+
+// runtimeStats records the state of the process around a run.
+type runtimeStats struct {
+	goroutinesBefore, goroutinesAfter int
+	connsBefore, connsAfter           int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startRuntimeStats records the state before the run and samples the heap
+// until print is called.
+func startRuntimeStats() *runtimeStats {
+	rs := &runtimeStats{
+		goroutinesBefore: runtime.NumGoroutine(),
+		connsBefore:      atomic.LoadInt64(&openConns),
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	go func() {
+		defer close(rs.done)
+		ticker := time.NewTicker(memSampleInterval)
+		defer ticker.Stop()
+		for {
+			sampleHeap()
+			select {
+			case <-rs.stop:
+				sampleHeap()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return rs
+}
+
+func sampleHeap() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	heap := int64(ms.HeapAlloc)
+	for {
+		peak := atomic.LoadInt64(&peakHeap)
+		if heap <= peak || atomic.CompareAndSwapInt64(&peakHeap, peak, heap) {
+			return
+		}
+	}
+}
+
+func (rs *runtimeStats) print(w io.Writer) {
+	close(rs.stop)
+	<-rs.done
+	rs.goroutinesAfter = runtime.NumGoroutine()
+	rs.connsAfter = atomic.LoadInt64(&openConns)
+	fmt.Fprintf(w, "Runtime (before, after):\n")
+	fmt.Fprintf(w, "  Goroutines:\t%d, %d\n", rs.goroutinesBefore, rs.goroutinesAfter)
+	fmt.Fprintf(w, "  Open connections:\t%d, %d\n", rs.connsBefore, rs.connsAfter)
+	fmt.Fprintf(w, "  Peak heap:\t%.2f MB\n\n", float64(atomic.LoadInt64(&peakHeap))/(1<<20))
+}
+
+// startCPUProfile starts profiling the CPU to file, and returns the function
+// stopping it and flushing the profile.
+func startCPUProfile(file string) (func(), error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			log.Println("error:", err.Error())
+		}
+	}, nil
+}
+
+// writeMemProfile writes the heap profile to file, as of the last garbage
+// collection.
+func writeMemProfile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// openConns is the number of connections dialed by the workers and not
+// closed yet, across all runs of the process. Accessed atomically.
+var openConns int64
+
+// countedConn keeps openConns up to date.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func countConn(conn net.Conn) net.Conn {
+	atomic.AddInt64(&openConns, 1)
+	return &countedConn{Conn: conn}
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&openConns, -1) })
+	return c.Conn.Close()
+}
+
+// sockBufControl returns the Control function of a dialer setting the
+// socket buffer sizes that are positive, before connecting so that the TCP
+// window scale can take them into account.
+func sockBufControl(snd, rcv int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = setSockBufs(fd, snd, rcv) }); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// countingDial wraps dial to count the connections it opens.
+func countingDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return countConn(conn), nil
+	}
+}
+
+// resolvedDial returns a dial function dialing ip instead of host, keeping
+// the port. Other addresses are dialed as is.
+func resolvedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), host, ip string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if h, port, err := net.SplitHostPort(addr); err == nil && h == host {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// resolve resolves the host of the request once, before the run.
+func (b *Work) resolve() {
+	host := b.Request.URL.Hostname()
+	if net.ParseIP(host) != nil {
+		return
+	}
+	s := now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	b.resolveDuration = now() - s
+	if err != nil {
+		log.Println("error: resolve-once:", err.Error())
+		return
+	}
+	b.resolvedHost, b.resolvedIP = host, addrs[0].IP.String()
+}
+
+var errNoWarmConn = errors.New("no pre-established connection available")
+
+// warmPool hands out the connections dialed before the run. Once it is
+// drained, dialing fails rather than opening a new connection.
+type warmPool struct {
+	conns      chan net.Conn
+	unexpected int64
+}
+
+// newWarmPool dials n connections to the target of the request, completing
+// the TLS handshake for https, so connection setup isn't measured.
+func newWarmPool(n int, req *http.Request, tlsConfig *tls.Config, d net.Dialer) (*warmPool, error) {
+	p := &warmPool{conns: make(chan net.Conn, n)}
+	addr := canonicalAddr(req)
+	for i := 0; i < n; i++ {
+		conn, err := d.Dial("tcp", addr)
+		if err != nil {
+			return p, err
+		}
+		conn = countConn(conn)
+		if req.URL.Scheme == "https" {
+			tc := tls.Client(conn, tlsConfig.Clone())
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				return p, err
+			}
+			conn = tc
+		}
+		p.conns <- conn
+	}
+	return p, nil
+}
+
+func (p *warmPool) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	default:
+		atomic.AddInt64(&p.unexpected, 1)
+		return nil, errNoWarmConn
+	}
+}
+
+// canonicalAddr returns the host:port the request is sent to.
+func canonicalAddr(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// tlsHandshake is the TLS handshake a request made for a new connection.
+type tlsHandshake int
+
+const (
+	handshakeNone tlsHandshake = iota
+	handshakeFull
+	handshakeResumed
+)
@@ -0,0 +1,60 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"regexp"
+	"sort"
+)
+
+// topErrors is the number of most common error messages in the summary.
+const topErrors = 10
+
+// addrRegexp matches the IP addresses, with their port if any, in the error
+// messages, e.g. 10.0.0.1:80 and [::1]:443.
+var addrRegexp = regexp.MustCompile(`\[[0-9a-fA-F:.%]+\](:\d+)?|\b\d{1,3}(\.\d{1,3}){3}(:\d+)?`)
+
+// normalizeError masks the addresses of an error message, so that the
+// same error from different connections is counted once, e.g.
+// "dial tcp 10.0.0.1:80: connect: connection refused" as
+// "dial tcp <addr>: connect: connection refused".
+func normalizeError(msg string) string {
+	return addrRegexp.ReplaceAllString(msg, "<addr>")
+}
+
+// ErrorCount is an error message, normalized, and how many requests failed
+// with it.
+type ErrorCount struct {
+	Message string
+	Count   int
+}
+
+// topErrorCounts returns the n most common messages, most common first.
+func topErrorCounts(messages map[string]int, n int) []ErrorCount {
+	var top []ErrorCount
+	for msg, count := range messages {
+		top = append(top, ErrorCount{msg, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Message < top[j].Message
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
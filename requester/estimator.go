@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"sort"
+)
+
+// gamma is the ratio between the bounds of consecutive buckets. Estimating
+// a value by its bucket is accurate to (gamma-1)/(gamma+1), 1%.
+const gamma = 1.0202
+
+var logGamma = math.Log(gamma)
+
+// zeroBucket holds the values that are not positive.
+const zeroBucket = math.MinInt32
+
+// estimator is the distribution of a series of durations in seconds, for
+// quantiles and histograms. By default it counts the values in logarithmic
+// buckets, so its memory stays bounded however many values it gets. In
+// exact mode it keeps the values instead, up to maxRes of them.
+type estimator struct {
+	exact  bool
+	values []float64 // exact mode
+	sorted bool
+	counts map[int]int64 // bucket index to count, default mode
+
+	n        int64
+	min, max float64
+}
+
+func newEstimator(exact bool) *estimator {
+	return &estimator{
+		exact:  exact,
+		counts: make(map[int]int64),
+	}
+}
+
+func (e *estimator) add(v float64) {
+	if e.n == 0 || v < e.min {
+		e.min = v
+	}
+	if e.n == 0 || v > e.max {
+		e.max = v
+	}
+	e.n++
+	if e.exact {
+		if len(e.values) < maxRes {
+			e.values = append(e.values, v)
+			e.sorted = false
+		}
+		return
+	}
+	e.counts[bucketOf(v)]++
+}
+
+// combine adds the values of o to e, like merge does those of a summary.
+func (e *estimator) combine(o *estimator) {
+	if o.n == 0 {
+		return
+	}
+	if e.n == 0 || o.min < e.min {
+		e.min = o.min
+	}
+	if e.n == 0 || o.max > e.max {
+		e.max = o.max
+	}
+	e.n += o.n
+	if e.exact {
+		n := min(len(o.values), maxRes-len(e.values))
+		e.values = append(e.values, o.values[:n]...)
+		e.sorted = false
+		return
+	}
+	for i, c := range o.counts {
+		e.counts[i] += c
+	}
+}
+
+// quantile returns the value that the fraction q of the values don't exceed.
+func (e *estimator) quantile(q float64) float64 {
+	var v float64
+	var count int64
+	e.each(func(value float64, c int64) bool {
+		v = value
+		count += c
+		return float64(count) < q*float64(e.n)
+	})
+	return v
+}
+
+// each calls f with the values in ascending order, and the number of times
+// each was seen, until f returns false.
+func (e *estimator) each(f func(v float64, count int64) bool) {
+	if e.exact {
+		if !e.sorted {
+			sort.Float64s(e.values)
+			e.sorted = true
+		}
+		for _, v := range e.values {
+			if !f(v, 1) {
+				return
+			}
+		}
+		return
+	}
+	buckets := make([]int, 0, len(e.counts))
+	for i := range e.counts {
+		buckets = append(buckets, i)
+	}
+	sort.Ints(buckets)
+	for _, i := range buckets {
+		if !f(e.bucketValue(i), e.counts[i]) {
+			return
+		}
+	}
+}
+
+func bucketOf(v float64) int {
+	if v <= 0 {
+		return zeroBucket
+	}
+	return int(math.Ceil(math.Log(v) / logGamma))
+}
+
+// bucketValue estimates the values in bucket i, which are between
+// gamma^(i-1) and gamma^i, by the value with the least relative error.
+func (e *estimator) bucketValue(i int) float64 {
+	if i == zeroBucket {
+		return math.Max(e.min, 0)
+	}
+	v := 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+	return math.Min(math.Max(v, e.min), e.max)
+}
+
+// stddev returns the standard deviation of the values of e.
+func (e *estimator) stddev() float64 {
+	var total int64
+	var sum, sumSq float64
+	e.each(func(v float64, count int64) bool {
+		total += count
+		sum += v * float64(count)
+		sumSq += v * v * float64(count)
+		return true
+	})
+	if total == 0 {
+		return 0
+	}
+	mean := sum / float64(total)
+	return math.Sqrt(math.Max(sumSq/float64(total)-mean*mean, 0))
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Fault configures the artificial latency and errors injected into the
+// requests, to produce a known distribution in the summary.
+type Fault struct {
+	// Delay is added to a DelayRate fraction of the requests.
+	Delay     time.Duration
+	DelayRate float64
+
+	// ErrorRate is the fraction of requests failing without being sent.
+	ErrorRate float64
+}
+
+var errInjectedFault = errors.New("injected fault (synthetic)")
+
+type faultKey struct{}
+
+// faultTransport injects the configured faults before passing requests on.
+type faultTransport struct {
+	rt    http.RoundTripper
+	fault Fault
+	rnd   *Rand
+}
+
+func newFaultTransport(rt http.RoundTripper, f Fault, rnd *Rand) *faultTransport {
+	return &faultTransport{rt: rt, fault: f, rnd: rnd}
+}
+
+func (t *faultTransport) roll() (delay, fail bool) {
+	return t.rnd.Float64() < t.fault.DelayRate, t.rnd.Float64() < t.fault.ErrorRate
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, fail := t.roll()
+	if delay || fail {
+		// mark the result of the request as synthetic
+		if injected, ok := req.Context().Value(faultKey{}).(*bool); ok {
+			*injected = true
+		}
+	}
+	if delay {
+		select {
+		case <-time.After(t.fault.Delay):
+		case <-req.Context().Done():
+			closeBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+	if fail {
+		closeBody(req)
+		return nil, errInjectedFault
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// withFaultMark returns a context in which the fault transport marks
+// the request as synthetic.
+func withFaultMark(ctx context.Context, injected *bool) context.Context {
+	return context.WithValue(ctx, faultKey{}, injected)
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
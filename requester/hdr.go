@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// writeHgrmFile writes the distribution of e to file, see writeHgrm.
+func (e *estimator) writeHgrmFile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := e.writeHgrm(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeHgrm writes the distribution of e in the percentile distribution
+// format of HdrHistogram (.hgrm), with values in milliseconds. There is
+// a line for each bucket, or each distinct value in exact mode.
+func (e *estimator) writeHgrm(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	// exact mode only keeps the first maxRes values
+	kept := e.n
+	if e.exact {
+		kept = int64(len(e.values))
+	}
+	var total int64
+	var sum, sumSq float64
+	var last float64
+	var lastCount int64
+	rows := 0
+	row := func(v float64, count int64) {
+		p := float64(count) / float64(kept)
+		if count == kept {
+			fmt.Fprintf(bw, "%12.3f %2.12f %10d\n", v*1000, p, count)
+		} else {
+			fmt.Fprintf(bw, "%12.3f %2.12f %10d %14.2f\n", v*1000, p, count, 1/(1-p))
+		}
+		rows++
+	}
+	e.each(func(v float64, count int64) bool {
+		// one line per distinct value
+		if total > 0 && v != last {
+			row(last, lastCount)
+		}
+		total += count
+		sum += v * float64(count)
+		sumSq += v * v * float64(count)
+		last, lastCount = v, total
+		return true
+	})
+	if total > 0 {
+		row(last, lastCount)
+	}
+
+	var mean, stddev float64
+	if total > 0 {
+		mean = sum / float64(total)
+		stddev = math.Sqrt(math.Max(sumSq/float64(total)-mean*mean, 0))
+	}
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean*1000, stddev*1000)
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", e.max*1000, total)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n", rows, 1)
+	return bw.Flush()
+}
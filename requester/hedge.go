@@ -0,0 +1,180 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// hedging of a request, marked by hedgeTransport
+type hedgeOutcome int
+
+const (
+	hedgeNone hedgeOutcome = iota // answered within the hedge delay
+	hedgeLost                     // hedged, the first request answered first
+	hedgeWon                      // hedged, the hedge answered first
+)
+
+type hedgeKey struct{}
+
+// withHedgeMark returns a context in which the hedge transport marks the
+// outcome of the request.
+func withHedgeMark(ctx context.Context, outcome *hedgeOutcome) context.Context {
+	return context.WithValue(ctx, hedgeKey{}, outcome)
+}
+
+// hedgeTransport sends a second, identical request when the first one has
+// no response after delay, returning the response that comes first and
+// cancelling the other request. The hedge isn't traced, the phases of the
+// result are those of the first request.
+type hedgeTransport struct {
+	rt    http.RoundTripper
+	delay time.Duration
+}
+
+type attempt struct {
+	resp   *http.Response
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	// the hedge sends the body again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(ctx)
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+
+	attempts := make(chan attempt, 2)
+	send := func(r *http.Request, hedge bool, cancel context.CancelFunc) {
+		resp, err := t.rt.RoundTrip(r)
+		attempts <- attempt{resp, err, hedge, cancel}
+	}
+	firstCtx, cancelFirst := context.WithCancel(ctx)
+	go send(req.WithContext(firstCtx), false, cancelFirst)
+
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+	select {
+	case a := <-attempts:
+		return a.done()
+	case <-timer.C:
+	}
+
+	hedge, err := t.hedgeRequest(req)
+	if err != nil {
+		a := <-attempts
+		return a.done()
+	}
+	hedgeCtx, cancelHedge := untraced(ctx)
+	go send(hedge.WithContext(hedgeCtx), true, cancelHedge)
+	markHedge(ctx, hedgeLost)
+
+	a := <-attempts
+	if a.err != nil {
+		// the other request may still succeed
+		a.cancel()
+		if a = <-attempts; a.hedge && a.err == nil {
+			markHedge(ctx, hedgeWon)
+		}
+		return a.done()
+	}
+	if a.hedge {
+		markHedge(ctx, hedgeWon)
+		// wait for the first request, so that its trace is done with
+		cancelFirst()
+		(<-attempts).discard()
+	} else {
+		cancelHedge()
+		go func() { (<-attempts).discard() }()
+	}
+	return a.done()
+}
+
+func (t *hedgeTransport) hedgeRequest(req *http.Request) (*http.Request, error) {
+	hedge := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		hedge.Body = body
+	}
+	return hedge, nil
+}
+
+// done returns the response of the attempt, cancelling its context once
+// the body is closed.
+func (a attempt) done() (*http.Response, error) {
+	if a.err != nil {
+		a.cancel()
+		return nil, a.err
+	}
+	a.resp.Body = &cancelBody{ReadCloser: a.resp.Body, cancel: a.cancel}
+	return a.resp, nil
+}
+
+func (a attempt) discard() {
+	if a.resp != nil {
+		a.resp.Body.Close()
+	}
+	a.cancel()
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// untraced returns a context cancelled with ctx but without its values, so
+// that the hedge doesn't report to the trace of the first request.
+func untraced(ctx context.Context) (context.Context, context.CancelFunc) {
+	hctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-hctx.Done():
+		}
+	}()
+	return hctx, cancel
+}
+
+func markHedge(ctx context.Context, outcome hedgeOutcome) {
+	if p, ok := ctx.Value(hedgeKey{}).(*hedgeOutcome); ok {
+		*p = outcome
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "sync/atomic"
+
+// enterFlight waits until less than MaxInFlight requests are outstanding,
+// and counts the request as one of them. The requests in flight complete
+// or time out, so it can't wait forever.
+func (b *Work) enterFlight() {
+	b.inflight <- struct{}{}
+	n := atomic.AddInt64(&b.inflightNow, 1)
+	for {
+		max := atomic.LoadInt64(&b.inflightMax)
+		if n <= max || atomic.CompareAndSwapInt64(&b.inflightMax, max, n) {
+			return
+		}
+	}
+}
+
+// leaveFlight frees the slot of a request, once its response was read.
+func (b *Work) leaveFlight() {
+	atomic.AddInt64(&b.inflightNow, -1)
+	<-b.inflight
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxMeasurement is the measurement of the influx points.
+const influxMeasurement = "hey"
+
+// influxBatch is the number of points pushed to InfluxURL per request.
+const influxBatch = 5000
+
+// influxStats aggregates the results by second of the run, status code and
+// method, for the InfluxDB line protocol points.
+type influxStats map[influxKey]*influxPoint
+
+type influxKey struct {
+	second int64 // since the start of the run
+	status string
+	method string
+}
+
+type influxPoint struct {
+	count, errors int64
+	sum, max      float64 // response times in seconds
+}
+
+func (is influxStats) add(res *result, start time.Duration) {
+	k := influxKey{
+		second: int64((res.offset - start) / time.Second),
+		status: strconv.Itoa(res.statusCode),
+		method: res.method,
+	}
+	if res.err != nil {
+		k.status = "error"
+	}
+	p, ok := is[k]
+	if !ok {
+		p = &influxPoint{}
+		is[k] = p
+	}
+	p.count++
+	if res.err != nil || res.failure != "" {
+		p.errors++
+	}
+	d := res.duration.Seconds()
+	p.sum += d
+	if d > p.max {
+		p.max = d
+	}
+}
+
+func (is influxStats) merge(o influxStats) {
+	for k, op := range o {
+		p, ok := is[k]
+		if !ok {
+			p = &influxPoint{}
+			is[k] = p
+		}
+		p.count += op.count
+		p.errors += op.errors
+		p.sum += op.sum
+		if op.max > p.max {
+			p.max = op.max
+		}
+	}
+}
+
+// lines returns the points in line protocol, in time order, timestamped
+// at the start of their second from start. The name of the run, if any,
+// is a tag of every point.
+func (is influxStats) lines(start time.Time, name string) []string {
+	keys := make([]influxKey, 0, len(is))
+	for k := range is {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.second != b.second {
+			return a.second < b.second
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.method < b.method
+	})
+	var tags string
+	if name != "" {
+		tags = ",name=" + influxEscape(name)
+	}
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		p := is[k]
+		ts := start.Add(time.Duration(k.second) * time.Second).UnixNano()
+		lines = append(lines, fmt.Sprintf("%s,method=%s%s,status=%s count=%di,errors=%di,mean=%g,max=%g %d",
+			influxMeasurement, influxEscape(k.method), tags, k.status, p.count, p.errors, p.sum/float64(p.count), p.max, ts))
+	}
+	return lines
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxEscape escapes a tag value of the line protocol.
+func influxEscape(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return influxEscaper.Replace(s)
+}
+
+// pushInflux writes the lines to url, an InfluxDB write endpoint like
+// http://host:8086/write?db=hey, in batches of influxBatch.
+func pushInflux(url string, lines []string) error {
+	for len(lines) > 0 {
+		n := min(len(lines), influxBatch)
+		body := strings.Join(lines[:n], "\n") + "\n"
+		resp, err := http.Post(url, "text/plain; charset=utf-8", bytes.NewBufferString(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("influx write to %s: %s", url, resp.Status)
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
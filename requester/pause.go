@@ -0,0 +1,135 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Pause stops the workers from sending new requests until Resume is called.
+// The requests in flight complete. The time spent paused is left out of the
+// duration of the run. Init must have been called.
+func (b *Work) Pause() {
+	b.pauseMu.Lock()
+	defer b.pauseMu.Unlock()
+	if b.resume != nil {
+		return
+	}
+	b.resume = make(chan struct{})
+	b.pauseStart = now()
+	atomic.StoreInt32(&b.paused, 1)
+}
+
+// Resume lets the workers paused by Pause send requests again.
+func (b *Work) Resume() {
+	b.pauseMu.Lock()
+	defer b.pauseMu.Unlock()
+	if b.resume == nil {
+		return
+	}
+	paused := now() - b.pauseStart
+	b.pausedTotal += paused
+	// before the workers resume and check it
+	if b.deadlineTimer != nil {
+		deadline := time.Duration(atomic.AddInt64(&b.deadline, int64(paused)))
+		b.deadlineTimer.Reset(deadline - now())
+	}
+	close(b.resume)
+	b.resume = nil
+	atomic.StoreInt32(&b.paused, 0)
+}
+
+// checkDeadline stops the run once its deadline is reached. While paused,
+// it's left to Resume to push the deadline back and check it again.
+func (b *Work) checkDeadline() {
+	b.pauseMu.Lock()
+	defer b.pauseMu.Unlock()
+	if b.resume != nil {
+		return
+	}
+	if left := time.Duration(atomic.LoadInt64(&b.deadline)) - now(); left > 0 {
+		b.deadlineTimer.Reset(left)
+		return
+	}
+	b.Stop()
+}
+
+// waitResume blocks while the run is paused. It returns false if the run
+// was stopped meanwhile.
+func (b *Work) waitResume() bool {
+	if atomic.LoadInt32(&b.paused) == 0 {
+		return true
+	}
+	b.pauseMu.Lock()
+	resume := b.resume
+	b.pauseMu.Unlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-b.stopCh:
+		return false
+	}
+}
+
+// pausedDuration returns the time spent paused so far.
+func (b *Work) pausedDuration() time.Duration {
+	b.pauseMu.Lock()
+	defer b.pauseMu.Unlock()
+	d := b.pausedTotal
+	if b.resume != nil {
+		d += now() - b.pauseStart
+	}
+	return d
+}
+
+// Progress prints a line summarizing the results so far to w, while the
+// run is going on. Init must have been called. It prints nothing before
+// the run starts, e.g. while waiting for the target to be ready.
+func (b *Work) Progress(w io.Writer) {
+	select {
+	case <-b.reporterStarted:
+	default:
+		return
+	}
+	req := progressRequest{w: w, paused: b.pausedDuration(), done: make(chan struct{})}
+	select {
+	case b.progress <- req:
+		<-req.done
+	case <-b.reporterDone:
+	}
+}
+
+// progressRequest asks the reporter to print the progress to w.
+type progressRequest struct {
+	w      io.Writer
+	paused time.Duration
+	done   chan struct{}
+}
+
+func (r *report) printProgress(w io.Writer, paused time.Duration) {
+	elapsed := now() - r.start - paused
+	fmt.Fprintf(w, "[%v] %d responses, %d errors, %4.4f requests/sec", elapsed.Round(100*time.Millisecond),
+		r.numRes, r.numErrors, float64(r.numRes)/elapsed.Seconds())
+	if r.latEst.n > 0 {
+		fmt.Fprintf(w, ", average %4.4f secs, 99%% in %4.4f secs", r.avgTotal/float64(r.latEst.n), r.latEst.quantile(0.99))
+	}
+	fmt.Fprintln(w)
+}
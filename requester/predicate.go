@@ -0,0 +1,277 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Predicate is a condition on the responses, like
+//
+//	status == 200 && body contains "ok" && latency < 300ms
+//
+// Comparisons of the fields status, size (content length in bytes), latency
+// and body are combined with &&, ||, ! and parentheses. Numbers and
+// durations support ==, !=, <, <=, > and >=, the body ==, != and contains.
+type Predicate struct {
+	root     predNode
+	usesBody bool
+}
+
+type predNode interface {
+	eval(res *result) bool
+}
+
+// ParsePredicate parses the condition expr, see Predicate.
+func ParsePredicate(expr string) (*Predicate, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &predParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in condition", p.toks[p.pos])
+	}
+	return &Predicate{root: root, usesBody: p.usesBody}, nil
+}
+
+// UsesBody reports whether the condition needs the response body, which is
+// otherwise discarded unread.
+func (p *Predicate) UsesBody() bool { return p.usesBody }
+
+func (p *Predicate) match(res *result) bool { return p.root.eval(res) }
+
+type andNode struct{ l, r predNode }
+
+func (n andNode) eval(res *result) bool { return n.l.eval(res) && n.r.eval(res) }
+
+type orNode struct{ l, r predNode }
+
+func (n orNode) eval(res *result) bool { return n.l.eval(res) || n.r.eval(res) }
+
+type notNode struct{ x predNode }
+
+func (n notNode) eval(res *result) bool { return !n.x.eval(res) }
+
+// cmpNode compares a field to num, or to str for the body.
+type cmpNode struct {
+	field, op string
+	num       int64
+	str       string
+}
+
+func (n cmpNode) eval(res *result) bool {
+	if n.field == "body" {
+		body := string(res.respbody)
+		switch n.op {
+		case "==":
+			return body == n.str
+		case "!=":
+			return body != n.str
+		}
+		return strings.Contains(body, n.str)
+	}
+	var v int64
+	switch n.field {
+	case "status":
+		v = int64(res.statusCode)
+	case "size":
+		v = res.contentLength
+	case "latency":
+		v = int64(res.duration)
+	}
+	switch n.op {
+	case "==":
+		return v == n.num
+	case "!=":
+		return v != n.num
+	case "<":
+		return v < n.num
+	case "<=":
+		return v <= n.num
+	case ">":
+		return v > n.num
+	}
+	return v >= n.num
+}
+
+type predParser struct {
+	toks     []string
+	pos      int
+	usesBody bool
+}
+
+func (p *predParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *predParser) next() (string, error) {
+	if p.pos == len(p.toks) {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+	p.pos++
+	return p.toks[p.pos-1], nil
+}
+
+func (p *predParser) parseOr() (predNode, error) {
+	l, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var r predNode
+		if r, err = p.parseAnd(); err == nil {
+			l = orNode{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *predParser) parseAnd() (predNode, error) {
+	l, err := p.parseNot()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var r predNode
+		if r, err = p.parseNot(); err == nil {
+			l = andNode{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *predParser) parseNot() (predNode, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		x, err := p.parseNot()
+		return notNode{x}, err
+	case "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, _ := p.next(); tok != ")" {
+			return nil, fmt.Errorf("missing ) in condition")
+		}
+		return x, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *predParser) parseCmp() (predNode, error) {
+	var n cmpNode
+	var val string
+	var err error
+	for _, tok := range []*string{&n.field, &n.op, &val} {
+		if *tok, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	switch n.field {
+	case "status", "size", "latency":
+		switch n.op {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("%s cannot be compared with %q", n.field, n.op)
+		}
+		if n.field == "latency" {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid latency %q, e.g. 300ms", val)
+			}
+			n.num = int64(d)
+		} else if n.num, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", n.field, val)
+		}
+	case "body":
+		switch n.op {
+		case "==", "!=", "contains":
+		default:
+			return nil, fmt.Errorf("body cannot be compared with %q", n.op)
+		}
+		if n.str, err = strconv.Unquote(val); err != nil || !strings.HasPrefix(val, `"`) {
+			return nil, fmt.Errorf("invalid string %s, use double quotes", val)
+		}
+		p.usesBody = true
+	default:
+		return nil, fmt.Errorf("unknown field %q, one of status, size, latency or body", n.field)
+	}
+	return n, nil
+}
+
+// tokenize splits a condition into fields, operators, parentheses, quoted
+// strings and values.
+func tokenize(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in condition")
+			}
+			toks = append(toks, s[i:j+1])
+			i = j + 1
+		case c == '(' || c == ')':
+			toks = append(toks, s[i:i+1])
+			i++
+		case strings.IndexByte("=!<>&|", c) >= 0:
+			if i+1 < len(s) {
+				switch op := s[i : i+2]; op {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					toks = append(toks, op)
+					i += 2
+					continue
+				}
+			}
+			if c != '!' && c != '<' && c != '>' {
+				return nil, fmt.Errorf("unexpected %q in condition", c)
+			}
+			toks = append(toks, s[i:i+1])
+			i++
+		case isWordByte(c):
+			j := i
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in condition", c)
+		}
+	}
+	return toks, nil
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_'
+}
@@ -13,7 +13,7 @@
 // limitations under the License.
 
 /*
-Hey supports two output formats: summary and CSV
+Hey supports three output formats: summary, CSV and JSON
 
 The summary output presents a number of statistics about the requests in a
 human-readable format, including:
@@ -31,6 +31,26 @@ The comma-separated CSV format is proceeded by a header, and consists of the fol
 6. Response-read:	Time taken to read full response (in seconds)
 7. status-code:		HTTP status code of the response (e.g. 200)
 8. offset:			The time since the start of the benchmark when the request was started. (in seconds)
+
+There is a row per response, the requests failing without one are left out.
+The phases are 0 when they weren't traced, see Work.Fast.
+
+Response headers requested with -csv-headers follow as additional columns,
+named after the header, and the name of the run as the last column if set.
+
+The JSON format is a single object holding the numbers of the summary.
+
+The influx format is in the InfluxDB line protocol, a point per second of
+the run, status code and method, with the count, errors, mean and max
+response times in seconds, e.g.
+
+	hey,method=GET,status=200 count=120i,errors=0i,mean=0.012,max=0.051 1700000000000000000
+
+The status is "error" for the requests failing without a response, and the
+name of the run, if set, is a tag too.
+
+The summary can also be rendered by a custom template, see
+ParseSummaryTemplate.
 */
 package requester
 
@@ -49,15 +69,34 @@ func newTemplate(output string) *template.Template {
 		outputTmpl = defaultTmpl
 	case "csv":
 		outputTmpl = csvTmpl
+	case "json":
+		outputTmpl = jsonTmpl
+	case "ab":
+		outputTmpl = abTmpl
+	case "wrk":
+		outputTmpl = wrkTmpl
+	case "influx":
+		outputTmpl = influxTmpl
 	}
 	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Parse(outputTmpl))
 }
 
+// ParseSummaryTemplate parses a text/template rendering the summary in place
+// of the built-in one. It is executed with the Report of the run, and can
+// use the functions of the built-in templates, like formatNumber.
+func ParseSummaryTemplate(text string) (*template.Template, error) {
+	return template.New("summary").Funcs(tmplFuncMap).Parse(text)
+}
+
 var tmplFuncMap = template.FuncMap{
 	"formatNumber":    formatNumber,
 	"formatNumberInt": formatNumberInt,
 	"histogram":       histogram,
 	"jsonify":         jsonify,
+	"csvField":        csvField,
+	"jsonSummary":     jsonSummary,
+	"abSummary":       abSummary,
+	"wrkSummary":      wrkSummary,
 }
 
 func jsonify(v interface{}) string {
@@ -65,6 +104,214 @@ func jsonify(v interface{}) string {
 	return string(d)
 }
 
+// jsonReport is the object written by the json output.
+type jsonReport struct {
+	Name     string  `json:"name,omitempty"`
+	Total    float64 `json:"total_secs"`
+	Slowest  float64 `json:"slowest_secs"`
+	Fastest  float64 `json:"fastest_secs"`
+	Average  float64 `json:"average_secs"`
+	Rps      float64 `json:"requests_per_sec"`
+	Requests int64   `json:"requests"`
+	Dropped  int64   `json:"dropped_results,omitempty"`
+	Aborted  string  `json:"aborted,omitempty"`
+
+	// the results are partial if set
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// redirects followed, counted in requests with -count-redirect-hops
+	RedirectHops int64 `json:"redirect_hops,omitempty"`
+
+	// responses without body, counted as errors with -fail-on-empty-body
+	EmptyBodies int64 `json:"empty_bodies,omitempty"`
+
+	SizeTotal int64 `json:"size_total"`
+	SizeReq   int64 `json:"size_per_request"`
+
+	LatencyDistribution []jsonPercentile     `json:"latency_distribution"`
+	Details             map[string]jsonPhase `json:"details,omitempty"`
+	StatusCodeDist      map[int]int          `json:"status_code_distribution"`
+	ErrorDist           map[string]int       `json:"error_distribution"`
+	TopErrors           []jsonErrorCount     `json:"top_errors,omitempty"`
+
+	TTFB   *jsonTTFB   `json:"time_to_first_byte,omitempty"`
+	Cache  *jsonCache  `json:"cache_validation,omitempty"`
+	Range  *jsonRange  `json:"range_requests,omitempty"`
+	Bodies *jsonBodies `json:"distinct_bodies,omitempty"`
+
+	CacheClasses []jsonCacheClass `json:"cache_classes,omitempty"`
+	Workers      []jsonWorker     `json:"workers,omitempty"`
+
+	Rate *jsonRate `json:"rate_achieved,omitempty"`
+
+	ConnRate *jsonConnRate `json:"connection_rate,omitempty"`
+}
+
+type jsonBodies struct {
+	Distinct  int        `json:"distinct"`
+	Untracked int64      `json:"untracked_responses"`
+	Top       []jsonBody `json:"most_common"`
+}
+
+type jsonBody struct {
+	Hash   string `json:"hash"`
+	Sample string `json:"sample"`
+	Count  int64  `json:"responses"`
+}
+
+type jsonRate struct {
+	Target    float64 `json:"target"`
+	Average   float64 `json:"average"`
+	Min       int64   `json:"min"`
+	Max       int64   `json:"max"`
+	Seconds   int     `json:"seconds"`
+	Deviating int     `json:"deviating_seconds"`
+}
+
+type jsonConnRate struct {
+	Total   int64   `json:"connections"`
+	Average float64 `json:"average"`
+	Peak    int64   `json:"peak"`
+	Share   float64 `json:"share"`
+}
+
+type jsonCacheClass struct {
+	Class     string  `json:"class"`
+	Responses int64   `json:"responses"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_secs"`
+	P95       float64 `json:"p95_secs"`
+	P99       float64 `json:"p99_secs"`
+}
+
+type jsonWorker struct {
+	Worker    string  `json:"worker"`
+	Responses int64   `json:"responses"`
+	ErrorRate float64 `json:"error_rate"`
+	Mean      float64 `json:"mean_secs"`
+	P95       float64 `json:"p95_secs"`
+}
+
+type jsonErrorCount struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+type jsonTTFB struct {
+	Average      float64          `json:"average_secs"`
+	Distribution []jsonPercentile `json:"distribution"`
+}
+
+type jsonCache struct {
+	Conditional int64   `json:"conditional_requests"`
+	NotModified int64   `json:"not_modified"`
+	HitRatio    float64 `json:"hit_ratio"`
+}
+
+type jsonRange struct {
+	Range      string `json:"range"`
+	Responses  int64  `json:"responses"`
+	Honored    int64  `json:"honored"`
+	Full       int64  `json:"full_body"`
+	Mismatched int64  `json:"wrong_content_range"`
+}
+
+type jsonPercentile struct {
+	Percentage int     `json:"percentage"`
+	Latency    float64 `json:"latency_secs"`
+}
+
+type jsonPhase struct {
+	Average float64 `json:"average_secs"`
+	Fastest float64 `json:"fastest_secs"`
+	Slowest float64 `json:"slowest_secs"`
+}
+
+func jsonPercentiles(lds []LatencyDistribution) []jsonPercentile {
+	var res []jsonPercentile
+	for _, ld := range lds {
+		if ld.Percentage > 0 {
+			res = append(res, jsonPercentile{ld.Percentage, ld.Latency})
+		}
+	}
+	return res
+}
+
+func jsonSummary(r Report) jsonReport {
+	j := jsonReport{
+		Name:      r.Name,
+		Total:     r.Total.Seconds(),
+		Slowest:   r.Slowest,
+		Fastest:   r.Fastest,
+		Average:   r.Average,
+		Rps:       r.Rps,
+		Requests:  r.NumRes,
+		Dropped:   r.Dropped,
+		Aborted:   r.Aborted,
+		SizeTotal: r.SizeTotal,
+		SizeReq:   r.SizeReq,
+		Details: map[string]jsonPhase{
+			"dns_dialup": {r.AvgConn, r.ConnMax, r.ConnMin},
+			"dns_lookup": {r.AvgDNS, r.DnsMax, r.DnsMin},
+			"req_write":  {r.AvgReq, r.ReqMax, r.ReqMin},
+			"resp_wait":  {r.AvgDelay, r.DelayMax, r.DelayMin},
+			"resp_read":  {r.AvgRes, r.ResMax, r.ResMin},
+		},
+		StatusCodeDist: r.StatusCodeDist,
+		ErrorDist:      r.ErrorDist,
+		Interrupted:    r.Interrupted,
+		RedirectHops:   r.RedirectHops,
+		EmptyBodies:    r.EmptyBodies,
+	}
+	if a := r.Rate; a != nil {
+		j.Rate = &jsonRate{a.Target, a.Average, a.Min, a.Max, a.Seconds, a.Deviating}
+	}
+	if c := r.ConnRate; c != nil {
+		j.ConnRate = &jsonConnRate{c.Total, c.Average, c.Peak, c.Share}
+	}
+	if cc := r.CacheClasses; cc != nil {
+		for _, c := range cc.Cohorts {
+			j.CacheClasses = append(j.CacheClasses, jsonCacheClass{c.Key, c.Count, c.ErrorRate, c.P50, c.P95, c.P99})
+		}
+	}
+	if ws := r.Workers; ws != nil {
+		for _, c := range ws.Cohorts {
+			j.Workers = append(j.Workers, jsonWorker{c.Key, c.Count, c.ErrorRate, c.Mean, c.P95})
+		}
+	}
+	for _, e := range r.TopErrors {
+		j.TopErrors = append(j.TopErrors, jsonErrorCount{e.Message, e.Count})
+	}
+	if c := r.Cache; c != nil {
+		j.Cache = &jsonCache{c.Conditional, c.NotModified, c.HitRatio}
+	}
+	if rr := r.Range; rr != nil {
+		j.Range = &jsonRange{rr.Range, rr.Responses, rr.Honored, rr.Full, rr.Mismatched}
+	}
+	if d := r.Bodies; d != nil {
+		j.Bodies = &jsonBodies{Distinct: d.Distinct, Untracked: d.Untracked}
+		for _, c := range d.Top {
+			j.Bodies.Top = append(j.Bodies.Top, jsonBody{c.Hash, c.Sample, c.Count})
+		}
+	}
+	if r.Fast {
+		j.Details = nil
+	}
+	j.LatencyDistribution = jsonPercentiles(r.LatencyDistribution)
+	if !r.Fast {
+		j.TTFB = &jsonTTFB{r.AvgDelay, jsonPercentiles(r.TTFBDistribution)}
+	}
+	return j
+}
+
+// csvField quotes s if it can't be used as a csv field as is.
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	}
+	return s
+}
+
 func formatNumber(duration float64) string {
 	return fmt.Sprintf("%4.4f", duration)
 }
@@ -93,13 +340,31 @@ func histogram(buckets []Bucket) string {
 }
 
 var (
-	defaultTmpl = `
-Summary:
+	defaultTmpl = `{{ if .Fault }}
+NOTE: fault injection enabled, {{ .Synthetic }} of {{ .NumRes }} results are synthetic.
+{{ end }}{{ if .CountRedirectHops }}
+NOTE: each redirect followed counts as a request, {{ .RedirectHops }} of the {{ .NumRes }} responses are redirects.
+They count in the totals, requests/sec and latencies, not in the successes, the phase details or the redirect averages.
+{{ end }}{{ if .Aborted }}
+ABORTED: {{ .Aborted }}.
+{{ end }}{{ if .Interrupted }}
+INTERRUPTED: the run was stopped before its end, the results are partial.
+{{ end }}{{ with .Rate }}{{ if .Deviating }}
+WARNING: the rate was more than 10% off -q in {{ .Deviating }} of {{ .Seconds }} seconds, the client or the target couldn't keep up.
+{{ end }}{{ end }}
+Summary:{{ if .Name }} {{ .Name }}{{ end }}
   Total:	{{ formatNumber .Total.Seconds }} secs
   Slowest:	{{ formatNumber .Slowest }} secs
   Fastest:	{{ formatNumber .Fastest }} secs
   Average:	{{ formatNumber .Average }} secs
-  Requests/sec:	{{ formatNumber .Rps }}
+  Requests/sec:	{{ formatNumber .Rps }}{{ with .Rate }}{{ if .Seconds }}
+  Rate achieved:	{{ formatNumber .Average }} req/s on average, {{ .Min }} to {{ .Max }} in a second, for a target of {{ formatNumber .Target }}{{ end }}{{ end }}{{ with .ConnRate }}
+  Connections/sec:	{{ formatNumber .Average }} on average, {{ .Peak }} at peak in a second, {{ .Total }} new connections for {{ formatNumber .Share }}% of the responses{{ end }}{{ if .Timeouts }}
+  Timed out:	{{ formatNumber .TimeoutRate }}% of requests{{ if .Timeout }} at {{ .Timeout }}{{ end }}, {{ .Timeouts }} requests{{ end }}{{ if .FailOnEmptyBody }}
+  Empty bodies:	{{ .EmptyBodies }} responses, counted as errors{{ end }}{{ if .Hedge }}
+  Hedged:	{{ .Hedged }} requests after {{ .Hedge }}, the hedge answered first for {{ .HedgeWins }}{{ end }}{{ if .SuccessTarget }}
+  Successes:	{{ .Successes }} of {{ .NumRes }} responses, the target of {{ .SuccessTarget }} {{ if .AttemptsNeeded }}took {{ .AttemptsNeeded }} requests{{ else }}was not reached{{ end }}{{ end }}{{ if .WaitedReady }}
+  Waited ready:	{{ formatNumber .WaitedReady.Seconds }} secs before the run{{ if .NotReady }}, the target was not ready{{ end }}{{ end }}
   {{ if gt .SizeTotal 0 }}
   Total data:	{{ .SizeTotal }} bytes
   Size/request:	{{ .SizeReq }} bytes{{ end }}
@@ -108,21 +373,96 @@ Response time histogram:
 {{ histogram .Histogram }}
 
 Latency distribution:{{ range .LatencyDistribution }}
-  {{ .Percentage }}%% in {{ formatNumber .Latency }} secs{{ end }}
-
-Details (average, fastest, slowest):
+  {{ .Percentage }}% in {{ formatNumber .Latency }} secs{{ end }}
+{{ if not .Fast }}
+Time to first byte:
+  Average:	{{ formatNumber .AvgDelay }} secs{{ range .TTFBDistribution }}{{ if .Percentage }}
+  {{ .Percentage }}% in {{ formatNumber .Latency }} secs{{ end }}{{ end }}
+{{ end }}
+Details (average, fastest, slowest):{{ if .Fast }}
+  Unavailable, requests were not traced.{{ else }}
   DNS+dialup:	{{ formatNumber .AvgConn }} secs, {{ formatNumber .ConnMax }} secs, {{ formatNumber .ConnMin }} secs
   DNS-lookup:	{{ formatNumber .AvgDNS }} secs, {{ formatNumber .DnsMax }} secs, {{ formatNumber .DnsMin }} secs
   req write:	{{ formatNumber .AvgReq }} secs, {{ formatNumber .ReqMax }} secs, {{ formatNumber .ReqMin }} secs
   resp wait:	{{ formatNumber .AvgDelay }} secs, {{ formatNumber .DelayMax }} secs, {{ formatNumber .DelayMin }} secs
-  resp read:	{{ formatNumber .AvgRes }} secs, {{ formatNumber .ResMax }} secs, {{ formatNumber .ResMin }} secs
-
-Status code distribution:{{ range $code, $num := .StatusCodeDist }}
-  [{{ $code }}]	{{ $num }} responses{{ end }}
+  resp read:	{{ formatNumber .AvgRes }} secs, {{ formatNumber .ResMax }} secs, {{ formatNumber .ResMin }} secs{{ end }}{{ if .ResolvedIP }}
+  DNS (once):	{{ formatNumber .ResolveDuration.Seconds }} secs, resolved to {{ .ResolvedIP }}{{ end }}
 
+{{ if not .Raw }}Status code distribution:{{ range $code, $num := .StatusCodeDist }}
+  [{{ $code }}]	{{ $num }} responses{{ end }}{{ else }}Raw exchanges, the responses have no status code.{{ end }}
+{{ if .NTLM }}
+NTLM auth failures:	{{ .AuthFailures }} responses
+{{ end }}{{ if gt .Dropped 0 }}
+Dropped results:	{{ .Dropped }}, not included in the statistics
+{{ end }}{{ with .Redirects }}
+Redirects:	{{ formatNumber .AvgHops }} per request on average
+Hop latency (average):{{ range $i, $l := .HopLats }}
+  [{{ if $i }}redirect {{ $i }}{{ else }}first{{ end }}]	{{ formatNumber $l }} secs{{ end }}
+{{ end }}{{ with .ReplayLag }}
+Replay lag (behind the recorded offsets):
+  Average:	{{ formatNumber .Average }} secs
+  99% in:	{{ formatNumber .P99 }} secs
+  Slowest:	{{ formatNumber .Max }} secs
+{{ end }}{{ with .Cache }}
+Cache validation:
+  Conditional requests:	{{ .Conditional }}{{ if .Conditional }}
+  304 responses:	{{ .NotModified }}, {{ formatNumber .HitRatio }}% hit ratio{{ else }}
+  No ETag was received, so no request was conditional.{{ end }}
+{{ end }}{{ with .Range }}
+Range requests ({{ .Range }}):
+  206 responses:	{{ .Honored }}, {{ formatNumber .HonoredRatio }}% honored the range
+  200 responses:	{{ .Full }}, {{ formatNumber .FullRatio }}% with the full body{{ if .Mismatched }}
+  Wrong Content-Range:	{{ .Mismatched }} 206 responses, counted as errors{{ end }}
+{{ end }}{{ with .Compression }}
+Compression (Accept-Encoding: {{ .AcceptEncoding }}):
+  On the wire:	{{ .WireSize }} bytes
+  Decompressed:	{{ .DecodedSize }} bytes
+  Ratio:	{{ formatNumber .Ratio }}, {{ .Compressed }} of {{ .Responses }} responses compressed
+{{ end }}{{ with .Bodies }}
+Distinct response bodies:	{{ .Distinct }}{{ if .Untracked }}, and {{ .Untracked }} responses with more bodies not tracked{{ end }}{{ range .Top }}
+  [{{ .Count }} responses]	{{ .Hash }} {{ printf "%q" .Sample }}{{ end }}
+{{ end }}{{ if .ServerTiming }}
+Server-Timing (average):{{ range .ServerTimings }}
+  [{{ .Name }}]	{{ formatNumber .Average }} ms, {{ .Count }} responses{{ else }}
+  No metrics with a duration.{{ end }}
+{{ end }}{{ if or .FullHandshakes .ResumedHandshakes }}
+TLS handshakes:	{{ .FullHandshakes }} full, {{ .ResumedHandshakes }} resumed
+{{ end }}{{ if .ALPN }}
+Negotiated protocols (ALPN):{{ range $proto, $num := .ALPN }}
+  [{{ if $proto }}{{ $proto }}{{ else }}none{{ end }}]	{{ $num }} connections{{ end }}
+{{ end }}{{ with .ConnReuse }}
+Requests per connection:	{{ formatNumber .Average }} on average, {{ .Max }} at most, {{ .Conns }} connections{{ range .Buckets }}
+  [{{ .Min }}{{ if .Max }}{{ if ne .Min .Max }}-{{ .Max }}{{ end }}{{ else }}+{{ end }}]	{{ .Conns }} connections{{ end }}
+{{ end }}{{ if .MaxInFlight }}
+In flight:	at most {{ .InFlightMax }} requests, capped at {{ .MaxInFlight }}
+{{ end }}{{ if .WarmConns }}
+Unexpected new connections:	{{ .UnexpectedConns }}
+{{ end }}{{ if .SlowRequests }}
+Slowest requests:{{ range .SlowRequests }}
+  [{{ formatNumber .Duration.Seconds }} secs]	{{ .Method }} {{ .URL }} {{ if .Error }}{{ .Error }}{{ else }}{{ .StatusCode }}{{ end }}, at {{ formatNumber .Offset.Seconds }} secs{{ if not (or $.Fast .Error) }}
+    DNS+dialup {{ formatNumber .Conn.Seconds }}, req write {{ formatNumber .Req.Seconds }}, resp wait {{ formatNumber .Delay.Seconds }}, resp read {{ formatNumber .Res.Seconds }} secs{{ end }}{{ end }}
+{{ end }}{{ range .Breakdowns }}
+{{ .Title }} breakdown (responses, errors, p95):{{ range .Cohorts }}
+  [{{ .Key }}]	{{ .Count }} responses, {{ formatNumber .ErrorRate }}% errors, {{ formatNumber .P95 }} secs{{ end }}
+{{ end }}{{ with .CacheClasses }}
+Latency by {{ .Title }} (responses, errors, p50, p95, p99):{{ range .Cohorts }}
+  [{{ .Key }}]	{{ .Count }} responses, {{ formatNumber .ErrorRate }}% errors, {{ formatNumber .P50 }}, {{ formatNumber .P95 }}, {{ formatNumber .P99 }} secs{{ end }}
+{{ end }}{{ with .Workers }}
+Workers (responses, errors, mean, p95):{{ range .Cohorts }}
+  [{{ .Key }}]	{{ .Count }} responses, {{ formatNumber .ErrorRate }}% errors, {{ formatNumber .Mean }}, {{ formatNumber .P95 }} secs{{ end }}
+{{ end }}
 {{ if gt (len .ErrorDist) 0 }}Error distribution:{{ range $err, $num := .ErrorDist }}
-  [count: {{ $num }}]	{{ $err }}{{ end }}{{ end }}
+  [count: {{ $num }}]	{{ $err }}{{ end }}{{ end }}{{ if .TopErrors }}
+
+Top errors, addresses masked:{{ range .TopErrors }}
+  [count: {{ .Count }}]	{{ .Message }}{{ end }}{{ end }}
 `
-	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $dnsLats := .DnsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets}}response-time,DNS+dialup,DNS,Request-write,Response-delay,Response-read,status-code,offset{{ range $i, $v := .Lats }}
-{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }}{{ end }}`
+	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets}}response-time,DNS+dialup,DNS,Request-write,Response-delay,Response-read,status-code,offset{{ range $.CSVHeaders }},{{ csvField . }}{{ end }}{{ if $.Name }},name{{ end }}{{ range $i, $v := .Lats }}
+{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }}{{ if $.CSVHeaders }}{{ range (index $.HeaderValues $i) }},{{ csvField . }}{{ end }}{{ end }}{{ if $.Name }},{{ csvField $.Name }}{{ end }}{{ end }}`
+	jsonTmpl = `{{ jsonify (jsonSummary .) }}`
+	abTmpl   = `{{ abSummary . }}`
+	wrkTmpl  = `{{ wrkSummary . }}`
+
+	influxTmpl = `{{ range $i, $line := .InfluxLines }}{{ if $i }}
+{{ end }}{{ $line }}{{ end }}`
 )
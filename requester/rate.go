@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"time"
+)
+
+// rateTolerance is how far, as a fraction of QPS, the rate of a second of
+// the run may be from QPS before it is flagged.
+const rateTolerance = 0.1
+
+// rateStats counts the requests started in each second of the run, to
+// audit the rate achieved against QPS.
+type rateStats struct {
+	qps    float64
+	counts []int64 // by second since the start
+}
+
+func (rs *rateStats) add(offset time.Duration) {
+	s := int(offset / time.Second)
+	if s < 0 {
+		return
+	}
+	for len(rs.counts) <= s {
+		rs.counts = append(rs.counts, 0)
+	}
+	rs.counts[s]++
+}
+
+func (rs *rateStats) merge(o *rateStats) {
+	for s, n := range o.counts {
+		for len(rs.counts) <= s {
+			rs.counts = append(rs.counts, 0)
+		}
+		rs.counts[s] += n
+	}
+}
+
+// snapshot audits the whole seconds of a run of total duration, the last
+// partial one is left out.
+func (rs *rateStats) snapshot(total time.Duration) *RateAudit {
+	a := &RateAudit{Target: rs.qps, Seconds: int(total / time.Second)}
+	if a.Seconds == 0 {
+		return a
+	}
+	var sum int64
+	for s := 0; s < a.Seconds; s++ {
+		var n int64
+		if s < len(rs.counts) {
+			n = rs.counts[s]
+		}
+		sum += n
+		if s == 0 || n < a.Min {
+			a.Min = n
+		}
+		if n > a.Max {
+			a.Max = n
+		}
+		if math.Abs(float64(n)-rs.qps) > rateTolerance*rs.qps {
+			a.Deviating++
+		}
+	}
+	a.Average = float64(sum) / float64(a.Seconds)
+	return a
+}
+
+// RateAudit compares the request rate achieved in each second of the run
+// to the target rate, QPS.
+type RateAudit struct {
+	Target  float64
+	Average float64 // requests started per second
+	// Min and Max are the fewest and most requests started in a second.
+	Min, Max int64
+	// Seconds is the number of whole seconds of the run, and Deviating how
+	// many of them were more than 10% off the target, a sign that the
+	// client or the target couldn't keep up.
+	Seconds, Deviating int
+}
+
+// connRate is the rate of the new connections counted over a run of total
+// duration with that many responses, nil if there were none.
+func (rs *rateStats) connRate(total time.Duration, responses int64) *ConnRate {
+	c := &ConnRate{}
+	for _, n := range rs.counts {
+		c.Total += n
+		if n > c.Peak {
+			c.Peak = n
+		}
+	}
+	if c.Total == 0 {
+		return nil
+	}
+	if total > 0 {
+		c.Average = float64(c.Total) / total.Seconds()
+	}
+	if responses > 0 {
+		c.Share = float64(c.Total) * 100 / float64(responses)
+	}
+	return c
+}
+
+// ConnRate is the rate new connections were established at, dials and TLS
+// handshakes included, apart from the request rate. It's the number to
+// watch without keep-alive.
+type ConnRate struct {
+	Total   int64   // new connections
+	Average float64 // per second over the run
+	Peak    int64   // most in a second
+	Share   float64 // percentage of the responses on a new connection
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"time"
+)
+
+var errNoRawResponse = errors.New("connection closed without a response")
+
+// rawBufSize is the size of the reads of the responses of Raw.
+const rawBufSize = 32 << 10
+
+// sendRaw sends Raw over a new connection in place of an HTTP request, and
+// reports the exchange as a result.
+func (b *Work) sendRaw() {
+	if b.inflight != nil {
+		b.enterFlight()
+	}
+	s := now()
+	res := &result{offset: s}
+	res.err = b.exchangeRaw(res)
+	if b.inflight != nil {
+		b.leaveFlight()
+	}
+	res.duration = now() - s
+	b.sendResult(res)
+}
+
+// exchangeRaw dials the host of Request, with TLS for https, writes Raw
+// and reads the response until the server closes the connection, or
+// RawReadBytes were read. It sets the phases and size of res.
+func (b *Work) exchangeRaw(res *result) error {
+	ctx := context.Background()
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(b.Timeout)*time.Second)
+		defer cancel()
+	}
+	start := now()
+	conn, err := b.rawDial(ctx, "tcp", canonicalAddr(b.Request))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	if b.Request.URL.Scheme == "https" {
+		tc := tls.Client(conn, b.rawTLS)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		conn = tc
+	}
+	written := now()
+	res.connDuration = written - start
+	if _, err := conn.Write(b.Raw); err != nil {
+		return err
+	}
+	sent := now()
+	res.reqDuration = sent - written
+
+	buf := make([]byte, rawBufSize)
+	var total int64
+	for {
+		n, err := conn.Read(buf)
+		if total == 0 && n > 0 {
+			res.delayDuration = now() - sent
+		}
+		total += int64(n)
+		if err == io.EOF || b.RawReadBytes > 0 && total >= int64(b.RawReadBytes) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res.contentLength = total
+	if total == 0 {
+		return errNoRawResponse
+	}
+	res.resDuration = now() - sent - res.delayDuration
+	return nil
+}
+
+// rawConfig is the TLS configuration of the connections of Raw, which
+// speak whatever protocol Raw is in.
+func rawConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	config.NextProtos = nil
+	return config
+}
@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// probeInterval is the pause between two probes of WaitReady.
+const probeInterval = 250 * time.Millisecond
+
+// WaitUntilReady probes the target until it is ready, for up to WaitReady,
+// and does nothing if WaitReady isn't set. Run calls it before starting,
+// calling it earlier keeps the wait out of a time limit of the run. Init
+// must have been called.
+func (b *Work) WaitUntilReady() {
+	if b.WaitReady <= 0 {
+		return
+	}
+	b.readyOnce.Do(func() {
+		s := now()
+		err := b.probeUntilReady()
+		b.readyWait = now() - s
+		if err != nil {
+			b.notReady = true
+			b.logf("wait-ready: target not ready after %v: %v, starting anyway", b.readyWait.Round(time.Millisecond), err)
+		}
+	})
+}
+
+// probeUntilReady sends GET probes to the URL of Request until one gets a
+// response below 500. It returns the last error if none did in time, or
+// if the run was stopped meanwhile.
+func (b *Work) probeUntilReady() error {
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         b.Request.Host,
+		},
+		DisableKeepAlives: true,
+		Proxy:             http.ProxyURL(b.ProxyAddr),
+	}
+	client := &http.Client{Transport: tr, Timeout: time.Duration(b.Timeout) * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), b.WaitReady)
+	defer cancel()
+
+	pause := time.NewTimer(0)
+	defer pause.Stop()
+	<-pause.C
+	for {
+		err := b.probe(ctx, client)
+		if err == nil {
+			return nil
+		}
+		pause.Reset(probeInterval)
+		select {
+		case <-b.stopCh:
+			// leave the stop signal to a worker
+			b.stopCh <- struct{}{}
+			return fmt.Errorf("stopped, last probe: %v", err)
+		case <-ctx.Done():
+			return err
+		case <-pause.C:
+		}
+	}
+}
+
+func (b *Work) probe(ctx context.Context, client *http.Client) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", b.Request.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = b.Request.Header.Clone()
+	req.Host = b.Request.Host
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
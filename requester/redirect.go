@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// redirectTrace times the hops of a request following redirects. A hop
+// ends when its redirect response is received, the last one when the
+// request is done.
+type redirectTrace struct {
+	last time.Duration
+	hops []time.Duration
+}
+
+type redirectKey struct{}
+
+func withRedirectTrace(ctx context.Context, rt *redirectTrace) context.Context {
+	return context.WithValue(ctx, redirectKey{}, rt)
+}
+
+// hop records the end of a hop at t.
+func (rt *redirectTrace) hop(t time.Duration) {
+	rt.hops = append(rt.hops, t-rt.last)
+	rt.last = t
+}
+
+// traceRedirect records a redirect response of the request of ctx, if it
+// is traced. Redirected requests keep the context of the first one.
+func traceRedirect(ctx context.Context) {
+	if rt, ok := ctx.Value(redirectKey{}).(*redirectTrace); ok {
+		rt.hop(now())
+	}
+}
+
+// hopStart is when the current hop of a request following redirects
+// started, with CountRedirectHops.
+type hopStart struct {
+	at     time.Duration
+	worker int
+}
+
+type hopKey struct{}
+
+func withHopStart(ctx context.Context, hs *hopStart) context.Context {
+	return context.WithValue(ctx, hopKey{}, hs)
+}
+
+// countHop reports the redirect response to the last request of via as a
+// result of its own, then starts timing the hop of req.
+func (b *Work) countHop(req *http.Request, via []*http.Request) {
+	hs, ok := req.Context().Value(hopKey{}).(*hopStart)
+	if !ok || req.Response == nil {
+		return
+	}
+	t := now()
+	prev := via[len(via)-1]
+	res := &result{
+		offset:      hs.at,
+		statusCode:  req.Response.StatusCode,
+		duration:    t - hs.at,
+		method:      prev.Method,
+		redirectHop: true,
+		worker:      hs.worker,
+	}
+	if b.PerURL || b.SlowRequests > 0 {
+		res.url = prev.URL.String()
+	}
+	hs.at = t
+	b.sendResult(res)
+}
+
+// redirectStats aggregates the hops of the results.
+type redirectStats struct {
+	redirects int64
+	hopSums   []float64
+	hopCounts []int64
+}
+
+func (rs *redirectStats) add(hops []time.Duration) {
+	rs.redirects += int64(len(hops) - 1)
+	for i, h := range hops {
+		if i == len(rs.hopSums) {
+			rs.hopSums = append(rs.hopSums, 0)
+			rs.hopCounts = append(rs.hopCounts, 0)
+		}
+		rs.hopSums[i] += h.Seconds()
+		rs.hopCounts[i]++
+	}
+}
+
+func (rs *redirectStats) merge(o *redirectStats) {
+	rs.redirects += o.redirects
+	for i := range o.hopSums {
+		if i == len(rs.hopSums) {
+			rs.hopSums = append(rs.hopSums, 0)
+			rs.hopCounts = append(rs.hopCounts, 0)
+		}
+		rs.hopSums[i] += o.hopSums[i]
+		rs.hopCounts[i] += o.hopCounts[i]
+	}
+}
+
+func (rs *redirectStats) snapshot(numRes int64) *Redirects {
+	s := &Redirects{}
+	if numRes > 0 {
+		s.AvgHops = float64(rs.redirects) / float64(numRes)
+	}
+	for i, sum := range rs.hopSums {
+		s.HopLats = append(s.HopLats, sum/float64(rs.hopCounts[i]))
+	}
+	return s
+}
+
+// Redirects summarizes the redirects followed by the requests.
+type Redirects struct {
+	// AvgHops is the average number of redirects per request.
+	AvgHops float64
+	// HopLats are the average latencies of the first request, of the
+	// first redirected one and so on, in seconds.
+	HopLats []float64
+}
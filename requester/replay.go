@@ -0,0 +1,99 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduled is a request of Replay, sent at Offset from the start of the run.
+type Scheduled struct {
+	Offset  time.Duration
+	Request *http.Request
+	Body    string
+}
+
+func (s Scheduled) clone() *http.Request {
+	r := s.Request.Clone(context.Background())
+	if s.Body != "" {
+		r.Body = ioutil.NopCloser(strings.NewReader(s.Body))
+	}
+	return r
+}
+
+// runReplay fires the requests of Replay at their offsets, each on its own
+// goroutine so that slow responses don't delay the next ones.
+func (b *Work) runReplay(client *http.Client) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for i, s := range b.Replay {
+		if wait := s.Offset - (now() - b.start); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-b.stopCh:
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b.makeRequest(-1, i, client)
+		}(i)
+	}
+}
+
+// lagStats aggregates how late the requests of Replay were sent.
+type lagStats struct {
+	est *estimator
+	sum float64
+}
+
+func (ls *lagStats) add(lag time.Duration) {
+	ls.est.add(lag.Seconds())
+	ls.sum += lag.Seconds()
+}
+
+func (ls *lagStats) merge(o *lagStats) {
+	ls.est.combine(o.est)
+	ls.sum += o.sum
+}
+
+func (ls *lagStats) snapshot() *ReplayLag {
+	s := &ReplayLag{P99: ls.est.quantile(0.99), Max: ls.est.max}
+	if ls.est.n > 0 {
+		s.Average = ls.sum / float64(ls.est.n)
+	}
+	return s
+}
+
+// ReplayLag is how late the requests of Replay were sent after their
+// offsets, in seconds.
+type ReplayLag struct {
+	Average, P99, Max float64
+}
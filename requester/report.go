@@ -16,11 +16,18 @@ package requester
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -51,110 +58,386 @@ type report struct {
 	offsets     []float64
 	statusCodes []int
 
+	// distributions for the summary; the values of each result in lats,
+	// connLats and the like are only kept for the csv output
+	latEst         *estimator
+	connEst        *estimator
+	dnsEst         *estimator
+	reqEst         *estimator
+	resEst         *estimator
+	delayEst       *estimator
+	statusCodeDist map[int]int
+
 	results chan *result
 	done    chan bool
 	total   time.Duration
 
 	errorDist map[string]int
+	errorMsgs map[string]int // errors of the requests, normalized
+	numErrors int64          // requests without a response
 	lats      []float64
 	sizeTotal int64
 	numRes    int64
 	output    string
 
+	ntlm         bool
+	authFailures int64
+
+	breakdowns []*breakdown
+
+	// requests started by second, with QPS
+	rates *rateStats
+
+	// requests sent on a new connection, by second
+	conns *rateStats
+
+	// responses to the Range requests, see Work.Range
+	ranges *rangeStats
+
+	// results by worker, see Work.PerWorker
+	workers *breakdown
+
+	// latencies by cache status, see Work.CacheHeader
+	cacheClasses *breakdown
+
+	csvHeaders []string
+	headers    [][]string
+
+	warmConns       bool
+	unexpectedConns int64
+
+	fault     bool
+	synthetic int64
+
+	name string
+
+	onResult    func(Result)
+	success     *Predicate
+	summaryTmpl *template.Template
+	quiet       bool
+	color       string
+
+	// for the progress printed during the run
+	start    time.Duration
+	progress chan progressRequest
+
+	fast bool
+
+	respCheckFailures int64
+
+	failEmpty   bool  // FailOnEmptyBody
+	emptyBodies int64 // responses failing it
+
+	redirects     *redirectStats
+	cache         *cacheStats
+	bodies        *bodyStats
+	compression   *compressionStats
+	slowRequests  *slowRequests
+	lags          *lagStats
+	serverTimings serverTimingStats
+
+	fullHandshakes, resumedHandshakes int64
+	alpn                              map[string]int64 // connections by negotiated protocol
+
+	connReuse *ConnReuse
+
+	maxInFlight int
+	inflightMax int64
+
+	raw bool // the results are exchanges of Work.Raw
+
+	// redirects followed, counted as results with countHops
+	countHops    bool
+	redirectHops int64
+
+	// requests hedged after hedge, and those the hedge answered first
+	hedge             time.Duration
+	hedged, hedgeWins int64
+
+	// stop aborts the run when errorWindow's rate is exceeded, see aborted
+	errorWindow *errorWindow
+	stop        func()
+	aborted     string
+	interrupted bool
+
+	// the run is also stopped once successTarget responses succeeded
+	successTarget, successes, attemptsNeeded int64
+
+	conc     int
+	timeout  time.Duration // per request, 0 if none
+	timeouts int64
+
+	resolveDuration time.Duration
+	resolvedIP      string
+
+	readyWait time.Duration
+	notReady  bool
+
+	dropped int64
+
+	// points of the influx output, timestamped from wallStart
+	influx    influxStats
+	wallStart time.Time
+
+	// reporters aggregating the results, see runShards
+	reporters int
+	mu        sync.Mutex // held by a shard while adding a result
+
 	w io.Writer
 }
 
-func newReport(w io.Writer, results chan *result, output string, n int) *report {
-	cap := min(n, maxRes)
-	return &report{
-		output:      output,
-		results:     results,
-		done:        make(chan bool, 1),
-		errorDist:   make(map[string]int),
-		w:           w,
-		connLats:    make([]float64, 0, cap),
-		dnsLats:     make([]float64, 0, cap),
-		reqLats:     make([]float64, 0, cap),
-		resLats:     make([]float64, 0, cap),
-		delayLats:   make([]float64, 0, cap),
-		lats:        make([]float64, 0, cap),
-		statusCodes: make([]int, 0, cap),
+// newReport returns a report of the results. Percentiles are estimated with
+// bounded memory unless exact is set.
+func newReport(w io.Writer, results chan *result, output string, n int, exact bool) *report {
+	r := &report{
+		output:         output,
+		results:        results,
+		done:           make(chan bool, 1),
+		errorDist:      make(map[string]int),
+		errorMsgs:      make(map[string]int),
+		statusCodeDist: make(map[int]int),
+		w:              w,
+		latEst:         newEstimator(exact),
+		connEst:        newEstimator(exact),
+		dnsEst:         newEstimator(exact),
+		reqEst:         newEstimator(exact),
+		resEst:         newEstimator(exact),
+		delayEst:       newEstimator(exact),
+		conns:          &rateStats{},
+	}
+	if output == "csv" {
+		cap := min(n, maxRes)
+		r.connLats = make([]float64, 0, cap)
+		r.dnsLats = make([]float64, 0, cap)
+		r.reqLats = make([]float64, 0, cap)
+		r.resLats = make([]float64, 0, cap)
+		r.delayLats = make([]float64, 0, cap)
+		r.lats = make([]float64, 0, cap)
+		r.statusCodes = make([]int, 0, cap)
 	}
+	return r
 }
 
 func runReporter(r *report) {
+	if r.reporters > 1 && r.shardable() {
+		runShards(r)
+		return
+	}
 	// Loop will continue until channel is closed
-	for res := range r.results {
-		r.numRes++
-		if res.err != nil {
-			r.errorDist[res.err.Error()]++ //直接用map key去重
-		} else {
-			if len(res.respbodyCompare) != 0 {
-				for _, item := range res.respbodyCompare {
-					// if exist, _ := regexp.Match(item, res.respbody); !exist {
-					// 	r.errorDist[item]++
-					// }
-
-					if !strings.Contains(string(res.respbody), item) {
-						r.errorDist[item]++
-					}
+	for {
+		select {
+		case res, ok := <-r.results:
+			if !ok {
+				// Signal reporter is done.
+				r.done <- true
+				return
+			}
+			r.add(res)
+		case req := <-r.progress:
+			r.printProgress(req.w, req.paused)
+			close(req.done)
+		}
+	}
+}
+
+// add counts a result in the report.
+func (r *report) add(res *result) {
+	r.numRes++
+	if res.redirectHop {
+		r.redirectHops++
+	}
+	if res.err == nil && res.failure == "" && !res.redirectHop && r.success != nil && !r.success.match(res) {
+		res.failure = "success condition not met"
+	}
+	if r.onResult != nil {
+		r.onResult(Result(*res))
+	}
+	if r.ntlm && res.statusCode == http.StatusUnauthorized {
+		r.authFailures++
+	}
+	for _, bd := range r.breakdowns {
+		bd.add(res)
+	}
+	if r.cacheClasses != nil {
+		r.cacheClasses.add(res)
+	}
+	if r.workers != nil {
+		r.workers.add(res)
+	}
+	if r.rates != nil && !res.redirectHop {
+		r.rates.add(res.offset - r.start)
+	}
+	if res.newConn {
+		r.conns.add(res.offset - r.start)
+	}
+	if res.synthetic {
+		r.synthetic++
+	}
+	if res.emptyBody {
+		r.emptyBodies++
+	}
+	if res.hedge != hedgeNone {
+		r.hedged++
+		if res.hedge == hedgeWon {
+			r.hedgeWins++
+		}
+	}
+	if r.bodies != nil {
+		r.bodies.add(res)
+	}
+	if r.compression != nil {
+		r.compression.add(res)
+	}
+	if r.slowRequests != nil {
+		r.slowRequests.add(res)
+	}
+	if r.cache != nil {
+		r.cache.add(res)
+	}
+	if r.ranges != nil {
+		r.ranges.add(res)
+	}
+	if r.lags != nil {
+		r.lags.add(res.lag)
+	}
+	switch res.handshake {
+	case handshakeFull:
+		r.fullHandshakes++
+	case handshakeResumed:
+		r.resumedHandshakes++
+	}
+	if r.alpn != nil && res.handshake != handshakeNone {
+		r.alpn[res.alpn]++
+	}
+	if r.errorWindow != nil && r.aborted == "" {
+		if r.aborted = r.errorWindow.add(res.err != nil || res.failure != ""); r.aborted != "" {
+			r.stop()
+		}
+	}
+	if r.influx != nil {
+		r.influx.add(res, r.start)
+	}
+	if r.redirects != nil && res.hops != nil {
+		r.redirects.add(res.hops)
+	}
+	if r.serverTimings != nil {
+		r.serverTimings.add(res.serverTimings)
+	}
+	if res.err != nil {
+		r.errorDist[res.err.Error()]++ //直接用map key去重
+		r.errorMsgs[normalizeError(res.err.Error())]++
+		r.numErrors++
+		if isTimeout(res.err) {
+			r.timeouts++
+		}
+	} else {
+		if res.failure != "" {
+			r.errorDist[res.failure]++
+		}
+		ok := res.failure == ""
+		if len(res.respbodyCompare) != 0 {
+			failed := false
+			for _, item := range res.respbodyCompare {
+				// if exist, _ := regexp.Match(item, res.respbody); !exist {
+				// 	r.errorDist[item]++
+				// }
+
+				if !strings.Contains(string(res.respbody), item) {
+					r.errorDist[item]++
+					failed = true
 				}
 			}
-			r.avgTotal += res.duration.Seconds()
+			if failed {
+				r.respCheckFailures++
+				ok = false
+			}
+		}
+		if ok && !res.redirectHop {
+			r.successes++
+			if r.successes == r.successTarget {
+				r.attemptsNeeded = r.numRes
+				r.stop()
+			}
+		}
+		r.avgTotal += res.duration.Seconds()
+		r.latEst.add(res.duration.Seconds())
+		// redirects counted as results are not traced, they have no phases
+		if !res.redirectHop {
 			r.avgConn += res.connDuration.Seconds()
 			r.avgDelay += res.delayDuration.Seconds()
 			r.avgDNS += res.dnsDuration.Seconds()
 			r.avgReq += res.reqDuration.Seconds()
 			r.avgRes += res.resDuration.Seconds()
-			if len(r.resLats) < maxRes {
-				r.lats = append(r.lats, res.duration.Seconds())
-				r.connLats = append(r.connLats, res.connDuration.Seconds())
-				r.dnsLats = append(r.dnsLats, res.dnsDuration.Seconds())
-				r.reqLats = append(r.reqLats, res.reqDuration.Seconds())
-				r.delayLats = append(r.delayLats, res.delayDuration.Seconds())
-				r.resLats = append(r.resLats, res.resDuration.Seconds())
-				r.statusCodes = append(r.statusCodes, res.statusCode)
-				r.offsets = append(r.offsets, res.offset.Seconds())
-			}
-			if res.contentLength > 0 {
-				r.sizeTotal += res.contentLength
+			r.connEst.add(res.connDuration.Seconds())
+			r.dnsEst.add(res.dnsDuration.Seconds())
+			r.reqEst.add(res.reqDuration.Seconds())
+			r.resEst.add(res.resDuration.Seconds())
+			r.delayEst.add(res.delayDuration.Seconds())
+		}
+		r.statusCodeDist[res.statusCode]++
+		if r.output == "csv" && len(r.lats) < maxRes {
+			r.lats = append(r.lats, res.duration.Seconds())
+			r.connLats = append(r.connLats, res.connDuration.Seconds())
+			r.dnsLats = append(r.dnsLats, res.dnsDuration.Seconds())
+			r.reqLats = append(r.reqLats, res.reqDuration.Seconds())
+			r.delayLats = append(r.delayLats, res.delayDuration.Seconds())
+			r.resLats = append(r.resLats, res.resDuration.Seconds())
+			r.statusCodes = append(r.statusCodes, res.statusCode)
+			r.offsets = append(r.offsets, res.offset.Seconds())
+			if len(r.csvHeaders) > 0 {
+				r.headers = append(r.headers, res.headers)
 			}
 		}
+		if res.contentLength > 0 {
+			r.sizeTotal += res.contentLength
+		}
 	}
-	// Signal reporter is done.
-	r.done <- true
 }
 
 func (r *report) finalize(total time.Duration) {
 	r.total = total
-	r.rps = float64(r.numRes) / r.total.Seconds()
-	r.average = r.avgTotal / float64(len(r.lats))
-	r.avgConn = r.avgConn / float64(len(r.lats))
-	r.avgDelay = r.avgDelay / float64(len(r.lats))
-	r.avgDNS = r.avgDNS / float64(len(r.lats))
-	r.avgReq = r.avgReq / float64(len(r.lats))
-	r.avgRes = r.avgRes / float64(len(r.lats))
+	// dropped results were still requests sent
+	r.rps = float64(r.numRes+r.dropped) / r.total.Seconds()
+	r.average = r.avgTotal / float64(r.latEst.n)
+	// the phases leave out the redirects counted as results
+	numPhases := float64(r.connEst.n)
+	r.avgConn = r.avgConn / numPhases
+	r.avgDelay = r.avgDelay / numPhases
+	r.avgDNS = r.avgDNS / numPhases
+	r.avgReq = r.avgReq / numPhases
+	r.avgRes = r.avgRes / numPhases
 	r.print()
 }
 
 func (r *report) print() {
-	if r.output != "" {
+	summary := newTemplate("")
+	if r.summaryTmpl != nil {
+		summary = r.summaryTmpl
+	}
+	// the ab and wrk outputs are summaries already
+	if r.output != "" && r.output != "ab" && r.output != "wrk" && !r.quiet {
 		buf := &bytes.Buffer{}
-		if err := newTemplate("").Execute(buf, r.snapshot()); err != nil {
+		if err := summary.Execute(buf, r.snapshot()); err != nil {
 			log.Println("error:", err.Error())
 			return
 		}
-		r.printf(buf.String())
-		r.printf("\n")
+		r.printf("%s\n", buf.String())
 	}
 
+	tmpl := summary
+	if r.output != "" {
+		tmpl = newTemplate(r.output)
+	}
 	buf := &bytes.Buffer{}
-	if err := newTemplate(r.output).Execute(buf, r.snapshot()); err != nil {
+	if err := tmpl.Execute(buf, r.snapshot()); err != nil {
 		log.Println("error:", err.Error())
 		return
 	}
-	r.printf(buf.String())
-	r.printf("\n")
+	out := buf.String()
+	if r.output == "" && r.summaryTmpl == nil && useColor(r.color, r.w) {
+		out = colorize(out)
+	}
+	r.printf("%s\n", out)
 }
 
 func (r *report) printf(s string, v ...interface{}) {
@@ -185,11 +468,106 @@ func (r *report) snapshot() Report {
 		StatusCodes: make([]int, len(r.lats)),
 	}
 
-	if len(r.lats) == 0 {
+	snapshot.NTLM = r.ntlm
+	snapshot.AuthFailures = r.authFailures
+	snapshot.Timeout = r.timeout
+	snapshot.Concurrency = r.conc
+	if pctls, ok := compatPercentiles[r.output]; ok {
+		for _, p := range pctls {
+			snapshot.CompatDistribution = append(snapshot.CompatDistribution, LatencyDistribution{Percentage: p, Latency: r.latEst.quantile(float64(p) / 100)})
+		}
+		snapshot.LatencyStdev = r.latEst.stddev()
+	}
+	snapshot.Timeouts = r.timeouts
+	snapshot.TopErrors = topErrorCounts(r.errorMsgs, topErrors)
+	snapshot.FailOnEmptyBody = r.failEmpty
+	snapshot.EmptyBodies = r.emptyBodies
+	if r.numRes > 0 {
+		snapshot.TimeoutRate = float64(r.timeouts) * 100 / float64(r.numRes)
+	}
+	snapshot.WarmConns = r.warmConns
+	snapshot.UnexpectedConns = r.unexpectedConns
+	snapshot.Name = r.name
+	snapshot.Fault = r.fault
+	snapshot.Fast = r.fast
+	snapshot.Dropped = r.dropped
+	snapshot.ResolveDuration = r.resolveDuration
+	snapshot.ResolvedIP = r.resolvedIP
+	snapshot.WaitedReady = r.readyWait
+	snapshot.NotReady = r.notReady
+	if r.redirects != nil {
+		snapshot.Redirects = r.redirects.snapshot(r.numRes - r.numErrors - r.redirectHops)
+	}
+	if r.cache != nil {
+		snapshot.Cache = r.cache.snapshot()
+	}
+	if r.ranges != nil {
+		snapshot.Range = r.ranges.snapshot()
+	}
+	if r.bodies != nil {
+		snapshot.Bodies = r.bodies.snapshot()
+	}
+	if r.compression != nil {
+		snapshot.Compression = r.compression.snapshot()
+	}
+	if r.slowRequests != nil {
+		snapshot.SlowRequests = r.slowRequests.snapshot()
+	}
+	if r.lags != nil {
+		snapshot.ReplayLag = r.lags.snapshot()
+	}
+	snapshot.Aborted = r.aborted
+	snapshot.Interrupted = r.interrupted
+	snapshot.SuccessTarget = r.successTarget
+	snapshot.Successes = r.successes
+	snapshot.AttemptsNeeded = r.attemptsNeeded
+	snapshot.FullHandshakes = r.fullHandshakes
+	snapshot.ResumedHandshakes = r.resumedHandshakes
+	snapshot.ALPN = r.alpn
+	snapshot.ConnReuse = r.connReuse
+	snapshot.MaxInFlight = r.maxInFlight
+	snapshot.InFlightMax = r.inflightMax
+	if r.serverTimings != nil {
+		snapshot.ServerTiming = true
+		snapshot.ServerTimings = r.serverTimings.snapshot()
+	}
+	snapshot.Synthetic = r.synthetic
+	snapshot.CountRedirectHops = r.countHops
+	snapshot.Raw = r.raw
+	if r.output == "influx" {
+		snapshot.InfluxLines = r.influx.lines(r.wallStart, r.name)
+	}
+	snapshot.RedirectHops = r.redirectHops
+	snapshot.Hedge = r.hedge
+	snapshot.Hedged = r.hedged
+	snapshot.HedgeWins = r.hedgeWins
+	for _, bd := range r.breakdowns {
+		snapshot.Breakdowns = append(snapshot.Breakdowns, bd.snapshot())
+	}
+	if r.rates != nil {
+		snapshot.Rate = r.rates.snapshot(r.total)
+	}
+	snapshot.ConnRate = r.conns.connRate(r.total, r.numRes)
+	if r.cacheClasses != nil {
+		cc := r.cacheClasses.snapshot()
+		snapshot.CacheClasses = &cc
+	}
+	if r.workers != nil {
+		ws := r.workers.snapshot()
+		// in the order of the workers rather than of their keys
+		sort.Slice(ws.Cohorts, func(i, j int) bool {
+			a, _ := strconv.Atoi(ws.Cohorts[i].Key)
+			b, _ := strconv.Atoi(ws.Cohorts[j].Key)
+			return a < b
+		})
+		snapshot.Workers = &ws
+	}
+
+	if r.latEst.n == 0 {
 		return snapshot
 	}
 
-	snapshot.SizeReq = r.sizeTotal / int64(len(r.lats))
+	snapshot.SizeReq = r.sizeTotal / r.latEst.n
 
 	copy(snapshot.Lats, r.lats)
 	copy(snapshot.ConnLats, r.connLats)
@@ -199,57 +577,48 @@ func (r *report) snapshot() Report {
 	copy(snapshot.DelayLats, r.delayLats)
 	copy(snapshot.StatusCodes, r.statusCodes)
 	copy(snapshot.Offsets, r.offsets)
+	if len(r.csvHeaders) > 0 {
+		snapshot.CSVHeaders = r.csvHeaders
+		snapshot.HeaderValues = make([][]string, len(r.lats))
+		copy(snapshot.HeaderValues, r.headers)
+	}
 
-	sort.Float64s(r.lats)
-	r.fastest = r.lats[0]
-	r.slowest = r.lats[len(r.lats)-1]
-
-	sort.Float64s(r.connLats)
-	sort.Float64s(r.dnsLats)
-	sort.Float64s(r.reqLats)
-	sort.Float64s(r.resLats)
-	sort.Float64s(r.delayLats)
+	r.fastest = r.latEst.min
+	r.slowest = r.latEst.max
 
 	snapshot.Histogram = r.histogram()
 	snapshot.LatencyDistribution = r.latencies()
+	snapshot.TTFBDistribution = percentiles(r.delayEst)
 
 	snapshot.Fastest = r.fastest
 	snapshot.Slowest = r.slowest
-	snapshot.ConnMax = r.connLats[0]
-	snapshot.ConnMin = r.connLats[len(r.connLats)-1]
-	snapshot.DnsMax = r.dnsLats[0]
-	snapshot.DnsMin = r.dnsLats[len(r.dnsLats)-1]
-	snapshot.ReqMax = r.reqLats[0]
-	snapshot.ReqMin = r.reqLats[len(r.reqLats)-1]
-	snapshot.DelayMax = r.delayLats[0]
-	snapshot.DelayMin = r.delayLats[len(r.delayLats)-1]
-	snapshot.ResMax = r.resLats[0]
-	snapshot.ResMin = r.resLats[len(r.resLats)-1]
-
-	statusCodeDist := make(map[int]int, len(snapshot.StatusCodes))
-	for _, statusCode := range snapshot.StatusCodes {
-		statusCodeDist[statusCode]++
-	}
-	snapshot.StatusCodeDist = statusCodeDist
+	snapshot.ConnMax = r.connEst.min
+	snapshot.ConnMin = r.connEst.max
+	snapshot.DnsMax = r.dnsEst.min
+	snapshot.DnsMin = r.dnsEst.max
+	snapshot.ReqMax = r.reqEst.min
+	snapshot.ReqMin = r.reqEst.max
+	snapshot.DelayMax = r.delayEst.min
+	snapshot.DelayMin = r.delayEst.max
+	snapshot.ResMax = r.resEst.min
+	snapshot.ResMin = r.resEst.max
+
+	snapshot.StatusCodeDist = r.statusCodeDist
 
 	return snapshot
 }
 
 func (r *report) latencies() []LatencyDistribution {
+	return percentiles(r.latEst)
+}
+
+// percentiles returns the distribution of the durations of e.
+func percentiles(e *estimator) []LatencyDistribution {
 	pctls := []int{10, 25, 50, 75, 90, 95, 99}
-	data := make([]float64, len(pctls))
-	j := 0
-	for i := 0; i < len(r.lats) && j < len(pctls); i++ {
-		current := i * 100 / len(r.lats)
-		if current >= pctls[j] {
-			data[j] = r.lats[i]
-			j++
-		}
-	}
 	res := make([]LatencyDistribution, len(pctls))
-	for i := 0; i < len(pctls); i++ {
-		if data[i] > 0 {
-			res[i] = LatencyDistribution{Percentage: pctls[i], Latency: data[i]}
+	for i, p := range pctls {
+		if l := e.quantile(float64(p) / 100); l > 0 {
+			res[i] = LatencyDistribution{Percentage: p, Latency: l}
 		}
 	}
 	return res
@@ -264,31 +633,30 @@ func (r *report) histogram() []Bucket {
 		buckets[i] = r.fastest + bs*float64(i)
 	}
 	buckets[bc] = r.slowest
-	var bi int
-	var max int
-	for i := 0; i < len(r.lats); {
-		if r.lats[i] <= buckets[bi] {
-			i++
-			counts[bi]++
-			if max < counts[bi] {
-				max = counts[bi]
-			}
-		} else if bi < len(buckets)-1 {
+	var bi, total int
+	r.latEst.each(func(v float64, count int64) bool {
+		for v > buckets[bi] && bi < len(buckets)-1 {
 			bi++
 		}
-	}
+		counts[bi] += int(count)
+		total += int(count)
+		return true
+	})
 	res := make([]Bucket, len(buckets))
 	for i := 0; i < len(buckets); i++ {
 		res[i] = Bucket{
 			Mark:      buckets[i],
 			Count:     counts[i],
-			Frequency: float64(counts[i]) / float64(len(r.lats)),
+			Frequency: float64(counts[i]) / float64(total),
 		}
 	}
 	return res
 }
 
 type Report struct {
+	// Name labels the run in all outputs.
+	Name string
+
 	AvgTotal float64
 	Fastest  float64
 	Slowest  float64
@@ -320,6 +688,11 @@ type Report struct {
 	Offsets     []float64
 	StatusCodes []int
 
+	// CSVHeaders are the names of the captured response headers, and
+	// HeaderValues their values for each response.
+	CSVHeaders   []string
+	HeaderValues [][]string
+
 	Total time.Duration
 
 	ErrorDist      map[string]int
@@ -328,8 +701,156 @@ type Report struct {
 	SizeReq        int64
 	NumRes         int64
 
+	// TopErrors are the most common errors of the requests failing without
+	// a response, with their addresses masked.
+	TopErrors []ErrorCount
+
+	// Concurrency is the number of workers.
+	Concurrency int
+
+	// CompatDistribution are the percentiles of the ab and wrk outputs,
+	// and LatencyStdev the standard deviation of the response times.
+	CompatDistribution []LatencyDistribution
+	LatencyStdev       float64
+
+	NTLM         bool
+	AuthFailures int64
+
+	// Timeouts is the number of requests that timed out, TimeoutRate their
+	// percentage and Timeout the per-request timeout, 0 if none.
+	Timeouts    int64
+	TimeoutRate float64
+	Timeout     time.Duration
+
+	// FailOnEmptyBody is set when the responses without body were counted
+	// as errors, EmptyBodies of them.
+	FailOnEmptyBody bool
+	EmptyBodies     int64
+
+	Breakdowns []Breakdown
+
+	// Rate audits the rate achieved against QPS, if set.
+	Rate *RateAudit
+
+	// ConnRate is the rate new connections were established at, nil if
+	// none was seen, e.g. with Fast.
+	ConnRate *ConnRate
+
+	// CacheClasses are the latencies by cache status, titled by the
+	// header of Work.CacheHeader.
+	CacheClasses *Breakdown
+
+	// Workers are the results by worker, set with Work.PerWorker.
+	Workers *Breakdown
+
+	WarmConns       bool
+	UnexpectedConns int64
+
+	// Fault is set when faults were injected, Synthetic counts the
+	// affected results.
+	Fault     bool
+	Synthetic int64
+
+	// InfluxLines are the points of the influx output, see influxStats.
+	InfluxLines []string
+
+	// Raw is set when raw bytes were sent in place of HTTP requests, the
+	// responses then have no status code.
+	Raw bool
+
+	// CountRedirectHops is set when the redirects followed were counted
+	// as results, RedirectHops of them.
+	CountRedirectHops bool
+	RedirectHops      int64
+
+	// Hedged is the number of requests hedged after Hedge, HedgeWins the
+	// number of them the hedge answered first.
+	Hedge     time.Duration
+	Hedged    int64
+	HedgeWins int64
+
+	// Fast is set when the phases of the requests weren't traced, so only
+	// total durations are available.
+	Fast bool
+
+	// Dropped is the number of results dropped because the reporter fell
+	// behind. They are counted in Rps only.
+	Dropped int64
+
+	// ResolvedIP is the IP the host was resolved to once, before the run,
+	// taking ResolveDuration.
+	ResolvedIP      string
+	ResolveDuration time.Duration
+
+	// WaitedReady is how long the target was probed before the run, with
+	// WaitReady. NotReady is set if the run started without it being ready.
+	WaitedReady time.Duration
+	NotReady    bool
+
+	// ServerTiming is set when the Server-Timing headers were parsed.
+	ServerTiming  bool
+	ServerTimings []ServerTiming
+
+	// Redirects is set when the hops of redirects were traced.
+	Redirects *Redirects
+
+	// Cache is set when ETags were sent back to validate the cache.
+	Cache *CacheValidation
+
+	// Range is set when the requests had a Range header.
+	Range *RangeRequests
+
+	// Bodies is set when the response bodies were hashed.
+	Bodies *DistinctBodies
+
+	// Compression is set when the sizes of compressed bodies were measured.
+	Compression *Compression
+
+	// SlowRequests are the slowest requests, slowest first, if they were
+	// kept.
+	SlowRequests []SlowRequest
+
+	// ReplayLag is set when requests were replayed at their offsets.
+	ReplayLag *ReplayLag
+
+	// Aborted is why the run was stopped early by AbortErrorRate, if it was.
+	Aborted string
+
+	// Interrupted is set if the run was stopped by Interrupt, its results
+	// are then partial.
+	Interrupted bool
+
+	// SuccessTarget is the number of successful responses the run stopped
+	// at, if set. AttemptsNeeded is the number of responses received by
+	// then, 0 if the target wasn't reached.
+	SuccessTarget  int64
+	Successes      int64
+	AttemptsNeeded int64
+
+	// FullHandshakes and ResumedHandshakes count the TLS handshakes of the
+	// new connections, resumed ones reusing the session of an earlier one.
+	FullHandshakes    int64
+	ResumedHandshakes int64
+
+	// ALPN counts the TLS handshakes by negotiated protocol, "" if none was,
+	// when the protocols offered were set.
+	ALPN map[string]int64
+
+	// ConnReuse is set when the requests of each connection were counted.
+	ConnReuse *ConnReuse
+
+	// MaxInFlight is the cap on the requests outstanding at once, 0 if
+	// none, and InFlightMax the most that were.
+	MaxInFlight int
+	InFlightMax int64
+
 	LatencyDistribution []LatencyDistribution
 	Histogram           []Bucket
+
+	// TTFBDistribution is the distribution of the times to first byte,
+	// from writing the request to the first byte of the response. Their
+	// average is AvgDelay.
+	TTFBDistribution []LatencyDistribution
 }
 
 type LatencyDistribution struct {
@@ -342,3 +863,13 @@ type Bucket struct {
 	Count     int
 	Frequency float64
 }
+
+// isTimeout reports whether err is a timeout, of the request or of a
+// network operation.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
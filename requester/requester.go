@@ -18,11 +18,14 @@ package requester
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
@@ -30,15 +33,23 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	ntlmssp "github.com/Azure/go-ntlmssp"
 	"golang.org/x/net/http2"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 )
 
 // Max size of the buffer of result channel.
 const maxResult = 1000000
 const maxIdleConn = 500
 
+// Same limit as the default policy of http.Client.
+const maxRedirects = 10
+
 type result struct {
 	err             error
 	statusCode      int
@@ -52,33 +63,128 @@ type result struct {
 	resDuration     time.Duration // response "read" duration
 	delayDuration   time.Duration // delay between response and request
 	contentLength   int64
+	url             string // requested URL, set when needed for reporting
+	headers         []string
+	synthetic       bool // affected by an injected fault
+	hedge           hedgeOutcome
+	redirectHop     bool // a redirect followed, see CountRedirectHops
+	hops            []time.Duration
+	serverTimings   []serverTiming
+	failure         string // why a response counts as an error, see OKStatus and Success
+	conditional     bool   // sent with If-None-Match, see ValidateCache
+	method          string
+	lag             time.Duration // behind the offset of a Replay request
+	handshake       tlsHandshake
+	newConn         bool   // the request was sent on a new connection
+	alpn            string // negotiated by the TLS handshake of the request
+	header          string // value of the PerHeader request header
+	port            string // requested port, set with PerPort
+	drained         int64  // bytes of body read, before decompression by hey
+	emptyBody       bool   // failed FailOnEmptyBody
+	host            string // requested host, set with PerHost
+	cacheClass      string // see CacheHeader
+	worker          int    // the worker that sent the request, see PerWorker
+	rangeOutcome    rangeOutcome
+	body            *bodyDigest
+
+	// sizes of the body on the wire and decompressed, see AcceptEncoding
+	wireSize, decodedSize int64
+	encoded               bool
 }
 
+// Result is the outcome of a single request, as passed to Work.OnResult.
+type Result result
+
+// Err returns the error of the request, if it failed.
+func (r Result) Err() error { return r.err }
+
+// StatusCode returns the HTTP status code of the response.
+func (r Result) StatusCode() int { return r.statusCode }
+
+// ContentLength returns the content length of the response, -1 if unknown.
+func (r Result) ContentLength() int64 { return r.contentLength }
+
+// Offset returns when the request started, relative to the start of the run.
+func (r Result) Offset() time.Duration { return r.offset }
+
+// Duration returns the total duration of the request.
+func (r Result) Duration() time.Duration { return r.duration }
+
+// ConnDuration returns the connection setup duration, DNS lookup included.
+// It is zero when a connection was reused.
+func (r Result) ConnDuration() time.Duration { return r.connDuration }
+
+// DNSDuration returns the DNS lookup duration.
+func (r Result) DNSDuration() time.Duration { return r.dnsDuration }
+
+// ReqDuration returns the duration of writing the request.
+func (r Result) ReqDuration() time.Duration { return r.reqDuration }
+
+// ResDuration returns the duration of reading the response.
+func (r Result) ResDuration() time.Duration { return r.resDuration }
+
+// DelayDuration returns the wait between writing the request and the first
+// byte of the response.
+func (r Result) DelayDuration() time.Duration { return r.delayDuration }
+
 type Work struct {
 	// Request is the request to be made.
 	Request *http.Request
 
 	RequestBody string
 
+	// RepeatBody, if set, is applied to the body once RandMark is replaced
+	// in it, e.g. to repeat it up to an exact size.
+	RepeatBody func(body string) string
+
 	// RequestFunc is a function to generate requests. If it is nil, then
 	// Request and RequestData are cloned for each request.
 	RequestFunc func() *http.Request
 
+	// StreamBody tells that the bodies of RequestFunc are read while they're
+	// sent, so SeqMark isn't replaced in them, which would load them in
+	// memory.
+	StreamBody bool
+
 	// N is the total number of requests to make.
 	N int
 
+	// Duration, if positive, stops the run once elapsed, or once N
+	// requests were made if that comes first. The time paused doesn't count.
+	Duration time.Duration
+
 	// C is the concurrency level, the number of concurrent workers to run.
 	C int
 
 	// H2 is an option to make HTTP/2 requests
 	H2 bool
 
-	// Timeout in seconds.
+	// Timeout in seconds. Each request gets a context deadline of Timeout,
+	// which also covers reading the response body.
 	Timeout int
 
 	// Qps is the rate limit in queries per second.
 	QPS float64
 
+	// Burst is the number of requests that may be sent at once while
+	// keeping the QPS average. Defaults to 1, evenly spaced requests.
+	Burst int
+
+	// StartJitter delays the first request of each worker by a random
+	// duration up to StartJitter, to avoid a spike of requests at the
+	// start. Offsets stay relative to the start of the run. Not used with QPS.
+	StartJitter time.Duration
+
+	// ResolveOnce resolves the host of Request once before the run, and
+	// dials the resolved IP, so DNS lookups don't add to the response times.
+	ResolveOnce bool
+
+	// WaitReady, if set, sends GET probes to the URL of Request before the
+	// run until one gets a response below 500, for up to WaitReady. The run
+	// starts anyway if the target isn't ready by then. The time waited is
+	// reported.
+	WaitReady time.Duration
+
 	// DisableCompression is an option to disable compression in response
 	DisableCompression bool
 
@@ -88,10 +194,30 @@ type Work struct {
 	// DisableRedirects is an option to prevent the following of HTTP redirects
 	DisableRedirects bool
 
+	// TraceRedirects times each hop of the requests following redirects,
+	// reporting the average number of redirects and latency of each hop.
+	TraceRedirects bool
+
+	// CountRedirectHops reports each redirect followed as a result of its
+	// own, with its status code and the time from its request to the
+	// redirect response, so that a request following redirects makes
+	// several results. The checks of the responses, like OKStatus and
+	// Success, only apply to the final ones.
+	CountRedirectHops bool
+
+	// KeepAuthOnRedirect re-adds the Authorization header of the original
+	// request when following redirects, which the client otherwise drops
+	// on cross-host redirects. Opt-in, as it leaks credentials to the target.
+	KeepAuthOnRedirect bool
+
 	// Output represents the output type. If "csv" is provided, the
-	// output will be dumped as a csv stream.
+	// output will be dumped as a csv stream, if "json" is provided, as
+	// a json object.
 	Output string
 
+	// Name labels the run in the summary and the csv and json outputs.
+	Name string
+
 	// ProxyAddr is the address of HTTP proxy server in the format on "host:port".
 	// Optional.
 	ProxyAddr *url.URL
@@ -104,6 +230,23 @@ type Work struct {
 	stopCh   chan struct{}
 	start    time.Duration
 
+	// request of Stream each worker is sending
+	streamed []Prepared
+
+	// index of the next request of Corpus
+	cursor int64
+
+	// set by Init, see mutatesRequest
+	mutates bool
+
+	// deadline is start+Duration, after which more stops the workers, and
+	// is pushed back by Resume. A time.Duration, accessed atomically.
+	deadline      int64
+	deadlineTimer *time.Timer // stops the run at deadline, set with pauseMu
+
+	stopOnce    sync.Once
+	interrupted int32 // set by Interrupt
+
 	report *report
 
 	Certfile string
@@ -111,6 +254,309 @@ type Work struct {
 
 	RandMark  string
 	RespCheck []string
+
+	// OKStatus, if set, reports whether a response status code is a
+	// success. Responses with other codes are counted as errors.
+	OKStatus func(code int) bool
+
+	// Replay, if set, are the requests to send at their offsets from the
+	// start of the run, in order, instead of running workers. Request must
+	// still be set, to the first of them; RequestFunc, N and QPS are unused.
+	Replay []Scheduled
+
+	// Corpus, if set, are the requests to send in order, each exactly
+	// once, the workers taking the next one from a shared cursor. N is set
+	// to their number. Request must still be set, to the first of them;
+	// RequestFunc is unused.
+	Corpus []Prepared
+
+	// Stream, if set, feeds the requests to send as they arrive, each
+	// exactly once, to the first worker free, until it's closed. Request
+	// must still be set, to the first of them; RequestFunc, N and QPS are
+	// unused.
+	Stream <-chan Prepared
+
+	// NSuccess, if positive, stops the run once that many responses
+	// succeeded: no error, an OKStatus code, Success and RespCheck passed.
+	// N still bounds the number of requests sent.
+	NSuccess int
+
+	// AbortErrorRate, if positive, stops the run once the fraction of the
+	// latest AbortWindow results that are errors exceeds it, and marks the
+	// run as aborted. AbortWindow defaults to 100.
+	AbortErrorRate float64
+	AbortWindow    int
+
+	// CacheHeader, if set, is a response header telling whether a cache
+	// served the response, like X-Cache. The latency percentiles are then
+	// reported for each cache status, e.g. HIT and MISS.
+	CacheHeader string
+
+	// ValidateCache makes each worker send the ETag of its last response
+	// in If-None-Match, and reports how many of these requests get 304
+	// responses. Only with C workers, not QPS.
+	ValidateCache bool
+
+	// SummaryTemplate, if set, renders the summary in place of the built-in
+	// one, see ParseSummaryTemplate.
+	SummaryTemplate *template.Template
+
+	// Quiet only writes the Output, leaving out the summary printed before
+	// it and the informational messages.
+	Quiet bool
+
+	// Color is whether the summary is colored: "always", "never", or "auto",
+	// the default, on a terminal unless NO_COLOR is set.
+	Color string
+
+	// Success, if set, is the condition that responses must meet to be
+	// counted as successes, the others are counted as errors.
+	Success *Predicate
+
+	// SeqMark, if set, is replaced in the URL, headers and body of each
+	// request by a sequence number, increasing from 1 across all workers.
+	SeqMark string
+
+	// BasicAuthUser and BasicAuthPassword, if set, are the basic
+	// authentication of each request, once RandMark and SeqMark are
+	// replaced in them.
+	BasicAuthUser, BasicAuthPassword string
+
+	// NTLMUser and NTLMPassword enable NTLM authentication. NTLM authenticates
+	// the connection rather than the request, so every worker gets its own
+	// single-connection transport when set. NTLMUser may be "domain\\user".
+	NTLMUser     string
+	NTLMPassword string
+
+	// TokenSource, if set, provides the OAuth2 tokens sent in the
+	// Authorization header. It should reuse its tokens until they expire,
+	// like the token sources of golang.org/x/oauth2 do.
+	TokenSource oauth2.TokenSource
+
+	// PerURL reports count, error rate and p95 for each requested URL,
+	// which is useful with a RequestFunc cycling through several URLs.
+	PerURL bool
+
+	// PerMethod reports count, error rate and p95 for each request method,
+	// for a RequestFunc mixing methods.
+	PerMethod bool
+
+	// PerHeader, if set, reports count, error rate and p95 for each value of
+	// this request header, e.g. rotated by a RequestFunc.
+	PerHeader string
+
+	// PerPort reports count, error rate and p95 for each port requested,
+	// e.g. by a RequestFunc spreading the requests over a port range.
+	PerPort bool
+
+	// PerHost reports count, error rate and p95 for each host requested,
+	// e.g. by a RequestFunc spreading the requests over a fleet of hosts.
+	PerHost bool
+
+	// PerWorker reports count, error rate, mean and p95 latency for each
+	// worker, to spot one starved or stuck on a slow connection. The
+	// requests of QPS and Replay aren't sent by workers.
+	PerWorker bool
+
+	// CSVHeaders are response headers whose values are added as extra
+	// columns to the csv output.
+	CSVHeaders []string
+
+	// WarmConns dials C keep-alive connections before the run and sends
+	// all requests over them. A request needing another connection fails
+	// instead of dialing, and is reported as an unexpected new connection.
+	WarmConns bool
+
+	// Fault injects artificial latency and errors into the requests, for
+	// testing what consumes the results. Optional.
+	Fault *Fault
+
+	// DropResults drops and counts the results that don't fit in the
+	// results buffer, instead of blocking the worker until the reporter
+	// catches up. Blocking delays the next request and distorts the rate
+	// against a fast target; dropping keeps the rate, but the statistics
+	// then only cover the results kept, which may be biased.
+	DropResults bool
+
+	// Reporters is the number of goroutines aggregating the results,
+	// merged for the summary, for when a single one can't keep up with
+	// the workers. Default is 1. It is ignored with the csv Output,
+	// OnResult, AbortErrorRate or NSuccess, which need a single one.
+	Reporters int
+
+	// Exact computes the percentiles from the durations of the results,
+	// up to 1M of them. By default they are estimated to within 1% with
+	// bounded memory, which suits long runs.
+	Exact bool
+
+	// HDRFile, if set, is where the distribution of the response times is
+	// written at the end, in the .hgrm format of HdrHistogram.
+	HDRFile string
+
+	// InfluxURL, if set, is an InfluxDB write endpoint, like
+	// http://host:8086/write?db=hey, the points of the influx output are
+	// pushed to at the end, in batches.
+	InfluxURL string
+
+	// Fast skips tracing the phases of the requests, recording only their
+	// total duration and status, to raise the achievable request rate.
+	Fast bool
+
+	// ParseServerTiming reports the average durations of the metrics of
+	// the Server-Timing response headers, like "db;dur=12".
+	ParseServerTiming bool
+
+	// OnResult, if set, is called by the reporter with every result as it
+	// arrives. It runs on the reporter goroutine and should return quickly.
+	OnResult func(Result)
+
+	// AcceptEncoding, if set, is sent as the Accept-Encoding header. The
+	// responses are then decompressed by hey rather than by the transport,
+	// to report their size on the wire and decompressed. gzip and deflate
+	// are decompressed, other encodings are counted as is.
+	AcceptEncoding string
+
+	// IdempotencyKey, if set, is a header, like Idempotency-Key, set to a
+	// new random UUID on every request. The hedge of a request and the
+	// redirects it follows have the same key.
+	IdempotencyKey string
+
+	// ConnClose sends Connection: close with each request, asking the
+	// server to close the connection after the response, while the
+	// transport keeps keep-alive enabled, unlike DisableKeepAlives.
+	ConnClose bool
+
+	// Range, if set, is sent in the Range header of each request, to
+	// report how many responses honored it with a 206 and the Content-Range
+	// requested. 206 responses with another Content-Range are errors.
+	Range *ByteRange
+
+	// BodyDigest, if set, is the algorithm of the digest of the body set
+	// on each request, after RandMark and SeqMark are replaced in it:
+	// "md5" for Content-MD5, "sha256" for Digest.
+	BodyDigest string
+
+	// RequireCompression counts the responses with a body as errors unless
+	// they are compressed, and smaller than decompressed when AcceptEncoding
+	// measures both sizes.
+	RequireCompression bool
+
+	// IdleTimeout, if positive, is how long a keep-alive connection may
+	// stay idle before it is closed, e.g. to match the keep-alive timeout
+	// of the server. Default is no limit.
+	IdleTimeout time.Duration
+
+	// FailOnEmptyBody counts the responses with a status that has a body,
+	// e.g. 200, as errors when no byte of body was read. The report counts
+	// them apart from the other failures.
+	FailOnEmptyBody bool
+
+	// MaxInFlight, if positive, bounds the number of requests outstanding
+	// at once, whatever C or QPS. The time spent waiting for one of these
+	// slots isn't part of the response time.
+	MaxInFlight int
+
+	// SendBuffer and RecvBuffer, if positive, are the sizes of the send and
+	// receive buffers of the sockets, in bytes, e.g. for links with a high
+	// bandwidth-delay product. The system may cap them. Only supported on
+	// Linux and macOS.
+	SendBuffer, RecvBuffer int
+
+	// Raw, if set, is sent as is over a new connection to the host of
+	// Request, with TLS for https, in place of each HTTP request, e.g. for
+	// other protocols over TCP or malformed HTTP. The response is read
+	// until the server closes the connection, or RawReadBytes were read if
+	// positive, and has no status code. Only C, N, QPS and Timeout apply.
+	Raw          []byte
+	RawReadBytes int
+
+	// ShuffleHeaders writes the header lines of each request in a random
+	// order, instead of the fixed one of the transport, e.g. to exercise
+	// servers sensitive to it. Only for HTTP/1.x without a proxy; the TLS
+	// handshakes of https requests are then not traced.
+	ShuffleHeaders bool
+
+	// Rand is the source of the random choices of the run, like the order
+	// of the shuffled headers, the start jitters and the injected faults.
+	// Seeded from the time if nil.
+	Rand *Rand
+
+	// Hedge, if set, sends a second, identical request when a request has
+	// no response after Hedge, the first response winning and the other
+	// request being cancelled. The phases reported are those of the first
+	// request.
+	Hedge time.Duration
+
+	// SlowRequests, if positive, lists that many of the slowest requests in
+	// the summary, with their URL, status and phases.
+	SlowRequests int
+
+	// DistinctBodies hashes the response bodies to report how many
+	// different ones were received, and the most common of them.
+	DistinctBodies bool
+
+	// ConnReuse reports the distribution of the number of requests sent on
+	// each connection. The phases of the requests must be traced, not Fast.
+	ConnReuse bool
+
+	// ALPN, if set, are the protocols offered by the TLS handshakes, in
+	// place of those of H2. The protocols negotiated are reported. Without
+	// H2, a connection negotiating h2 is still spoken to in HTTP/1.1.
+	ALPN []string
+
+	// DisableResumption disables the TLS session cache, so that every new
+	// connection makes a full handshake. By default, the sessions of the
+	// earlier connections are resumed.
+	DisableResumption bool
+
+	// DebugRuntime prints goroutine and open connection counts before and
+	// after the run, and the peak heap size, for debugging hey itself.
+	DebugRuntime bool
+
+	// CPUProfile and MemProfile are files to write the CPU profile of hey
+	// while the workers run, and its heap profile after they're done, to.
+	// A stopped run still writes them.
+	CPUProfile string
+	MemProfile string
+
+	warm *warmPool
+
+	dropped int64  // results dropped with DropResults
+	seq     uint64 // last sequence number of SeqMark
+
+	etags []string // last ETag of each worker, see ValidateCache
+
+	connUses connUses
+
+	// slots of MaxInFlight, and the requests in flight now and at most
+	inflight                 chan struct{}
+	inflightNow, inflightMax int64
+
+	// set by Pause and Resume
+	pauseMu     sync.Mutex
+	paused      int32
+	resume      chan struct{}
+	pauseStart  time.Duration
+	pausedTotal time.Duration
+
+	// progress requests to the reporter, from when reporterStarted is
+	// closed until reporterDone is
+	progress        chan progressRequest
+	reporterStarted chan struct{}
+	reporterDone    chan struct{}
+
+	// set by ResolveOnce
+	resolveDuration          time.Duration
+	resolvedHost, resolvedIP string
+
+	// the connections of Raw, set by runWorkers
+	rawDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	rawTLS  *tls.Config
+
+	// set by WaitUntilReady
+	readyOnce sync.Once
+	readyWait time.Duration
+	notReady  bool
 }
 
 func (b *Work) writer() io.Writer {
@@ -120,12 +566,32 @@ func (b *Work) writer() io.Writer {
 	return b.Writer
 }
 
+// logf prints an informational message, unless Quiet.
+func (b *Work) logf(format string, v ...interface{}) {
+	if !b.Quiet {
+		fmt.Printf(format+"\n", v...)
+	}
+}
+
 // Init initializes internal data-structures
 func (b *Work) Init() {
 	b.initOnce.Do(
 		func() {
 			b.results = make(chan *result, min(b.C*1000, maxResult))
 			b.stopCh = make(chan struct{}, b.C)
+			b.progress = make(chan progressRequest)
+			b.mutates = b.mutatesRequest()
+			b.reporterStarted = make(chan struct{})
+			b.reporterDone = make(chan struct{})
+			if b.Rand == nil {
+				b.Rand = NewRand(time.Now().UnixNano())
+			}
+			if b.ValidateCache {
+				b.etags = make([]string, b.C)
+			}
+			if b.MaxInFlight > 0 {
+				b.inflight = make(chan struct{}, b.MaxInFlight)
+			}
 		},
 	)
 }
@@ -134,42 +600,222 @@ func (b *Work) Init() {
 // all work is done.
 func (b *Work) Run() {
 	b.Init()
+	if b.Corpus != nil {
+		b.N = len(b.Corpus)
+	}
+	b.WaitUntilReady()
+	if b.ResolveOnce {
+		b.resolve()
+	}
 	b.start = now()
-	b.report = newReport(b.writer(), b.results, b.Output, b.N)
+	b.report = newReport(b.writer(), b.results, b.Output, b.N, b.Exact)
+	b.report.ntlm = b.NTLMUser != ""
+	b.report.csvHeaders = b.CSVHeaders
+	b.report.fault = b.Fault != nil
+	b.report.name = b.Name
+	b.report.onResult = b.OnResult
+	b.report.success = b.Success
+	b.report.summaryTmpl = b.SummaryTemplate
+	b.report.quiet = b.Quiet
+	b.report.color = b.Color
+	b.report.timeout = time.Duration(b.Timeout) * time.Second
+	b.report.conc = b.C
+	b.report.hedge = b.Hedge
+	b.report.countHops = b.CountRedirectHops
+	b.report.raw = b.Raw != nil
+	b.report.failEmpty = b.FailOnEmptyBody
+	b.report.reporters = b.Reporters
+	if b.Output == "influx" || b.InfluxURL != "" {
+		b.report.influx = make(influxStats)
+		b.report.wallStart = time.Now()
+	}
+	b.report.start = b.start
+	b.report.progress = b.progress
+	if b.ValidateCache {
+		b.report.cache = &cacheStats{}
+	}
+	if b.Range != nil {
+		b.report.ranges = &rangeStats{spec: b.Range.String()}
+	}
+	if b.AcceptEncoding != "" {
+		b.report.compression = &compressionStats{acceptEncoding: b.AcceptEncoding}
+	}
+	if b.ALPN != nil {
+		b.report.alpn = make(map[string]int64)
+	}
+	if b.SlowRequests > 0 {
+		b.report.slowRequests = &slowRequests{n: b.SlowRequests}
+	}
+	if b.DistinctBodies {
+		b.report.bodies = &bodyStats{counts: make(map[uint64]*BodyCount)}
+	}
+	if b.Replay != nil {
+		b.report.lags = &lagStats{est: newEstimator(b.Exact)}
+	}
+	if b.AbortErrorRate > 0 {
+		b.report.errorWindow = newErrorWindow(b.AbortWindow, b.AbortErrorRate)
+		b.report.stop = b.Stop
+	}
+	if b.NSuccess > 0 {
+		b.report.successTarget = int64(b.NSuccess)
+		b.report.stop = b.Stop
+	}
+	b.report.fast = b.Fast
+	b.report.resolveDuration = b.resolveDuration
+	b.report.resolvedIP = b.resolvedIP
+	b.report.readyWait = b.readyWait
+	b.report.notReady = b.notReady
+	if b.TraceRedirects {
+		b.report.redirects = &redirectStats{}
+	}
+	if b.ParseServerTiming {
+		b.report.serverTimings = make(serverTimingStats)
+	}
+	if b.PerURL {
+		b.report.breakdowns = append(b.report.breakdowns, newBreakdown("URL", b.Exact, func(res *result) string { return res.url }))
+	}
+	if b.PerMethod {
+		b.report.breakdowns = append(b.report.breakdowns, newBreakdown("Method", b.Exact, func(res *result) string { return res.method }))
+	}
+	if b.PerHeader != "" {
+		b.report.breakdowns = append(b.report.breakdowns, newBreakdown(b.PerHeader, b.Exact, func(res *result) string { return res.header }))
+	}
+	if b.PerPort {
+		b.report.breakdowns = append(b.report.breakdowns, newBreakdown("Port", b.Exact, func(res *result) string { return res.port }))
+	}
+	if b.QPS > 0 && b.Replay == nil {
+		b.report.rates = &rateStats{qps: b.QPS}
+	}
+	if b.CacheHeader != "" {
+		b.report.cacheClasses = newBreakdown(b.CacheHeader, b.Exact, func(res *result) string {
+			if res.err != nil {
+				return "ERROR"
+			}
+			return res.cacheClass
+		})
+	}
+	if b.PerWorker {
+		b.report.workers = newBreakdown("Worker", b.Exact, func(res *result) string { return strconv.Itoa(res.worker) })
+	}
+	if b.PerHost {
+		b.report.breakdowns = append(b.report.breakdowns, newBreakdown("Host", b.Exact, func(res *result) string { return res.host }))
+	}
 	// Run the reporter first, it polls the result channel until it is closed.
 	go func() {
 		runReporter(b.report)
+		close(b.reporterDone)
 	}()
+	close(b.reporterStarted)
+	var rs *runtimeStats
+	if b.DebugRuntime {
+		rs = startRuntimeStats()
+	}
+	var stopProfile func()
+	if b.CPUProfile != "" {
+		var err error
+		if stopProfile, err = startCPUProfile(b.CPUProfile); err != nil {
+			log.Println("error:", err.Error())
+		}
+	}
+	if b.Duration > 0 {
+		atomic.StoreInt64(&b.deadline, int64(b.start+b.Duration))
+		b.pauseMu.Lock()
+		b.deadlineTimer = time.AfterFunc(b.Duration, b.checkDeadline)
+		b.pauseMu.Unlock()
+		defer b.deadlineTimer.Stop()
+	}
 	b.runWorkers()
+	if stopProfile != nil {
+		stopProfile()
+	}
+	if b.MemProfile != "" {
+		if err := writeMemProfile(b.MemProfile); err != nil {
+			log.Println("error:", err.Error())
+		}
+	}
+	if rs != nil {
+		defer rs.print(b.writer())
+	}
 	b.Finish()
 }
 
+// Interrupt stops the run like Stop, e.g. on Ctrl-C. The requests in
+// flight complete and the summary is still printed, marked as partial.
+func (b *Work) Interrupt() {
+	atomic.StoreInt32(&b.interrupted, 1)
+	b.Stop()
+}
+
+// Stop stops the run. It can be called more than once, e.g. by a timer and
+// on Ctrl-C.
 func (b *Work) Stop() {
-	// Send stop signal so that workers can stop gracefully.
-	for i := 0; i < b.C; i++ {
-		b.stopCh <- struct{}{}
-	}
+	b.stopOnce.Do(func() {
+		// Send stop signal so that workers can stop gracefully.
+		for i := 0; i < b.C; i++ {
+			b.stopCh <- struct{}{}
+		}
+	})
 }
 
 func (b *Work) Finish() {
 	close(b.results)
-	total := now() - b.start
+	total := now() - b.start - b.pausedDuration()
 	// Wait until the reporter is done.
 	<-b.report.done
+	if b.warm != nil {
+		b.report.warmConns = true
+		b.report.unexpectedConns = atomic.LoadInt64(&b.warm.unexpected)
+	}
+	b.report.dropped = atomic.LoadInt64(&b.dropped)
+	if b.ConnReuse {
+		b.report.connReuse = b.connUses.snapshot()
+	}
+	b.report.interrupted = atomic.LoadInt32(&b.interrupted) == 1
+	b.report.maxInFlight = b.MaxInFlight
+	b.report.inflightMax = atomic.LoadInt64(&b.inflightMax)
 	b.report.finalize(total)
+	if b.HDRFile != "" {
+		if err := b.report.latEst.writeHgrmFile(b.HDRFile); err != nil {
+			log.Println("error:", err.Error())
+		}
+	}
+	if b.InfluxURL != "" {
+		if err := pushInflux(b.InfluxURL, b.report.influx.lines(b.report.wallStart, b.Name)); err != nil {
+			log.Println("error:", err.Error())
+		}
+	}
 }
 
 func (b *Work) makeRequest(gort, n int, c *http.Client) {
+	if b.Raw != nil {
+		b.sendRaw()
+		return
+	}
+	if b.inflight != nil {
+		b.enterFlight()
+	}
 	s := now()
 	var size int64
 	var code int
 	var dnsStart, connStart, resStart, reqStart, delayStart time.Duration
 	var dnsDuration, connDuration, resDuration, reqDuration, delayDuration time.Duration
+	var newConn bool
 	var req *http.Request
-	if b.RequestFunc != nil {
+	var lag time.Duration
+	var handshake tlsHandshake
+	var alpn string
+	switch {
+	case b.Replay != nil:
+		req = b.Replay[n].clone()
+		lag = s - b.start - b.Replay[n].Offset
+	case b.Corpus != nil:
+		req = b.Corpus[n].clone()
+	case b.Stream != nil:
+		req = b.streamed[gort].clone()
+	case b.RequestFunc != nil:
 		req = b.RequestFunc()
-	} else {
-		req = cloneRequest(b.Request, b.RequestBody)
+	default:
+		req = cloneRequest(b.Request, b.RequestBody, b.mutates)
 	}
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(info httptrace.DNSStartInfo) {
@@ -184,6 +830,10 @@ func (b *Work) makeRequest(gort, n int, c *http.Client) {
 		GotConn: func(connInfo httptrace.GotConnInfo) {
 			if !connInfo.Reused {
 				connDuration = now() - connStart
+				newConn = true
+			}
+			if b.ConnReuse {
+				b.connUses.add(connInfo.Conn)
 			}
 			reqStart = now()
 		},
@@ -195,8 +845,71 @@ func (b *Work) makeRequest(gort, n int, c *http.Client) {
 			delayDuration = now() - delayStart
 			resStart = now()
 		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				handshake = handshakeFull
+				if state.DidResume {
+					handshake = handshakeResumed
+				}
+				alpn = state.NegotiatedProtocol
+			}
+		},
+	}
+	if !b.Fast {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	}
+
+	// the deadline covers the whole request, reading the response body included
+	if b.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Duration(b.Timeout)*time.Second)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	var hs *hopStart
+	if b.CountRedirectHops {
+		hs = &hopStart{at: s, worker: gort}
+		req = req.WithContext(withHopStart(req.Context(), hs))
+	}
+
+	var rt *redirectTrace
+	if b.TraceRedirects {
+		rt = &redirectTrace{last: now()}
+		req = req.WithContext(withRedirectTrace(req.Context(), rt))
+	}
+
+	var synthetic bool
+	if b.Fault != nil {
+		req = req.WithContext(withFaultMark(req.Context(), &synthetic))
+	}
+
+	var hedge hedgeOutcome
+	if b.Hedge > 0 {
+		req = req.WithContext(withHedgeMark(req.Context(), &hedge))
+	}
+
+	// tag before the random part, so that requests of one URL are grouped
+	var reqURL string
+	if b.PerURL || b.SlowRequests > 0 {
+		reqURL = req.URL.String()
+	}
+	var header string
+	if b.PerHeader != "" {
+		header = req.Header.Get(b.PerHeader)
+	}
+	var port string
+	if b.PerPort {
+		port = req.URL.Port()
+	}
+	var host string
+	if b.PerHost {
+		host = req.URL.Host
+	}
+
+	// the negotiator turns basic credentials into the NTLM handshake
+	if b.NTLMUser != "" {
+		req.SetBasicAuth(b.NTLMUser, b.NTLMPassword)
 	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 
 	// random part
 	if b.RandMark != "" {
@@ -212,55 +925,175 @@ func (b *Work) makeRequest(gort, n int, c *http.Client) {
 		}
 
 		body := strings.Replace(b.RequestBody, b.RandMark, strconv.Itoa(gort)+"-"+strconv.Itoa(n), -1)
+		if b.RepeatBody != nil {
+			body = b.RepeatBody(body)
+		}
 		req.Body = ioutil.NopCloser(bytes.NewReader([]byte(body)))
 
 		req.ContentLength = int64(len(body))
 	}
 	//
 
+	// sequence number, increasing across all workers
+	var seq string
+	if b.SeqMark != "" {
+		seq = strconv.FormatUint(atomic.AddUint64(&b.seq, 1), 10)
+		req.URL.Host = strings.Replace(req.URL.Host, b.SeqMark, seq, -1)
+		req.URL.Path = strings.Replace(req.URL.Path, b.SeqMark, seq, -1)
+		req.URL.RawQuery = strings.Replace(req.URL.RawQuery, b.SeqMark, seq, -1)
+		for _, v := range req.Header {
+			for i := range v {
+				v[i] = strings.Replace(v[i], b.SeqMark, seq, -1)
+			}
+		}
+		if req.Body != nil && !b.StreamBody {
+			data, _ := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			body := strings.Replace(string(data), b.SeqMark, seq, -1)
+			req.Body = ioutil.NopCloser(strings.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+	}
+	if b.BasicAuthUser != "" || b.BasicAuthPassword != "" {
+		user, password := b.BasicAuthUser, b.BasicAuthPassword
+		if b.RandMark != "" {
+			mark := strconv.Itoa(gort) + "-" + strconv.Itoa(n)
+			user = strings.Replace(user, b.RandMark, mark, -1)
+			password = strings.Replace(password, b.RandMark, mark, -1)
+		}
+		if b.SeqMark != "" {
+			user = strings.Replace(user, b.SeqMark, seq, -1)
+			password = strings.Replace(password, b.SeqMark, seq, -1)
+		}
+		req.SetBasicAuth(user, password)
+	}
+	// once the body is final, whatever marks were replaced in it
+	if b.BodyDigest != "" {
+		setBodyDigest(req, b.BodyDigest)
+	}
+
+	var conditional bool
+	if b.ValidateCache {
+		conditional = b.setIfNoneMatch(req, gort)
+	}
+	if b.AcceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", b.AcceptEncoding)
+	}
+	if b.Range != nil {
+		req.Header.Set("Range", b.Range.String())
+	}
+	if b.ConnClose {
+		req.Close = true
+	}
+	// a fresh key per request, which its hedge and redirects send again
+	if b.IdempotencyKey != "" {
+		req.Header.Set(b.IdempotencyKey, newUUID())
+	}
+
 	resp, err := c.Do(req)
 	var bodybyte []byte
+	var headers []string
+	var timings []serverTiming
+	var digest *bodyDigest
+	var wire, plain, drained *countingReader
+	var encoded bool
+	var cacheStatus string
+	var ranged rangeOutcome
 
 	if err == nil {
 		size = resp.ContentLength
 		code = resp.StatusCode
+		if hs != nil && reqURL != "" {
+			// the last hop, the earlier ones have their own results
+			reqURL = resp.Request.URL.String()
+		}
+		if b.ValidateCache {
+			b.keepETag(resp, gort)
+		}
+		if b.CacheHeader != "" {
+			cacheStatus = cacheClass(resp.Header.Get(b.CacheHeader))
+		}
+		if b.Range != nil {
+			ranged = b.Range.outcome(resp)
+		}
+		if b.ParseServerTiming {
+			timings = parseServerTiming(resp.Header.Values("Server-Timing"))
+		}
+		if len(b.CSVHeaders) > 0 {
+			headers = make([]string, len(b.CSVHeaders))
+			for i, h := range b.CSVHeaders {
+				headers[i] = resp.Header.Get(h)
+			}
+		}
 		// bodybyte, _ := ioutil.ReadAll(resp.Body)
 		// fmt.Println(string(bodybyte), "=====3")
 		// io.Copy(ioutil.Discard, resp.Body)
 
-		if len(b.RespCheck) != 0 {
+		// count the compressed bytes, then decompress them ourselves
+		// the transport removes the header of the bodies it decompresses
+		encoding := resp.Header.Get("Content-Encoding")
+		encoded = resp.Uncompressed || isEncoded(encoding)
+		drained = &countingReader{r: resp.Body}
+		var body io.Reader = drained
+		if b.AcceptEncoding != "" {
+			wire = &countingReader{r: drained}
+			plain = &countingReader{r: decodeBody(encoding, wire)}
+			body = plain
+		}
+
+		if len(b.RespCheck) != 0 || (b.Success != nil && b.Success.UsesBody()) {
 			gzipFlag := false
 			for k, v := range resp.Header {
 				if strings.ToLower(k) == "content-encoding" && strings.ToLower(v[0]) == "gzip" {
 					gzipFlag = true
 				}
 			}
-			if gzipFlag {
+			if plain != nil {
+				bodybyte, _ = ioutil.ReadAll(plain)
+			} else if gzipFlag {
 				// 创建 gzip.Reader
-				gr, err := gzip.NewReader(resp.Body)
+				gr, err := gzip.NewReader(drained)
 				if err != nil {
-					fmt.Println(err.Error())
+					b.logf("%v", err)
 				}
 				bodybyte, _ = ioutil.ReadAll(gr)
 				defer gr.Close()
 			} else {
-				bodybyte, err = ioutil.ReadAll(resp.Body)
+				bodybyte, err = ioutil.ReadAll(drained)
 				if err != nil {
-					fmt.Println(err.Error())
+					b.logf("%v", err)
 				}
 			}
+			if b.DistinctBodies {
+				digest, _ = digestBody(bytes.NewReader(bodybyte))
+			}
+		} else if b.DistinctBodies {
+			digest, _ = digestBody(body)
 		} else {
-			io.Copy(ioutil.Discard, resp.Body) //丢弃结果加速性能
+			io.Copy(ioutil.Discard, body) //丢弃结果加速性能
 		}
 
 		resp.Body.Close()
 	}
 
+	if b.inflight != nil {
+		b.leaveFlight()
+	}
 	t := now()
 	resDuration = t - resStart
 	finish := t - s
-	b.results <- &result{
-		offset:          s,
+	offset := s
+	if hs != nil {
+		// the earlier hops were results of their own
+		finish, offset = t-hs.at, hs.at
+	}
+	var hops []time.Duration
+	if rt != nil && err == nil {
+		rt.hop(t)
+		hops = rt.hops
+	}
+	res := &result{
+		offset:          offset,
 		statusCode:      code,
 		respbody:        bodybyte,
 		respbodyCompare: b.RespCheck,
@@ -272,49 +1105,91 @@ func (b *Work) makeRequest(gort, n int, c *http.Client) {
 		reqDuration:     reqDuration,
 		resDuration:     resDuration,
 		delayDuration:   delayDuration,
+		url:             reqURL,
+		headers:         headers,
+		synthetic:       synthetic,
+		hedge:           hedge,
+		hops:            hops,
+		serverTimings:   timings,
+		conditional:     conditional,
+		method:          req.Method,
+		header:          header,
+		port:            port,
+		host:            host,
+		cacheClass:      cacheStatus,
+		rangeOutcome:    ranged,
+		worker:          gort,
+		body:            digest,
+		encoded:         encoded,
+		lag:             lag,
+		handshake:       handshake,
+		alpn:            alpn,
+		newConn:         newConn,
+	}
+	if err == nil && b.OKStatus != nil && !b.OKStatus(code) {
+		res.failure = fmt.Sprintf("unexpected status code %d", code)
+	}
+	if res.failure == "" && ranged == rangeMismatch {
+		res.failure = "Content-Range not matching " + b.Range.String()
+	}
+	if wire != nil {
+		res.wireSize, res.decodedSize = wire.n, plain.n
 	}
+	if drained != nil {
+		res.drained = drained.n
+	}
+	if err == nil && res.failure == "" && b.FailOnEmptyBody && res.drained == 0 && hasBody(req.Method, code) {
+		res.failure = "empty response body"
+		res.emptyBody = true
+	}
+	if err == nil && res.failure == "" && b.RequireCompression && hasBody(req.Method, code) {
+		res.failure = compressionFailure(res, wire != nil)
+	}
+	b.sendResult(res)
 }
 
-// func (b *Work) runWorker(client *http.Client, gort, n int) {
-// 	var throttle <-chan time.Time
-// 	if b.QPS > 0 {
-// 		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond) // 1e6/(b.QPS) 100w毫秒即1秒 / 1秒运行多少次= 一次运行的时间 即每次需要间隔多久才能达到这个qps
-// 	}
-
-// 	if b.DisableRedirects {
-// 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-// 			return http.ErrUseLastResponse
-// 		}
-// 	}
-// 	for i := 0; i < n; i++ {
-// 		// Check if application is stopped. Do not send into a closed channel.
-// 		select {
-// 		case <-b.stopCh:
-// 			return
-// 		default:
-// 			if b.QPS > 0 {
-// 				<-throttle //外层有N个runWorker的并发数，此函数是一个worker要访问多少次，如果没有sleep就一股脑发过去了
-// 				//如果通过sleep变相控制了每秒访问的数量因此-n 1000 -c 100 -q 2 则是一秒访问100*2次 且 c * q < n ，否则n太小的话不到1s没意义，qps也不宜过大，超过本身性能极限，具体真实值查看  Requests/sec
-// 			}
-// 			b.makeRequest(gort, i, client)
-// 		}
-// 	}
-// }
-
-func (b *Work) runWorker(client *http.Client, gort, n int) {
-	if b.DisableRedirects {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		}
+// sendResult passes res to the reporter.
+func (b *Work) sendResult(res *result) {
+	if !b.DropResults {
+		b.results <- res
+		return
 	}
-	for i := 0; i < n; i++ {
-		// Check if application is stopped. Do not send into a closed channel.
-		select {
-		case <-b.stopCh:
-			return
-		default:
-			b.makeRequest(gort, i, client)
-		}
+	select {
+	case b.results <- res:
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+	}
+}
+
+// more reports whether a worker goes on with another request: the run
+// wasn't stopped, Duration hasn't elapsed and the worker sent fewer than
+// quota requests.
+func (b *Work) more(sent, quota int) bool {
+	select {
+	case <-b.stopCh:
+		return false
+	default:
+	}
+	if b.Duration > 0 && now() >= time.Duration(atomic.LoadInt64(&b.deadline)) {
+		return false
+	}
+	return sent < quota
+}
+
+// quota returns the number of requests of worker gort, N/C, plus one for
+// the first N%C workers so that N requests are sent in all.
+func (b *Work) quota(gort int) int {
+	q := b.N / b.C
+	if gort < b.N%b.C {
+		q++
+	}
+	return q
+}
+
+// runWorker sends requests until the run is over or it sent quota of them.
+func (b *Work) runWorker(client *http.Client, gort, quota int) {
+	for i := 0; b.waitResume() && b.more(i, quota); i++ {
+		b.makeRequest(gort, i, client)
 	}
 }
 
@@ -324,13 +1199,13 @@ func (b *Work) runWorkers() {
 	if b.Certfile != "" && b.Keyfile != "" {
 		certstmp, err := tls.LoadX509KeyPair(b.Certfile, b.Keyfile)
 		if err != nil {
-			fmt.Println(err)
+			b.logf("%v", err)
 		} else {
 			certs = certstmp
 		}
 		ca, err := x509.ParseCertificate(certs.Certificate[0])
 		if err != nil {
-			fmt.Println(err)
+			b.logf("%v", err)
 		}
 		pool := x509.NewCertPool()
 		pool.AddCert(ca)
@@ -361,60 +1236,219 @@ func (b *Work) runWorkers() {
 		}
 	}
 
+	// resume the TLS sessions of the earlier connections
+	if !b.DisableResumption {
+		tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	tr.IdleConnTimeout = b.IdleTimeout
+
+	// 与http.DefaultTransport相同的拨号参数，并统计打开的连接数
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if b.SendBuffer > 0 || b.RecvBuffer > 0 {
+		if sockBufSupported {
+			dialer.Control = sockBufControl(b.SendBuffer, b.RecvBuffer)
+		} else {
+			b.logf("socket buffer sizes can't be set on this platform, ignored")
+		}
+	}
+	tr.DialContext = countingDial(dialer.DialContext)
+	if b.resolvedIP != "" {
+		// the TLS server name and Host header still come from the URL
+		tr.DialContext = resolvedDial(tr.DialContext, b.resolvedHost, b.resolvedIP)
+	}
+
+	if b.Raw != nil {
+		b.rawDial = tr.DialContext
+		b.rawTLS = rawConfig(tr.TLSClientConfig)
+	}
+
+	if b.ShuffleHeaders {
+		// https requests are shuffled before being encrypted
+		tr.DialTLSContext = shuffleDialTLS(tr.DialContext, tr.TLSClientConfig, b.Rand)
+		tr.DialContext = shuffleDial(tr.DialContext, b.Rand)
+	}
+
+	if b.WarmConns {
+		pool, err := newWarmPool(b.C, b.Request, tr.TLSClientConfig, net.Dialer{Timeout: time.Duration(b.Timeout) * time.Second, Control: dialer.Control})
+		if err != nil {
+			b.logf("warm-conns: dialed %d of %d connections: %v", len(pool.conns), b.C, err)
+		}
+		b.warm = pool
+		tr.DialContext = pool.dial
+		tr.DialTLSContext = pool.dial
+		tr.MaxConnsPerHost = b.C
+		tr.MaxIdleConnsPerHost = b.C
+		tr.DisableKeepAlives = false
+	}
+
 	if b.H2 {
 		http2.ConfigureTransport(&tr)
 	} else {
 		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
 	}
-	client := &http.Client{Transport: &tr, Timeout: time.Duration(b.Timeout) * time.Second}
+	if b.ALPN != nil {
+		tr.TLSClientConfig.NextProtos = b.ALPN
+	}
+	client := &http.Client{
+		Transport:     b.wrapTransport(&tr),
+		CheckRedirect: b.checkRedirect,
+	}
 
-	// Ignore the case where b.N % b.C != 0.
 	var wg sync.WaitGroup
+	// the requests in flight when stopped still report their results
+	defer wg.Wait()
 	switch {
+	case b.Replay != nil:
+		b.runReplay(client)
+
 	case b.QPS > 0:
-		var throttle <-chan time.Time
-		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond) // 1e6/(b.QPS) 100w毫秒即1秒 / 1秒运行多少次= 一次运行的时间 即每次需要间隔多久才能达到这个qps
+		// 令牌桶限速，桶容量为burst，burst为1时请求均匀间隔；等待时仍响应停止信号
+		limiter := rate.NewLimiter(rate.Limit(b.QPS), max(b.Burst, 1))
+		wait := time.NewTimer(0)
+		defer wait.Stop()
+		<-wait.C
 
-		for n := 0; n < b.N; n++ {
-			wg.Add(1)
+		for n := 0; b.waitResume() && b.more(n, b.N); n++ {
+			wait.Reset(limiter.Reserve().Delay())
 			select {
 			case <-b.stopCh:
 				return
-			default:
-				<-throttle
-				go func() {
-					b.runWorker(client, -1, 1)
+			case <-wait.C:
+				wg.Add(1)
+				go func(n int) {
+					b.makeRequest(-1, n, client)
 					wg.Done()
-				}()
+				}(n)
 			}
 		}
 		wg.Wait()
 
 	case b.C > 0:
+		if b.Stream != nil {
+			b.streamed = make([]Prepared, b.C)
+		}
 		wg.Add(b.C)
 		for gort := 0; gort < b.C; gort++ {
-			go func(gr int) {
-				b.runWorker(client, gr, b.N/b.C) //注意此处去余了，也就是Ignore the case where b.N % b.C != 0
-				wg.Done()
-			}(gort)
+			wc := client
+			if b.NTLMUser != "" {
+				wc = b.ntlmClient(&tr)
+			}
+			var jitter time.Duration
+			if b.StartJitter > 0 {
+				jitter = time.Duration(b.Rand.Int63n(int64(b.StartJitter)))
+			}
+			go func(gr int, wc *http.Client, jitter time.Duration) {
+				defer wg.Done()
+				if jitter > 0 {
+					t := time.NewTimer(jitter)
+					defer t.Stop()
+					select {
+					case <-b.stopCh:
+						return
+					case <-t.C:
+					}
+				}
+				if b.Corpus != nil {
+					b.runCorpusWorker(wc, gr)
+					return
+				}
+				if b.Stream != nil {
+					b.runStreamWorker(wc, gr)
+					return
+				}
+				b.runWorker(wc, gr, b.quota(gr))
+			}(gort, wc, jitter)
 		}
 		wg.Wait()
 	}
 }
 
+// checkRedirect is the redirect policy of the clients used by the workers.
+func (b *Work) checkRedirect(req *http.Request, via []*http.Request) error {
+	if b.DisableRedirects {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if b.TraceRedirects {
+		traceRedirect(req.Context())
+	}
+	if b.CountRedirectHops {
+		b.countHop(req, via)
+	}
+	if b.KeepAuthOnRedirect && req.Header.Get("Authorization") == "" {
+		if auth := via[0].Header.Get("Authorization"); auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+	}
+	return nil
+}
+
+// wrapTransport wraps rt with the round trippers the options ask for.
+func (b *Work) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if b.Hedge > 0 {
+		rt = &hedgeTransport{rt: rt, delay: b.Hedge}
+	}
+	if b.Fault != nil {
+		rt = newFaultTransport(rt, *b.Fault, b.Rand)
+	}
+	if b.TokenSource != nil {
+		rt = &oauth2.Transport{Source: b.TokenSource, Base: rt}
+	}
+	return rt
+}
+
+// ntlmClient returns a client pinned to a single keep-alive connection, so
+// that the NTLM handshake and the requests following it share the connection.
+func (b *Work) ntlmClient(tr *http.Transport) *http.Client {
+	t := tr.Clone()
+	t.MaxConnsPerHost = 1
+	t.MaxIdleConnsPerHost = 1
+	t.DisableKeepAlives = false
+	return &http.Client{
+		Transport:     b.wrapTransport(ntlmssp.Negotiator{RoundTripper: t}),
+		CheckRedirect: b.checkRedirect,
+	}
+}
+
+// mutatesRequest reports whether makeRequest modifies the header or url of
+// the clones of Request, which are then copied rather than shared with it.
+// Any option setting a header or changing the url of each request must be
+// listed here, or it would modify Request for all the requests.
+func (b *Work) mutatesRequest() bool {
+	return b.RandMark != "" ||
+		b.SeqMark != "" ||
+		b.NTLMUser != "" ||
+		b.BasicAuthUser != "" || b.BasicAuthPassword != "" ||
+		b.ValidateCache ||
+		b.AcceptEncoding != "" ||
+		b.IdempotencyKey != "" ||
+		b.BodyDigest != "" ||
+		b.Range != nil
+}
+
 // cloneRequest returns a clone of the provided *http.Request.
-// The clone is a shallow copy of the struct and its Header map.
-func cloneRequest(r *http.Request, body string) *http.Request {
+// The clone is a shallow copy of the struct, sharing the Header map and URL
+// with r unless deep is set. The shared ones must not be modified.
+func cloneRequest(r *http.Request, body string, deep bool) *http.Request {
 	// shallow copy of the struct
 	r2 := new(http.Request)
 	*r2 = *r
-	// deep copy of the Header
-	r2.Header = make(http.Header, len(r.Header))
-	for k, s := range r.Header {
-		r2.Header[k] = append([]string(nil), s...)
+	// deep copy of the Header and URL
+	if deep {
+		r2.Header = make(http.Header, len(r.Header))
+		for k, s := range r.Header {
+			r2.Header[k] = append([]string(nil), s...)
+		}
+		u := *r.URL
+		r2.URL = &u
 	}
 	if len(body) > 0 {
-		r2.Body = ioutil.NopCloser(bytes.NewReader([]byte(body)))
+		r2.Body = ioutil.NopCloser(strings.NewReader(body))
 	}
 
 	return r2
@@ -426,3 +1460,10 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
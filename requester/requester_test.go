@@ -15,14 +15,38 @@
 package requester
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/oauth2"
 )
 
 func TestN(t *testing.T) {
@@ -72,6 +96,57 @@ func TestQps(t *testing.T) {
 	wg.Wait()
 }
 
+func TestBurst(t *testing.T) {
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, int64(1))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       20,
+		C:       2,
+		QPS:     1,
+		Burst:   5,
+		Writer:  ioutil.Discard,
+	}
+	w.Init()
+	time.AfterFunc(500*time.Millisecond, w.Stop)
+	w.Run()
+	if count != 5 {
+		t.Errorf("Expected a burst of 5 requests, found %v", count)
+	}
+}
+
+func TestOnResult(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	var count int
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       20,
+		C:       2,
+		Writer:  ioutil.Discard,
+		OnResult: func(res Result) {
+			if res.Err() == nil && res.StatusCode() == http.StatusCreated && res.Duration() > 0 {
+				count++
+			}
+		},
+	}
+	w.Run()
+	if count != 20 {
+		t.Errorf("OnResult is expected to be called with 20 results, %v found", count)
+	}
+}
+
 func TestRequest(t *testing.T) {
 	var uri, contentType, some, auth string
 	handler := func(w http.ResponseWriter, r *http.Request) {
@@ -109,6 +184,28 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestNoUserAgent(t *testing.T) {
+	var ua []string
+	var sent bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ua, sent = r.Header["User-Agent"]
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header["User-Agent"] = []string{""}
+	w := &Work{
+		Request: req,
+		N:       1,
+		C:       1,
+	}
+	w.Run()
+	if sent {
+		t.Errorf("User-Agent is expected to be absent, %v is found", ua)
+	}
+}
+
 func TestBody(t *testing.T) {
 	var count int64
 	handler := func(w http.ResponseWriter, r *http.Request) {
@@ -132,3 +229,2174 @@ func TestBody(t *testing.T) {
 		t.Errorf("Expected to work 10 times, found %v", count)
 	}
 }
+
+func TestKeepAuthOnRedirect(t *testing.T) {
+	var auth string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer target.Close()
+	// redirect to another host, so that the client drops the header
+	targetURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targetURL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.SetBasicAuth("username", "password")
+	w := &Work{
+		Request:            req,
+		N:                  1,
+		C:                  1,
+		KeepAuthOnRedirect: true,
+	}
+	w.Run()
+	if auth != "Basic dXNlcm5hbWU6cGFzc3dvcmQ=" {
+		t.Errorf("Authorization is expected to survive the redirect, %q is found", auth)
+	}
+}
+
+func TestPerURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var next int64
+	paths := []string{"/found", "/missing"}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		RequestFunc: func() *http.Request {
+			p := paths[atomic.AddInt64(&next, 1)%2]
+			r, _ := http.NewRequest("GET", server.URL+p, nil)
+			return r
+		},
+		N:      10,
+		C:      2,
+		PerURL: true,
+		Writer: &buf,
+	}
+	w.Run()
+	for _, p := range paths {
+		if want := "[" + server.URL + p + "]\t5 responses"; !strings.Contains(buf.String(), want) {
+			t.Errorf("Summary is expected to contain %q, found:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWarmConns(t *testing.T) {
+	var mu sync.Mutex
+	addrs := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		addrs[r.RemoteAddr] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:   req,
+		N:         20,
+		C:         2,
+		WarmConns: true,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	if len(addrs) != 2 {
+		t.Errorf("Expected requests over 2 connections, found %v", len(addrs))
+	}
+	if n := w.warm.unexpected; n != 0 {
+		t.Errorf("Expected no unexpected connections, found %v", n)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Output:  "json",
+		Name:    "login",
+		Writer:  &buf,
+	}
+	w.Run()
+	out := strings.TrimSpace(buf.String())
+	var summary struct {
+		Name           string         `json:"name"`
+		Requests       int64          `json:"requests"`
+		StatusCodeDist map[string]int `json:"status_code_distribution"`
+	}
+	if err := json.Unmarshal([]byte(out[strings.LastIndex(out, "\n")+1:]), &summary); err != nil {
+		t.Fatalf("Invalid json output: %v", err)
+	}
+	if summary.Name != "login" || summary.Requests != 10 || summary.StatusCodeDist["200"] != 10 {
+		t.Errorf("Unexpected json summary %+v", summary)
+	}
+}
+
+func TestFast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Fast:    true,
+		Writer:  &buf,
+	}
+	w.Run()
+	if !strings.Contains(buf.String(), "Unavailable, requests were not traced.") {
+		t.Errorf("Summary is expected to note the missing details:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "[200]\t10 responses") {
+		t.Errorf("Summary is expected to count 10 responses:\n%s", buf.String())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var errs []error
+	var buf bytes.Buffer
+	w := &Work{
+		Request:  req,
+		N:        2,
+		C:        2,
+		Timeout:  1,
+		Writer:   &buf,
+		OnResult: func(r Result) { errs = append(errs, r.Err()) },
+	}
+	w.Run()
+	if len(errs) != 2 {
+		t.Fatalf("Got %d results; want 2", len(errs))
+	}
+	for _, err := range errs {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Request error is %v; want the deadline to be exceeded", err)
+		}
+	}
+	if want := "Timed out:\t100.0000% of requests at 1s, 2 requests"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Summary is expected to contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("No request is expected to be sent")
+	}))
+	defer server.Close()
+
+	// the test server's certificate isn't trusted by the system roots
+	check := CheckTLS(server.Listener.Addr().String(), "example.com", nil, time.Second)
+	if check.Err == nil || check.NameMismatch {
+		t.Errorf("Check error is %v, name mismatch %v; want an untrusted chain", check.Err, check.NameMismatch)
+	}
+	if check.NotAfter.IsZero() || check.Issuer == "" {
+		t.Errorf("Check is expected to describe the certificate: %+v", check)
+	}
+}
+
+func BenchmarkCloneRequest(b *testing.B) {
+	req, _ := http.NewRequest("POST", "http://localhost/", nil)
+	for _, h := range []string{"Accept", "Content-Type", "User-Agent", "X-Some", "X-Other"} {
+		req.Header.Set(h, "value")
+	}
+	for _, deep := range []bool{true, false} {
+		b.Run(fmt.Sprintf("deep=%v", deep), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cloneRequest(req, "body", deep)
+			}
+		})
+	}
+}
+
+// reportResults aggregates results with the given number of reporters.
+func reportResults(reporters int, results []*result) *report {
+	ch := make(chan *result, len(results))
+	r := newReport(ioutil.Discard, ch, "", len(results), false)
+	r.reporters = reporters
+	r.breakdowns = []*breakdown{newBreakdown("URL", false, func(res *result) string { return res.url })}
+	r.slowRequests = &slowRequests{n: 3}
+	for _, res := range results {
+		ch <- res
+	}
+	close(ch)
+	runReporter(r)
+	return r
+}
+
+func TestReporters(t *testing.T) {
+	var results []*result
+	for i := 0; i < 1000; i++ {
+		res := &result{
+			url:          fmt.Sprintf("http://host/%d", i%3),
+			statusCode:   200 + i%2,
+			duration:     time.Duration(i) * time.Millisecond,
+			connDuration: time.Duration(i%10) * time.Millisecond,
+		}
+		if i%7 == 0 {
+			res.err = errors.New("failed")
+		}
+		results = append(results, res)
+	}
+	want := reportResults(1, results).snapshot()
+	got := reportResults(4, results).snapshot()
+	if got.NumRes != want.NumRes || got.AvgTotal != want.AvgTotal || got.AvgConn != want.AvgConn {
+		t.Errorf("Got %d results, %v and %v secs in total; want %d, %v and %v",
+			got.NumRes, got.AvgTotal, got.AvgConn, want.NumRes, want.AvgTotal, want.AvgConn)
+	}
+	for name, pair := range map[string][2]interface{}{
+		"errors":       {got.ErrorDist, want.ErrorDist},
+		"status codes": {got.StatusCodeDist, want.StatusCodeDist},
+		"latencies":    {got.LatencyDistribution, want.LatencyDistribution},
+		"histogram":    {got.Histogram, want.Histogram},
+		"breakdowns":   {got.Breakdowns, want.Breakdowns},
+		"slowest":      {got.SlowRequests, want.SlowRequests},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("Sharded %s are %v; want %v", name, pair[0], pair[1])
+		}
+	}
+}
+
+// BenchmarkReporter sends results as fast as the workers of a fast target
+// would, reporting the share of them finding the results channel full,
+// i.e. how often the reporter holds the workers back.
+func BenchmarkReporter(b *testing.B) {
+	for _, reporters := range []int{1, 4} {
+		b.Run(fmt.Sprintf("reporters=%d", reporters), func(b *testing.B) {
+			ch := make(chan *result, 1000)
+			r := newReport(ioutil.Discard, ch, "", b.N, false)
+			r.reporters = reporters
+			r.breakdowns = []*breakdown{newBreakdown("URL", false, func(res *result) string { return res.url })}
+			go runReporter(r)
+			var full int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					i++
+					res := &result{url: "http://host/", statusCode: 200, duration: time.Duration(i%1000) * time.Microsecond}
+					select {
+					case ch <- res:
+					default:
+						atomic.AddInt64(&full, 1)
+						ch <- res
+					}
+				}
+			})
+			close(ch)
+			<-r.done
+			b.ReportMetric(float64(full)/float64(b.N), "full/op")
+		})
+	}
+}
+
+func TestDropResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var count int64
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           2000,
+		C:           1,
+		DropResults: true,
+		Writer:      ioutil.Discard,
+		// a slow reporter, the buffer of 1000 results fills up
+		OnResult: func(Result) {
+			count++
+			time.Sleep(time.Millisecond)
+		},
+	}
+	w.Run()
+	if w.dropped == 0 {
+		t.Errorf("Results are expected to be dropped")
+	}
+	if count+w.dropped != 2000 {
+		t.Errorf("Expected 2000 results kept or dropped, %v kept and %v dropped", count, w.dropped)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	est, exact := newEstimator(false), newEstimator(true)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		v := rnd.ExpFloat64() / 100
+		est.add(v)
+		exact.add(v)
+	}
+	if est.min != exact.min || est.max != exact.max {
+		t.Errorf("min and max are expected to be exact, got %v, %v; want %v, %v", est.min, est.max, exact.min, exact.max)
+	}
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		got, want := est.quantile(q), exact.quantile(q)
+		if math.Abs(got-want)/want > 0.01 {
+			t.Errorf("quantile(%v) = %v; want %v within 1%%", q, got, want)
+		}
+	}
+}
+
+func TestWriteHgrm(t *testing.T) {
+	est := newEstimator(true)
+	for _, v := range []float64{0.001, 0.002, 0.002, 0.004} {
+		est.add(v)
+	}
+	var buf bytes.Buffer
+	if err := est.writeHgrm(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// header, blank line, 3 distinct values and 3 footer lines
+	if len(lines) != 8 {
+		t.Fatalf("Expected 8 lines, got:\n%s", buf.String())
+	}
+	if got := strings.Fields(lines[3]); got[0] != "2.000" || got[1] != "0.750000000000" || got[2] != "3" {
+		t.Errorf("Unexpected line for 2ms: %q", lines[3])
+	}
+	if !strings.HasPrefix(lines[7], "#[Buckets") {
+		t.Errorf("Unexpected footer: %q", lines[7])
+	}
+}
+
+func TestPrintSummaries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var summaries []*Summary
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request: req,
+			N:       10,
+			C:       2,
+			Writer:  ioutil.Discard,
+		}
+		w.Run()
+		// as sent by an agent
+		data, _ := json.Marshal(w.Summary())
+		var s Summary
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		summaries = append(summaries, &s)
+	}
+	var buf bytes.Buffer
+	PrintSummaries(&buf, "", summaries)
+	if !strings.Contains(buf.String(), "[200]\t20 responses") {
+		t.Errorf("Merged summary is expected to count 20 responses:\n%s", buf.String())
+	}
+}
+
+func TestColorize(t *testing.T) {
+	out := colorize("Summary:\n  Total:\t1 secs\n\nStatus code distribution:\n  [200]\t9 responses\n  [500]\t1 responses\n")
+	for _, want := range []string{
+		colorBold + "Summary:" + colorReset,
+		"\n  Total:\t1 secs\n",
+		colorGreen + "  [200]\t9 responses" + colorReset,
+		colorRed + "  [500]\t1 responses" + colorReset,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Colorized summary is expected to contain %q:\n%q", want, out)
+		}
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	var buf bytes.Buffer
+	for _, tt := range []struct {
+		color string
+		want  bool
+	}{
+		{"", false},
+		{"auto", false},
+		{"always", true},
+		{"never", false},
+	} {
+		if got := useColor(tt.color, &buf); got != tt.want {
+			t.Errorf("useColor(%q) on a buffer = %v; want %v", tt.color, got, tt.want)
+		}
+	}
+}
+
+func TestSeqMark(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if seq := r.Header.Get("X-Seq"); seq == r.URL.Query().Get("seq") && seq == string(body) {
+			seen[seq] = true
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/?seq=SEQ", nil)
+	req.Header.Set("X-Seq", "SEQ")
+	w := &Work{
+		Request:     req,
+		RequestBody: "SEQ",
+		N:           20,
+		C:           4,
+		SeqMark:     "SEQ",
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	for i := 1; i <= 20; i++ {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("Request %d is expected to have been sent", i)
+		}
+	}
+}
+
+func TestMarksDontLeak(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.URL.Path+" "+r.URL.RawQuery+" "+r.Header.Get("X-Mark")+" "+r.Header.Get("X-Seq"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/RAND?seq={{seq}}", nil)
+	req.Header.Set("X-Mark", "RAND")
+	req.Header.Set("X-Seq", "{{seq}}")
+	w := &Work{Request: req, N: 10, C: 2, RandMark: "RAND", SeqMark: "{{seq}}", Writer: ioutil.Discard}
+	w.Run()
+	if req.URL.Path != "/RAND" || req.URL.RawQuery != "seq={{seq}}" || req.Header.Get("X-Mark") != "RAND" || req.Header.Get("X-Seq") != "{{seq}}" {
+		t.Errorf("Request is modified to %v %v", req.URL, req.Header)
+	}
+	seen := make(map[string]bool)
+	for _, r := range got {
+		if seen[r] || strings.Contains(r, "RAND") || strings.Contains(r, "{{seq}}") {
+			t.Errorf("Request %q is sent twice or still has a mark", r)
+		}
+		seen[r] = true
+	}
+	if len(got) != 10 {
+		t.Errorf("Got %d requests; want 10", len(got))
+	}
+}
+
+func TestTraceRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:        req,
+		N:              10,
+		C:              2,
+		TraceRedirects: true,
+		Writer:         &buf,
+	}
+	w.Run()
+	for _, want := range []string{"Redirects:\t2.0000 per request", "[redirect 2]\t"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Summary is expected to contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestStartJitter(t *testing.T) {
+	var mu sync.Mutex
+	var first, last time.Time
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first.IsZero() {
+			first = time.Now()
+		}
+		last = time.Now()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           20,
+		C:           20,
+		StartJitter: 500 * time.Millisecond,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	// 20 workers of a single request each, spread over 500ms
+	if spread := last.Sub(first); spread < 100*time.Millisecond {
+		t.Errorf("Requests are expected to be spread out, got %v between the first and last", spread)
+	}
+}
+
+func TestResolvedDial(t *testing.T) {
+	var host string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: resolvedDial((&net.Dialer{}).DialContext, "hey.invalid", "127.0.0.1"),
+	}}
+	resp, err := client.Get("http://hey.invalid:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if host != "hey.invalid:"+port {
+		t.Errorf("Host header is expected to be kept, %v is found", host)
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	got := parseServerTiming([]string{`db;dur=53, cache;desc="Cache Read";dur=23.2`, "miss, app;dur=\"47\""})
+	want := []serverTiming{{"db", 53}, {"cache", 23.2}, {"app", 47}}
+	if len(got) != len(want) {
+		t.Fatalf("parseServerTiming() = %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseServerTiming()[%d] = %v; want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPredicate(t *testing.T) {
+	res := &result{statusCode: 200, contentLength: 10, duration: 200 * time.Millisecond, respbody: []byte(`{"status":"ok"}`)}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`status == 200`, true},
+		{`status != 200`, false},
+		{`status >= 200 && status < 300`, true},
+		{`latency < 300ms`, true},
+		{`latency > 1s || size <= 10`, true},
+		{`body contains "\"ok\""`, true},
+		{`!(body contains "ok")`, false},
+		{`status == 200 && body contains "ok" && latency < 100ms`, false},
+		{`status == 503 || (size > 5 && !(latency >= 1s))`, true},
+	}
+	for _, tt := range tests {
+		p, err := ParsePredicate(tt.expr)
+		if err != nil {
+			t.Errorf("ParsePredicate(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := p.match(res); got != tt.want {
+			t.Errorf("%q = %v; want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		``, `status`, `status == `, `code == 200`, `status < 2xx`, `latency < 300`,
+		`body < "a"`, `body == ok`, `body contains "ok`, `(status == 200`, `status == 200 200`, `status = 200`,
+	} {
+		if _, err := ParsePredicate(expr); err == nil {
+			t.Errorf("ParsePredicate(%q) is expected to fail", expr)
+		}
+	}
+}
+
+func TestSuccess(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1)%2 == 0 {
+			w.Write([]byte("fail"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	success, err := ParsePredicate(`status == 200 && body contains "ok"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       1,
+		Success: success,
+		Writer:  &buf,
+	}
+	w.Run()
+	if !strings.Contains(buf.String(), "[count: 5]\tsuccess condition not met") {
+		t.Errorf("Summary is expected to count 5 failures:\n%s", buf.String())
+	}
+}
+
+func TestPause(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       1000000,
+		C:       2,
+		Writer:  ioutil.Discard,
+	}
+	w.Init()
+	// the reporter isn't running yet, there is nothing to wait for
+	var buf bytes.Buffer
+	w.Progress(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("Progress before the run printed %q; want nothing", buf.String())
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	w.Pause()
+	time.Sleep(50 * time.Millisecond) // the requests in flight complete
+	paused := atomic.LoadInt64(&count)
+	time.Sleep(200 * time.Millisecond)
+	if got := atomic.LoadInt64(&count); got != paused {
+		t.Errorf("Got %d requests while paused", got-paused)
+	}
+	w.Progress(&buf)
+	if !strings.Contains(buf.String(), fmt.Sprintf("] %d responses, 0 errors", paused)) {
+		t.Errorf("Progress is expected to report %d responses: %s", paused, buf.String())
+	}
+
+	w.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt64(&count); got == paused {
+		t.Error("No request was sent after resuming")
+	}
+	w.Stop()
+	<-done
+
+	// the time paused is added to Duration, the run going on after it
+	w = &Work{
+		Request:  req,
+		N:        1000000,
+		C:        2,
+		Duration: 300 * time.Millisecond,
+		Writer:   ioutil.Discard,
+	}
+	w.Init()
+	done = make(chan struct{})
+	start := time.Now()
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	w.Pause()
+	time.Sleep(400 * time.Millisecond)
+	paused = atomic.LoadInt64(&count)
+	w.Resume()
+	<-done
+	if elapsed := time.Since(start); elapsed < 650*time.Millisecond {
+		t.Errorf("Run with a 400ms pause ended after %v; want 700ms", elapsed)
+	}
+	if got := atomic.LoadInt64(&count); got == paused {
+		t.Error("No request was sent after resuming")
+	}
+	if total := w.report.total; total < 250*time.Millisecond || total > 450*time.Millisecond {
+		t.Errorf("Run lasted %v without the pause; want 300ms", total)
+	}
+}
+
+func TestValidateCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:       req,
+		N:             10,
+		C:             2,
+		ValidateCache: true,
+		Writer:        &buf,
+	}
+	w.Run()
+	// the first request of each worker has no ETag to send
+	for _, want := range []string{"Conditional requests:\t8", "304 responses:\t8, 100.0000% hit ratio"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Summary is expected to contain %q:\n%s", want, buf.String())
+		}
+	}
+	if req.Header.Get("If-None-Match") != "" {
+		t.Error("The header of the base request is not expected to be modified")
+	}
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       4,
+		C:       2,
+		Output:  "json",
+		Writer:  &buf,
+	}
+	w.Run()
+	if !strings.Contains(buf.String(), "Time to first byte:\n  Average:\t") {
+		t.Errorf("Summary is expected to report the time to first byte:\n%s", buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var j jsonReport
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.TTFB == nil || len(j.TTFB.Distribution) != 7 || j.TTFB.Distribution[2].Latency < 0.02 {
+		t.Errorf("Json time to first byte is %+v; want 7 percentiles from 20ms", j.TTFB)
+	}
+}
+
+func TestSummaryTemplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tmpl, err := ParseSummaryTemplate(`*{{ .NumRes }} requests*{{ range $code, $num := .StatusCodeDist }}, {{ $num }} x {{ $code }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:         req,
+		N:               10,
+		C:               2,
+		SummaryTemplate: tmpl,
+		Writer:          &buf,
+	}
+	w.Run()
+	if got, want := buf.String(), "*10 requests*, 10 x 200\n"; got != want {
+		t.Errorf("Summary is %q; want %q", got, want)
+	}
+
+	if _, err := ParseSummaryTemplate(`{{ .NumRes `); err == nil {
+		t.Error("ParseSummaryTemplate is expected to fail on an unclosed action")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpu, mem := filepath.Join(dir, "cpu.prof"), filepath.Join(dir, "mem.prof")
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:    req,
+		N:          10,
+		C:          2,
+		CPUProfile: cpu,
+		MemProfile: mem,
+		Writer:     ioutil.Discard,
+	}
+	w.Run()
+	for _, file := range []string{cpu, mem} {
+		if fi, err := os.Stat(file); err != nil || fi.Size() == 0 {
+			t.Errorf("Profile %s is expected to be written: %v", file, err)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string]time.Duration)
+	var start time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got[r.URL.Path] = time.Since(start)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var schedule []Scheduled
+	for i, path := range []string{"/a", "/b", "/c"} {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		schedule = append(schedule, Scheduled{Offset: time.Duration(i) * 100 * time.Millisecond, Request: req})
+	}
+	var buf bytes.Buffer
+	w := &Work{
+		Request: schedule[0].Request,
+		N:       len(schedule),
+		C:       1,
+		Replay:  schedule,
+		Writer:  &buf,
+	}
+	start = time.Now()
+	w.Run()
+	for _, s := range schedule {
+		if at, ok := got[s.Request.URL.Path]; !ok || at < s.Offset {
+			t.Errorf("%s was requested at %v; want at %v at the earliest", s.Request.URL.Path, at, s.Offset)
+		}
+	}
+	if !strings.Contains(buf.String(), "Replay lag (behind the recorded offsets):") {
+		t.Errorf("Summary is expected to report the replay lag:\n%s", buf.String())
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	var auth atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           2,
+		C:           1,
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "secret", TokenType: "Bearer"}),
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if got, _ := auth.Load().(string); got != "Bearer secret" {
+		t.Errorf("Authorization header is %q; want the token", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("The header of the base request is not expected to be modified")
+	}
+}
+
+func TestAbortErrorRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:        req,
+		N:              100000,
+		C:              2,
+		OKStatus:       func(code int) bool { return code == http.StatusOK },
+		AbortErrorRate: 0.5,
+		AbortWindow:    10,
+		Writer:         &buf,
+	}
+	w.Run()
+	s := w.Summary()
+	if s.Aborted == "" || s.NumRes >= 100000 {
+		t.Errorf("Run is expected to be aborted early, got %d results, aborted %q", s.NumRes, s.Aborted)
+	}
+	if !strings.Contains(buf.String(), "ABORTED: error rate 100.0% over the last 10 results exceeded 50.0%.") {
+		t.Errorf("Summary is expected to report the abort:\n%s", buf.String())
+	}
+	w.Stop() // Stop is expected not to block once the run is stopped
+}
+
+func TestSessionResumption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, disable := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		var buf bytes.Buffer
+		w := &Work{
+			Request:           req,
+			N:                 5,
+			C:                 1,
+			DisableKeepAlives: true,
+			DisableResumption: disable,
+			Writer:            &buf,
+		}
+		w.Run()
+		want := "TLS handshakes:\t1 full, 4 resumed"
+		if disable {
+			want = "TLS handshakes:\t5 full, 0 resumed"
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Summary with DisableResumption %v is expected to contain %q:\n%s", disable, want, buf.String())
+		}
+	}
+}
+
+func TestConnReuse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:   req,
+		N:         20,
+		C:         2,
+		ConnReuse: true,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	cr := w.report.connReuse
+	if cr == nil {
+		t.Fatal("ConnReuse is expected to be reported")
+	}
+	if cr.Conns != 2 || cr.Max != 10 || cr.Average != 10 {
+		t.Errorf("ConnReuse = %+v, want 2 connections of 10 requests", cr)
+	}
+	if b := cr.Buckets[1]; b.Min != 2 || b.Max != 10 || b.Conns != 2 {
+		t.Errorf("Bucket [2-10] = %+v, want 2 connections", b)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Output:  "json",
+		Quiet:   true,
+		Writer:  &buf,
+	}
+	w.Run()
+	var j map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &j); err != nil {
+		t.Fatalf("Output with Quiet is expected to be json only: %v\n%s", err, buf.String())
+	}
+	if j["requests"] != 10.0 {
+		t.Errorf("requests = %v, want 10", j["requests"])
+	}
+}
+
+func TestDistinctBodies(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1)%4 == 0 {
+			w.Write([]byte("error page"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              20,
+		C:              2,
+		DistinctBodies: true,
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	d := w.report.bodies.snapshot()
+	if d.Distinct != 2 || d.Untracked != 0 {
+		t.Fatalf("DistinctBodies = %+v, want 2 bodies", d)
+	}
+	if top := d.Top[0]; top.Sample != "ok" || top.Count != 15 {
+		t.Errorf("Most common body is %+v, want 15 x ok", top)
+	}
+
+	bs := &bodyStats{counts: make(map[uint64]*BodyCount)}
+	for i := 0; i < maxDistinctBodies+3; i++ {
+		bs.add(&result{body: &bodyDigest{sum: uint64(i)}})
+	}
+	if d := bs.snapshot(); d.Distinct != maxDistinctBodies || d.Untracked != 3 || len(d.Top) != topBodies {
+		t.Errorf("Bounded DistinctBodies = %d distinct, %d untracked, %d top", d.Distinct, d.Untracked, len(d.Top))
+	}
+}
+
+func TestNSuccess(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1)%2 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:  req,
+		N:        1000,
+		C:        1,
+		NSuccess: 10,
+		OKStatus: func(code int) bool { return code == http.StatusOK },
+		Writer:   &buf,
+	}
+	w.Run()
+	if n := atomic.LoadInt64(&count); n >= 1000 {
+		t.Errorf("Sent %d requests; want to stop at about 20", n)
+	}
+	// a few more requests may be sent before the run stops
+	if want := "the target of 10 took 19 requests"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Summary is expected to contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("hello ", 1000)
+	var gotEncoding atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding.Store(r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(body))
+		gw.Close()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              4,
+		C:              2,
+		AcceptEncoding: "gzip",
+		RespCheck:      []string{"hello hello"},
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	if got := gotEncoding.Load(); got != "gzip" {
+		t.Errorf("Accept-Encoding = %v, want gzip", got)
+	}
+	c := w.report.compression.snapshot()
+	if c.DecodedSize != 4*int64(len(body)) || c.Compressed != 4 {
+		t.Errorf("Compression = %+v, want 4 compressed bodies of %d bytes", c, len(body))
+	}
+	if c.WireSize == 0 || c.Ratio < 10 {
+		t.Errorf("Compression = %+v, want a ratio over 10", c)
+	}
+	if w.report.respCheckFailures != 0 {
+		t.Errorf("RespCheck failed on %d decompressed bodies", w.report.respCheckFailures)
+	}
+}
+
+func TestRequireCompression(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			w.Write([]byte("plain"))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(strings.Repeat("a", 1000)))
+		gw.Close()
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		path, acceptEncoding string
+		failures             int
+	}{
+		{"/", "", 0},
+		{"/", "gzip", 0},
+		{"/plain", "", 4},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tt.path, nil)
+		var failures int
+		w := &Work{
+			Request:            req,
+			N:                  4,
+			C:                  2,
+			AcceptEncoding:     tt.acceptEncoding,
+			RequireCompression: true,
+			Writer:             ioutil.Discard,
+			OnResult: func(r Result) {
+				if r.failure != "" {
+					failures++
+				}
+			},
+		}
+		w.Run()
+		if failures != tt.failures {
+			t.Errorf("%s with Accept-Encoding %q: %d failures; want %d", tt.path, tt.acceptEncoding, failures, tt.failures)
+		}
+	}
+}
+
+func TestCompatOutputs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	for output, want := range map[string][]string{
+		"ab":  {"Concurrency Level:      2\n", "Complete requests:      10\n", "Failed requests:        0\n", "Total transferred:      50 bytes\n", "[#/sec] (mean)", " 100%", "(longest request)"},
+		"wrk": {"  2 threads and 2 connections\n", "  Latency Distribution\n", "     99%", "  10 requests in ", "50.00B read", "Requests/sec:", "Transfer/sec:"},
+	} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		var buf bytes.Buffer
+		w := &Work{
+			Request: req,
+			N:       10,
+			C:       2,
+			Output:  output,
+			Writer:  &buf,
+		}
+		w.Run()
+		if strings.Contains(buf.String(), "Summary:") {
+			t.Errorf("-o %s output is expected without the summary:\n%s", output, buf.String())
+		}
+		for _, s := range want {
+			if !strings.Contains(buf.String(), s) {
+				t.Errorf("-o %s output is expected to contain %q:\n%s", output, s, buf.String())
+			}
+		}
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var now, max int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&now, 1)
+		defer atomic.AddInt64(&now, -1)
+		for m := atomic.LoadInt64(&max); n > m && !atomic.CompareAndSwapInt64(&max, m, n); m = atomic.LoadInt64(&max) {
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           40,
+		C:           8,
+		MaxInFlight: 3,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if got := atomic.LoadInt64(&max); got > 3 {
+		t.Errorf("Server saw %d concurrent requests; want 3 at most", got)
+	}
+	if w.report.inflightMax != 3 || w.report.numRes != 40 {
+		t.Errorf("Reported %d requests in flight at most and %d responses; want 3 and 40", w.report.inflightMax, w.report.numRes)
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	var paths []string
+	for i := 0; i < 10; i++ {
+		paths = append(paths, "/fast")
+	}
+	paths[3], paths[7] = "/slow", "/slow"
+	var next int64
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request: req,
+		RequestFunc: func() *http.Request {
+			i := atomic.AddInt64(&next, 1) - 1
+			r, _ := http.NewRequest("GET", server.URL+paths[i%int64(len(paths))], nil)
+			return r
+		},
+		N:            10,
+		C:            1,
+		SlowRequests: 2,
+		Writer:       &buf,
+	}
+	w.Run()
+	srs := w.report.slowRequests.snapshot()
+	if len(srs) != 2 {
+		t.Fatalf("Got %d slow requests; want 2", len(srs))
+	}
+	for _, sr := range srs {
+		if sr.URL != server.URL+"/slow" || sr.StatusCode != 200 || sr.Duration < 50*time.Millisecond {
+			t.Errorf("Slow request %+v; want a /slow one", sr)
+		}
+	}
+	if srs[0].Duration < srs[1].Duration {
+		t.Error("Slow requests are expected to be sorted, slowest first")
+	}
+	if !strings.Contains(buf.String(), "Slowest requests:") {
+		t.Errorf("Summary is expected to list the slowest requests:\n%s", buf.String())
+	}
+}
+
+func TestALPN(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, protos := range [][]string{{"h2", "http/1.1"}, {"http/1.1"}} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:           req,
+			N:                 4,
+			C:                 1,
+			H2:                true,
+			ALPN:              protos,
+			DisableKeepAlives: true,
+			Writer:            ioutil.Discard,
+		}
+		w.Run()
+		if got := w.report.alpn[protos[0]]; got != 4 || len(w.report.alpn) != 1 {
+			t.Errorf("Offering %v negotiated %v; want 4 connections of %s", protos, w.report.alpn, protos[0])
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	var probes int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&probes, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 3, C: 1, WaitReady: 5 * time.Second, Writer: ioutil.Discard}
+	w.Run()
+	if w.report.notReady || w.report.readyWait < 2*probeInterval {
+		t.Errorf("Waited %v, not ready %v; want at least %v and ready", w.report.readyWait, w.report.notReady, 2*probeInterval)
+	}
+	if got := atomic.LoadInt64(&probes); got != 6 {
+		t.Errorf("Target got %d requests; want 3 probes and 3 requests", got)
+	}
+	if s := w.Summary(); s.StatusCodeDist[200] != 3 {
+		t.Errorf("Status codes of the run %v; want 3 responses of 200", s.StatusCodeDist)
+	}
+
+	atomic.StoreInt64(&probes, -1000)
+	w = &Work{Request: req, N: 1, C: 1, WaitReady: 300 * time.Millisecond, Quiet: true, Writer: ioutil.Discard}
+	w.Run()
+	if !w.report.notReady {
+		t.Errorf("Target always failing was reported ready after %v", w.report.readyWait)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, r.URL.Path+string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var corpus []Prepared
+	var want []string
+	for i := 0; i < 7; i++ {
+		req, _ := http.NewRequest("POST", server.URL+"/"+strconv.Itoa(i), nil)
+		corpus = append(corpus, Prepared{Request: req, Body: "b" + strconv.Itoa(i)})
+		want = append(want, "/"+strconv.Itoa(i)+"b"+strconv.Itoa(i))
+	}
+
+	for _, c := range []int{1, 3} {
+		got = nil
+		w := &Work{Request: corpus[0].Request, Corpus: corpus, N: 100, C: c, Writer: ioutil.Discard}
+		w.Run()
+		if c > 1 {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("With %d workers, sent %v; want %v", c, got, want)
+		}
+		if w.N != len(corpus) {
+			t.Errorf("N = %d; want the %d requests of the corpus", w.N, len(corpus))
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	for _, qps := range []float64{0, 200} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		var out bytes.Buffer
+		w := &Work{Request: req, N: 100000, C: 4, QPS: qps, Output: "json", Quiet: true, Writer: &out}
+		w.Init()
+		time.AfterFunc(200*time.Millisecond, w.Interrupt)
+		w.Run()
+		s := w.Summary()
+		if !s.Interrupted || s.NumRes == 0 || s.NumRes >= 100000 {
+			t.Errorf("QPS %v: interrupted %v after %d responses; want a partial run", qps, s.Interrupted, s.NumRes)
+		}
+		var j struct {
+			Requests    int64
+			Interrupted bool
+		}
+		if err := json.Unmarshal(out.Bytes(), &j); err != nil {
+			t.Fatalf("QPS %v: %v in %s", qps, err, out.String())
+		}
+		if !j.Interrupted || j.Requests != s.NumRes {
+			t.Errorf("QPS %v: json has interrupted %v and %d requests; want true and %d", qps, j.Interrupted, j.Requests, s.NumRes)
+		}
+	}
+}
+
+func TestHedge(t *testing.T) {
+	var arrivals, cancelled int64
+	var badBodies int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+			atomic.AddInt64(&badBodies, 1)
+		}
+		// the first request of each pair is slow, its hedge isn't
+		if atomic.AddInt64(&arrivals, 1)%2 == 1 {
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt64(&cancelled, 1)
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{Request: req, RequestBody: "payload", N: 4, C: 1, Hedge: 50 * time.Millisecond, Writer: ioutil.Discard}
+	w.Run()
+	r := w.report
+	if r.hedged != 4 || r.hedgeWins != 4 {
+		t.Errorf("Hedged %d requests, %d won by the hedge; want 4 and 4", r.hedged, r.hedgeWins)
+	}
+	if r.slowest >= 0.5 {
+		t.Errorf("Slowest request took %v secs; want the hedge response", r.slowest)
+	}
+	if got := atomic.LoadInt64(&badBodies); got != 0 {
+		t.Errorf("%d requests had a wrong body", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt64(&cancelled); got != 4 {
+		t.Errorf("%d slow requests were cancelled; want 4", got)
+	}
+}
+
+func TestCSVPhases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{Request: req, N: 3, C: 1, Output: "csv", Quiet: true, DisableKeepAlives: true, Writer: &out}
+	w.Run()
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimSpace(out.String()))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"response-time", "DNS+dialup", "DNS", "Request-write", "Response-delay", "Response-read", "status-code", "offset"}
+	if len(rows) != 4 || !reflect.DeepEqual(rows[0], header) {
+		t.Fatalf("Got rows %v; want the header %v and 3 rows", rows, header)
+	}
+	for _, row := range rows[1:] {
+		for i, col := range header {
+			if _, err := strconv.ParseFloat(row[i], 64); err != nil {
+				t.Errorf("Column %s is %q in %v; want a number", col, row[i], row)
+			}
+		}
+		// the phases are filled in, the server takes 10ms to respond
+		if delay, _ := strconv.ParseFloat(row[4], 64); delay < 0.01 {
+			t.Errorf("Response-delay is %s of %s secs; want at least 0.01", row[4], row[0])
+		}
+		if row[6] != "200" {
+			t.Errorf("Status code is %q; want 200", row[6])
+		}
+	}
+}
+
+func TestCSVHeaders(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&count, 1)
+		// the second response lacks X-Cache
+		if n != 2 {
+			w.Header().Set("X-Cache", "HIT")
+		}
+		w.Header().Set("X-Served-By", fmt.Sprintf("edge-%d, shield", n))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{Request: req, N: 3, C: 1, Output: "csv", Quiet: true, CSVHeaders: []string{"X-Cache", "X-Served-By"}, Writer: &out}
+	w.Run()
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimSpace(out.String()))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Got rows %v; want the header and 3 rows", rows)
+	}
+	if got := rows[0][8:]; !reflect.DeepEqual(got, []string{"X-Cache", "X-Served-By"}) {
+		t.Errorf("Extra columns are %v; want X-Cache and X-Served-By", got)
+	}
+	var got []string
+	for _, row := range rows[1:] {
+		if len(row) != 10 {
+			t.Fatalf("Row %v has %d columns; want 10", row, len(row))
+		}
+		got = append(got, row[8]+"|"+row[9])
+	}
+	sort.Strings(got)
+	want := []string{"HIT|edge-1, shield", "HIT|edge-3, shield", "|edge-2, shield"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Header values are %v; want %v", got, want)
+	}
+}
+func TestCountRedirectHops(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	var urls []string
+	w := &Work{
+		Request:           req,
+		N:                 2,
+		C:                 1,
+		CountRedirectHops: true,
+		TraceRedirects:    true,
+		PerURL:            true,
+		OKStatus:          func(code int) bool { return code == 200 },
+		OnResult:          func(r Result) { urls = append(urls, strings.TrimPrefix(r.url, server.URL)) },
+		Writer:            ioutil.Discard,
+	}
+	w.Run()
+	r := w.report
+	if r.numRes != 6 || r.redirectHops != 4 || r.successes != 2 || r.numErrors != 0 || len(r.errorDist) != 0 {
+		t.Errorf("Got %d results, %d hops, %d successes, errors %v; want 6, 4, 2 and none", r.numRes, r.redirectHops, r.successes, r.errorDist)
+	}
+	want := map[int]int{302: 2, 301: 2, 200: 2}
+	if !reflect.DeepEqual(r.statusCodeDist, want) {
+		t.Errorf("Status codes %v; want %v", r.statusCodeDist, want)
+	}
+	if want := []string{"/a", "/b", "/c", "/a", "/b", "/c"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("Hops of %v; want %v", urls, want)
+	}
+	if got := r.snapshot().Redirects.AvgHops; got != 2 {
+		t.Errorf("AvgHops = %v; want 2", got)
+	}
+	if r.connEst.n != 2 || r.delayEst.n != 2 || r.latEst.n != 6 {
+		t.Errorf("Got %d phases, %d delays and %d latencies; want 2, 2 and 6", r.connEst.n, r.delayEst.n, r.latEst.n)
+	}
+}
+
+// writeConn is a connection recording what is written to it.
+type writeConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *writeConn) Write(p []byte) (int, error) { return c.buf.Write(p) }
+
+func TestShuffleHeaders(t *testing.T) {
+	out := &writeConn{}
+	conn := &shuffleConn{Conn: out, rnd: NewRand(1)}
+	orders := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader("body with\r\n\r\nin it"))
+		if i%2 == 1 {
+			// unknown length, sent chunked
+			req.ContentLength = -1
+			req.Body = ioutil.NopCloser(strings.NewReader("chunked\r\n\r\nbody"))
+		}
+		for _, h := range []string{"A", "B", "C", "D", "E"} {
+			req.Header.Set("X-"+h, h)
+		}
+		// write in small pieces, like a slow writer would
+		var buf bytes.Buffer
+		req.Write(&buf)
+		for buf.Len() > 0 {
+			conn.Write(buf.Next(7))
+		}
+
+		var order []string
+		head := out.buf.String()[:strings.Index(out.buf.String(), "\r\n\r\n")]
+		for _, line := range strings.Split(head, "\r\n")[1:] {
+			order = append(order, strings.SplitN(line, ":", 2)[0])
+		}
+		orders[strings.Join(order, ",")] = true
+
+		got, err := http.ReadRequest(bufio.NewReader(&out.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(got.Body)
+		if want := map[bool]string{false: "body with\r\n\r\nin it", true: "chunked\r\n\r\nbody"}[i%2 == 1]; string(body) != want || got.Header.Get("X-C") != "C" {
+			t.Errorf("Request %d has body %q and X-C %q; want %q and C", i, body, got.Header.Get("X-C"), want)
+		}
+		if out.buf.Len() != 0 {
+			t.Fatalf("Request %d left %q", i, out.buf.String())
+		}
+	}
+	if len(orders) < 10 {
+		t.Errorf("Got %d orders of the headers in 20 requests; want them shuffled", len(orders))
+	}
+
+	// over keep-alive https connections
+	var bodies int64
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := ioutil.ReadAll(r.Body); string(body) == "payload" {
+			atomic.AddInt64(&bodies, 1)
+		}
+	}))
+	defer server.Close()
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	req.ContentLength = int64(len("payload"))
+	w := &Work{Request: req, RequestBody: "payload", N: 20, C: 2, ShuffleHeaders: true, Writer: ioutil.Discard}
+	w.Run()
+	if got := atomic.LoadInt64(&bodies); got != 20 || w.report.numErrors != 0 {
+		t.Errorf("Server got %d of the 20 bodies, %v errors", got, w.report.errorDist)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var badPayloads int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "PING" {
+					atomic.AddInt64(&badPayloads, 1)
+					return
+				}
+				conn.Write([]byte("PONG\r\n"))
+			}()
+		}
+	}()
+
+	req, _ := http.NewRequest("GET", "http://"+ln.Addr().String(), nil)
+	w := &Work{Request: req, Raw: []byte("PING"), N: 6, C: 2, Writer: ioutil.Discard}
+	w.Run()
+	r := w.report
+	if len(r.errorDist) != 0 || r.successes != 6 {
+		t.Errorf("Got %d successes and errors %v; want 6 successes", r.successes, r.errorDist)
+	}
+	if r.sizeTotal != 6*6 {
+		t.Errorf("Read %d bytes; want %d", r.sizeTotal, 6*6)
+	}
+	if got := atomic.LoadInt64(&badPayloads); got != 0 {
+		t.Errorf("%d connections got a wrong payload", got)
+	}
+	if !r.raw {
+		t.Error("The report isn't marked raw")
+	}
+}
+
+func TestInflux(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	var pushed bytes.Buffer
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(&pushed, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{Request: req, N: 30, C: 3, Output: "influx", Quiet: true, Name: "my run", InfluxURL: influx.URL + "/write?db=hey", Writer: &out}
+	w.Run()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var count int64
+	for _, line := range lines {
+		var n, errs int64
+		var mean, max float64
+		var ts int64
+		prefix := `hey,method=GET,name=my\ run,status=200 `
+		if !strings.HasPrefix(line, prefix) {
+			t.Fatalf("Line %q doesn't start with %q", line, prefix)
+		}
+		if _, err := fmt.Sscanf(line[len(prefix):], "count=%di,errors=%di,mean=%g,max=%g %d", &n, &errs, &mean, &max, &ts); err != nil {
+			t.Fatalf("Line %q: %v", line, err)
+		}
+		if errs != 0 || mean <= 0 || max < mean || time.Since(time.Unix(0, ts)) > time.Minute {
+			t.Errorf("Line %q has unexpected fields", line)
+		}
+		count += n
+	}
+	if count != 30 {
+		t.Errorf("Points count %d requests; want 30", count)
+	}
+	if pushed.String() != out.String() {
+		t.Errorf("Pushed %q; want the printed points %q", pushed.String(), out.String())
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	keys := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/next", http.StatusFound)
+		}
+		mu.Lock()
+		keys[r.Header.Get("Idempotency-Key")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 20, C: 2, IdempotencyKey: "Idempotency-Key", Writer: ioutil.Discard}
+	w.Run()
+	if len(keys) != 20 {
+		t.Errorf("Got %d distinct keys; want 20", len(keys))
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for k, n := range keys {
+		if !uuid.MatchString(k) || n != 2 {
+			t.Errorf("Key %q was sent %d times; want a UUID sent with the request and its redirect", k, n)
+		}
+	}
+}
+
+func TestFailOnEmptyBody(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+		case atomic.AddInt64(&count, 1)%2 == 0:
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Query().Get("empty") == "":
+			w.Write([]byte("hit"))
+		}
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		method, query string
+		empty         int64
+	}{
+		{"GET", "", 0},
+		{"GET", "?empty=1", 5},
+		{"HEAD", "", 0},
+	} {
+		atomic.StoreInt64(&count, 0)
+		req, _ := http.NewRequest(tt.method, server.URL+tt.query, nil)
+		w := &Work{Request: req, N: 10, C: 1, FailOnEmptyBody: true, Writer: ioutil.Discard}
+		w.Run()
+		r := w.report
+		if r.emptyBodies != tt.empty || int64(r.errorDist["empty response body"]) != tt.empty || r.successes != 10-tt.empty {
+			t.Errorf("%s %s: %d empty bodies, %d successes, errors %v; want %d empty bodies",
+				tt.method, tt.query, r.emptyBodies, r.successes, r.errorDist, tt.empty)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		idle  time.Duration
+		conns int64
+	}{
+		{0, 1},
+		{50 * time.Millisecond, 3},
+	} {
+		var conns int64
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt64(&conns, 1)
+			}
+		}
+		server.Start()
+
+		// the requests are 200ms apart
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{Request: req, N: 3, C: 1, QPS: 5, IdleTimeout: tt.idle, Writer: ioutil.Discard}
+		w.Run()
+		server.Close()
+		if got := atomic.LoadInt64(&conns); got != tt.conns {
+			t.Errorf("With an idle timeout of %v, %d connections were opened; want %d", tt.idle, got, tt.conns)
+		}
+	}
+}
+
+func TestNormalizeError(t *testing.T) {
+	for _, tt := range []struct{ msg, want string }{
+		{"dial tcp 1.2.3.4:80: connect: connection refused", "dial tcp <addr>: connect: connection refused"},
+		{"read tcp 10.0.0.1:54321->5.6.7.8:443: read: connection reset by peer", "read tcp <addr>-><addr>: read: connection reset by peer"},
+		{"dial tcp [2001:db8::1]:443: i/o timeout", "dial tcp <addr>: i/o timeout"},
+		{"lookup example.com on 8.8.8.8:53: no such host", "lookup example.com on <addr>: no such host"},
+		{"unexpected EOF", "unexpected EOF"},
+	} {
+		if got := normalizeError(tt.msg); got != tt.want {
+			t.Errorf("normalizeError(%q) = %q; want %q", tt.msg, got, tt.want)
+		}
+	}
+	messages := map[string]int{"b": 2, "a": 2, "c": 5, "d": 1}
+	want := []ErrorCount{{"c", 5}, {"a", 2}, {"b", 2}}
+	if got := topErrorCounts(messages, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("topErrorCounts = %v; want %v", got, want)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer server.Close()
+
+	// the remainder of N/C is spread over the first workers
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 11, C: 3, Writer: ioutil.Discard}
+	w.Run()
+	if got := atomic.LoadInt64(&count); got != 11 {
+		t.Errorf("Sent %d requests; want 11", got)
+	}
+	if q := []int{w.quota(0), w.quota(1), w.quota(2)}; !reflect.DeepEqual(q, []int{4, 4, 3}) {
+		t.Errorf("Quotas are %v; want [4 4 3]", q)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	for _, q := range []float64{0, 50} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{Request: req, N: math.MaxInt32, C: 2, QPS: q, Duration: 200 * time.Millisecond, Writer: ioutil.Discard}
+		start := time.Now()
+		w.Run()
+		if d := time.Since(start); d < 200*time.Millisecond || d > time.Second {
+			t.Errorf("With -q %v, the run took %v; want about 200ms", q, d)
+		}
+		if w.report.numRes == 0 {
+			t.Errorf("With -q %v, no request was sent", q)
+		}
+	}
+}
+
+func TestCacheHeader(t *testing.T) {
+	for value, want := range map[string]string{
+		"HIT": "HIT", "TCP_MISS": "MISS", "Hit from cloudfront": "HIT",
+		"MISS, HIT": "HIT", "expired": "EXPIRED", "": "NONE",
+	} {
+		if got := cacheClass(value); got != want {
+			t.Errorf("cacheClass(%q) = %q; want %q", value, got, want)
+		}
+	}
+
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first requests miss and are slow
+		if atomic.AddInt64(&count, 1) <= 2 {
+			w.Header().Set("X-Cache", "MISS")
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		w.Header().Set("X-Cache", "HIT")
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 10, C: 1, CacheHeader: "X-Cache", Writer: ioutil.Discard}
+	w.Run()
+	cc := w.report.snapshot().CacheClasses
+	if cc == nil || len(cc.Cohorts) != 2 {
+		t.Fatalf("Cache classes are %+v; want HIT and MISS", cc)
+	}
+	hit, miss := cc.Cohorts[0], cc.Cohorts[1]
+	if hit.Key != "HIT" || hit.Count != 8 || miss.Key != "MISS" || miss.Count != 2 {
+		t.Errorf("Got %s: %d and %s: %d; want HIT: 8 and MISS: 2", hit.Key, hit.Count, miss.Key, miss.Count)
+	}
+	if miss.P50 < 0.05 || hit.P99 >= 0.05 {
+		t.Errorf("MISS p50 is %v and HIT p99 %v; want the misses slower", miss.P50, hit.P99)
+	}
+}
+
+func TestRateAudit(t *testing.T) {
+	rs := &rateStats{qps: 10}
+	for s, n := range []int{10, 10, 6, 11, 3} {
+		for i := 0; i < n; i++ {
+			rs.add(time.Duration(s)*time.Second + time.Duration(i)*time.Millisecond)
+		}
+	}
+	// the last second is partial
+	got := rs.snapshot(4500 * time.Millisecond)
+	want := &RateAudit{Target: 10, Average: 9.25, Min: 6, Max: 11, Seconds: 4, Deviating: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Audit is %+v; want %+v", got, want)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 30, C: 1, QPS: 20, Writer: ioutil.Discard}
+	w.Run()
+	if a := w.report.snapshot().Rate; a == nil || a.Seconds != 1 || a.Deviating != 0 {
+		t.Errorf("Audit of a -q 20 run is %+v; want a second at 20 req/s", a)
+	}
+}
+
+func TestBasicAuthTemplate(t *testing.T) {
+	var mu sync.Mutex
+	users := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		mu.Lock()
+		users[user] = password
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 10, C: 2, SeqMark: "{{seq}}", BasicAuthUser: "user{{seq}}", BasicAuthPassword: "pass{{seq}}", Writer: ioutil.Discard}
+	w.Run()
+	if len(users) != 10 {
+		t.Errorf("Got %d distinct users; want 10", len(users))
+	}
+	for i := 1; i <= 10; i++ {
+		if p := users[fmt.Sprintf("user%d", i)]; p != fmt.Sprintf("pass%d", i) {
+			t.Errorf("Password of user%d = %q; want pass%d", i, p, i)
+		}
+	}
+}
+
+func TestConnRate(t *testing.T) {
+	var mu sync.Mutex
+	closes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.Close {
+			closes++
+		}
+		mu.Unlock()
+	}))
+	defer server.Close()
+	for _, tt := range []struct {
+		disableKeepAlives, connClose bool
+		want                         int64
+	}{
+		{false, false, 2},
+		{true, false, 20},
+		{false, true, 20},
+	} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{Request: req, N: 20, C: 2, DisableKeepAlives: tt.disableKeepAlives, ConnClose: tt.connClose, Writer: ioutil.Discard}
+		w.Run()
+		c := w.report.snapshot().ConnRate
+		if c == nil || c.Total != tt.want || c.Peak != tt.want || c.Average <= 0 || c.Share != float64(tt.want)*100/20 {
+			t.Errorf("Connection rate without keep-alive %v, with close %v is %+v; want %d connections", tt.disableKeepAlives, tt.connClose, c, tt.want)
+		}
+	}
+	// both send Connection: close
+	if closes != 40 {
+		t.Errorf("Got %d requests with Connection: close; want 40", closes)
+	}
+}
+
+func TestBodyDigest(t *testing.T) {
+	var mu sync.Mutex
+	var mismatches, count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		md5Sum := md5.Sum(body)
+		shaSum := sha256.Sum256(body)
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		switch {
+		case r.Header.Get("Content-MD5") == base64.StdEncoding.EncodeToString(md5Sum[:]):
+		case r.Header.Get("Digest") == "SHA-256="+base64.StdEncoding.EncodeToString(shaSum[:]):
+		default:
+			mismatches++
+		}
+	}))
+	defer server.Close()
+
+	for _, algo := range []string{"md5", "sha256"} {
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		w := &Work{Request: req, RequestBody: "body {{seq}}", N: 10, C: 2, SeqMark: "{{seq}}", BodyDigest: algo, Writer: ioutil.Discard}
+		w.Run()
+	}
+	if count != 20 || mismatches != 0 {
+		t.Errorf("%d of %d requests had a digest not matching their body", mismatches, count)
+	}
+}
+
+func TestByteRange(t *testing.T) {
+	for _, tt := range []struct {
+		spec, contentRange string
+		want               bool
+	}{
+		{"bytes=0-1023", "bytes 0-1023/5000", true},
+		{"bytes=0-1023", "bytes 0-999/1000", true},
+		{"bytes=0-1023", "bytes 0-511/5000", false},
+		{"bytes=1024-", "bytes 1024-4999/5000", true},
+		{"bytes=1024-", "bytes 1024-2047/*", true},
+		{"bytes=-500", "bytes 4500-4999/5000", true},
+		{"bytes=-500", "bytes 0-499/5000", false},
+		{"bytes=0-1023", "", false},
+	} {
+		r, err := ParseByteRange(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseByteRange(%q) failed: %v", tt.spec, err)
+		}
+		if r.String() != tt.spec {
+			t.Errorf("Range %q is sent as %q", tt.spec, r.String())
+		}
+		if got := r.matches(tt.contentRange); got != tt.want {
+			t.Errorf("Range %q matches %q = %v; want %v", tt.spec, tt.contentRange, got, tt.want)
+		}
+	}
+	for _, spec := range []string{"0-1023", "bytes=0-10,20-30", "bytes=10-5", "bytes=-0", "bytes=a-"} {
+		if _, err := ParseByteRange(spec); err == nil {
+			t.Errorf("ParseByteRange(%q) is expected to fail", spec)
+		}
+	}
+
+	content := strings.Repeat("x", 5000)
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&count, 1) % 4 {
+		case 0:
+			w.Write([]byte(content))
+		case 1:
+			w.Header().Set("Content-Range", "bytes 0-99/5000")
+			w.WriteHeader(http.StatusPartialContent)
+		default:
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer server.Close()
+
+	r, _ := ParseByteRange("bytes=0-1023")
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 20, C: 2, Range: r, Writer: ioutil.Discard}
+	w.Run()
+	s := w.report.snapshot()
+	want := &RangeRequests{Range: "bytes=0-1023", Responses: 20, Honored: 10, Full: 5, Mismatched: 5, HonoredRatio: 50, FullRatio: 25}
+	if !reflect.DeepEqual(s.Range, want) {
+		t.Errorf("Range requests are %+v; want %+v", s.Range, want)
+	}
+	if n := s.ErrorDist["Content-Range not matching bytes=0-1023"]; n != 5 {
+		t.Errorf("Got %d errors; want the 5 wrong Content-Range", n)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	stream := make(chan Prepared)
+	go func() {
+		defer close(stream)
+		for i := 0; i < 10; i++ {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%d", server.URL, i), nil)
+			stream <- Prepared{Request: req}
+		}
+	}()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, C: 3, Stream: stream, Writer: ioutil.Discard}
+	w.Run()
+	if len(paths) != 10 {
+		t.Errorf("Got %d distinct requests; want 10", len(paths))
+	}
+	for p, n := range paths {
+		if n != 1 {
+			t.Errorf("%s was requested %d times; want once", p, n)
+		}
+	}
+}
+
+func TestPerWorker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 24, C: 12, PerWorker: true, Writer: ioutil.Discard}
+	w.Run()
+	ws := w.report.snapshot().Workers
+	if ws == nil || len(ws.Cohorts) != 12 {
+		t.Fatalf("Workers are %+v; want 12", ws)
+	}
+	for i, c := range ws.Cohorts {
+		if c.Key != strconv.Itoa(i) || c.Count != 2 || c.Mean <= 0 {
+			t.Errorf("Worker %d is %+v; want worker %d with 2 responses", i, c, i)
+		}
+	}
+}
+
+func TestStreamBodySeqMark(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/{{seq}}", nil)
+	w := &Work{Request: req, N: 1, C: 1, SeqMark: "{{seq}}", StreamBody: true, Writer: ioutil.Discard}
+	w.RequestFunc = func() *http.Request {
+		r := cloneRequest(req, "", true)
+		r.Body = ioutil.NopCloser(strings.NewReader("streamed {{seq}}"))
+		return r
+	}
+	w.Run()
+	if len(bodies) != 1 || bodies[0] != "/1 streamed {{seq}}" {
+		t.Errorf("Server received %q; want the url numbered and the streamed body untouched", bodies)
+	}
+}
+
+func TestNTLM(t *testing.T) {
+	// a challenge message without target, NTLM and unicode flags
+	challenge := append([]byte("NTLMSSP\x00\x02\x00\x00\x00"), make([]byte, 36)...)
+	challenge[20], challenge[21] = 0x01, 0x02
+	var mu sync.Mutex
+	authed := make(map[string]int) // handshakes per connection
+	var anonymous int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// NTLM authenticates the connection, not the request
+		if authed[r.RemoteAddr] > 0 && r.Header.Get("Authorization") == "" {
+			anonymous++
+			return
+		}
+		msg, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "NTLM "))
+		switch {
+		case len(msg) > 8 && msg[8] == 1:
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challenge))
+		case len(msg) > 8 && msg[8] == 3:
+			authed[r.RemoteAddr]++
+			return
+		default:
+			w.Header().Set("WWW-Authenticate", "NTLM")
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		N:            20,
+		C:            4,
+		NTLMUser:     `domain\user`,
+		NTLMPassword: "password",
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	r := w.report
+	if r.numErrors != 0 || r.statusCodeDist[200] != 20 || r.authFailures != 0 {
+		t.Errorf("Got errors %v and status codes %v; want 20 authenticated requests", r.errorDist, r.statusCodeDist)
+	}
+	// each worker authenticates once, on its own connection, the requests
+	// following the handshake being sent anonymously on it
+	if len(authed) != 4 {
+		t.Errorf("Got %d authenticated connections; want one per worker, 4", len(authed))
+	}
+	for addr, n := range authed {
+		if n != 1 {
+			t.Errorf("Connection %s authenticated %d times; want once", addr, n)
+		}
+	}
+	if anonymous != 16 {
+		t.Errorf("Got %d requests on authenticated connections; want 16", anonymous)
+	}
+}
+
+func TestDurationCap(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer server.Close()
+
+	// N caps the requests of a run with a Duration, whichever comes first
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 7, C: 2, Duration: 5 * time.Second, Writer: ioutil.Discard}
+	start := time.Now()
+	w.Run()
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("Run lasted %v; want it to end after 7 requests", elapsed)
+	}
+	if got := atomic.LoadInt64(&count); got != 7 || w.report.numRes != 7 {
+		t.Errorf("Got %d requests and %d results; want 7", got, w.report.numRes)
+	}
+}
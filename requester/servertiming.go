@@ -0,0 +1,102 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// serverTiming is a metric of a Server-Timing header, like "db;dur=12".
+type serverTiming struct {
+	name string
+	dur  float64 // in milliseconds
+}
+
+// parseServerTiming parses the metrics with a duration of the Server-Timing
+// header values, e.g. `db;dur=53, cache;desc="Cache Read";dur=23.2`.
+func parseServerTiming(values []string) []serverTiming {
+	var timings []serverTiming
+	for _, v := range values {
+		for _, metric := range strings.Split(v, ",") {
+			params := strings.Split(metric, ";")
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+			for _, p := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				if len(kv) != 2 || strings.ToLower(kv[0]) != "dur" {
+					continue
+				}
+				if dur, err := strconv.ParseFloat(strings.Trim(kv[1], `"`), 64); err == nil {
+					timings = append(timings, serverTiming{name, dur})
+				}
+				break
+			}
+		}
+	}
+	return timings
+}
+
+// serverTimingStats aggregates the Server-Timing metrics of the responses.
+type serverTimingStats map[string]*timingSum
+
+type timingSum struct {
+	count int64
+	sum   float64
+}
+
+func (st serverTimingStats) add(timings []serverTiming) {
+	for _, t := range timings {
+		ts, ok := st[t.name]
+		if !ok {
+			ts = &timingSum{}
+			st[t.name] = ts
+		}
+		ts.count++
+		ts.sum += t.dur
+	}
+}
+
+func (st serverTimingStats) merge(o serverTimingStats) {
+	for name, ots := range o {
+		ts, ok := st[name]
+		if !ok {
+			ts = &timingSum{}
+			st[name] = ts
+		}
+		ts.count += ots.count
+		ts.sum += ots.sum
+	}
+}
+
+func (st serverTimingStats) snapshot() []ServerTiming {
+	var s []ServerTiming
+	for name, ts := range st {
+		s = append(s, ServerTiming{Name: name, Count: ts.count, Average: ts.sum / float64(ts.count)})
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Name < s[j].Name })
+	return s
+}
+
+// ServerTiming is the average duration of a metric of the Server-Timing
+// response headers.
+type ServerTiming struct {
+	Name    string
+	Count   int64   // responses with the metric
+	Average float64 // in milliseconds
+}
@@ -0,0 +1,203 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "sync"
+
+// shardable reports whether the results can be aggregated by several
+// reporters. The csv output, OnResult and the checks stopping the run need
+// them one at a time, in order.
+func (r *report) shardable() bool {
+	return r.output != "csv" && r.onResult == nil && r.errorWindow == nil && r.successTarget == 0
+}
+
+// runShards aggregates the results on r.reporters goroutines, each into a
+// shard of r, then merges the shards into r once the results are closed.
+func runShards(r *report) {
+	shards := make([]*report, r.reporters)
+	var wg sync.WaitGroup
+	for i := range shards {
+		s := r.shard()
+		shards[i] = s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range r.results {
+				s.mu.Lock()
+				s.add(res)
+				s.mu.Unlock()
+			}
+		}()
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	for {
+		select {
+		case <-drained:
+			for _, s := range shards {
+				r.merge(s)
+			}
+			r.done <- true
+			return
+		case req := <-r.progress:
+			p := r.shard()
+			for _, s := range shards {
+				s.mu.Lock()
+				p.merge(s)
+				s.mu.Unlock()
+			}
+			p.printProgress(req.w, req.paused)
+			close(req.done)
+		}
+	}
+}
+
+// shard returns an empty report aggregating the same statistics as r.
+func (r *report) shard() *report {
+	s := newReport(r.w, nil, "", 0, r.latEst.exact)
+	s.ntlm = r.ntlm
+	s.start = r.start
+	s.success = r.success
+	for _, bd := range r.breakdowns {
+		s.breakdowns = append(s.breakdowns, newBreakdown(bd.title, bd.exact, bd.key))
+	}
+	if r.rates != nil {
+		s.rates = &rateStats{qps: r.rates.qps}
+	}
+	if bd := r.cacheClasses; bd != nil {
+		s.cacheClasses = newBreakdown(bd.title, bd.exact, bd.key)
+	}
+	if bd := r.workers; bd != nil {
+		s.workers = newBreakdown(bd.title, bd.exact, bd.key)
+	}
+	if r.alpn != nil {
+		s.alpn = make(map[string]int64)
+	}
+	if r.cache != nil {
+		s.cache = &cacheStats{}
+	}
+	if r.ranges != nil {
+		s.ranges = &rangeStats{spec: r.ranges.spec}
+	}
+	if r.bodies != nil {
+		s.bodies = &bodyStats{counts: make(map[uint64]*BodyCount)}
+	}
+	if r.compression != nil {
+		s.compression = &compressionStats{}
+	}
+	if r.slowRequests != nil {
+		s.slowRequests = &slowRequests{n: r.slowRequests.n}
+	}
+	if r.lags != nil {
+		s.lags = &lagStats{est: newEstimator(r.latEst.exact)}
+	}
+	if r.redirects != nil {
+		s.redirects = &redirectStats{}
+	}
+	if r.serverTimings != nil {
+		s.serverTimings = make(serverTimingStats)
+	}
+	if r.influx != nil {
+		s.influx = make(influxStats)
+	}
+	return s
+}
+
+// merge adds the results aggregated by the shard s to r.
+func (r *report) merge(s *report) {
+	r.numRes += s.numRes
+	r.numErrors += s.numErrors
+	r.timeouts += s.timeouts
+	r.successes += s.successes
+	r.sizeTotal += s.sizeTotal
+	r.authFailures += s.authFailures
+	r.synthetic += s.synthetic
+	r.respCheckFailures += s.respCheckFailures
+	r.emptyBodies += s.emptyBodies
+	r.redirectHops += s.redirectHops
+	r.hedged += s.hedged
+	r.hedgeWins += s.hedgeWins
+	r.fullHandshakes += s.fullHandshakes
+	r.resumedHandshakes += s.resumedHandshakes
+
+	r.avgTotal += s.avgTotal
+	r.avgConn += s.avgConn
+	r.avgDelay += s.avgDelay
+	r.avgDNS += s.avgDNS
+	r.avgReq += s.avgReq
+	r.avgRes += s.avgRes
+	r.latEst.combine(s.latEst)
+	r.connEst.combine(s.connEst)
+	r.dnsEst.combine(s.dnsEst)
+	r.reqEst.combine(s.reqEst)
+	r.resEst.combine(s.resEst)
+	r.delayEst.combine(s.delayEst)
+
+	for code, n := range s.statusCodeDist {
+		r.statusCodeDist[code] += n
+	}
+	for err, n := range s.errorDist {
+		r.errorDist[err] += n
+	}
+	for msg, n := range s.errorMsgs {
+		r.errorMsgs[msg] += n
+	}
+	for proto, n := range s.alpn {
+		r.alpn[proto] += n
+	}
+	for i, bd := range s.breakdowns {
+		r.breakdowns[i].merge(bd)
+	}
+	if r.cacheClasses != nil {
+		r.cacheClasses.merge(s.cacheClasses)
+	}
+	if r.workers != nil {
+		r.workers.merge(s.workers)
+	}
+	if r.rates != nil {
+		r.rates.merge(s.rates)
+	}
+	r.conns.merge(s.conns)
+	if r.cache != nil {
+		r.cache.merge(s.cache)
+	}
+	if r.ranges != nil {
+		r.ranges.merge(s.ranges)
+	}
+	if r.bodies != nil {
+		r.bodies.merge(s.bodies)
+	}
+	if r.compression != nil {
+		r.compression.merge(s.compression)
+	}
+	if r.slowRequests != nil {
+		r.slowRequests.merge(s.slowRequests)
+	}
+	if r.lags != nil {
+		r.lags.merge(s.lags)
+	}
+	if r.redirects != nil {
+		r.redirects.merge(s.redirects)
+	}
+	if r.serverTimings != nil {
+		r.serverTimings.merge(s.serverTimings)
+	}
+	if r.influx != nil {
+		r.influx.merge(s.influx)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var headEnd = []byte("\r\n\r\n")
+
+// shuffleConn shuffles the header lines of the HTTP/1.x requests written
+// to the connection, which the transport writes in a fixed order.
+type shuffleConn struct {
+	net.Conn
+	head  []byte        // the head of the request being written
+	left  int64         // bytes of its body left to write
+	chunk *chunkScanner // set while writing a chunked body
+	rnd   *Rand
+}
+
+func (c *shuffleConn) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		var k int
+		switch {
+		case c.left > 0:
+			k = len(p)
+			if int64(k) > c.left {
+				k = int(c.left)
+			}
+			c.left -= int64(k)
+		case c.chunk != nil:
+			var done bool
+			if k, done = c.chunk.scan(p); done {
+				c.chunk = nil
+			}
+		default:
+			i := bytes.Index(append(c.head[max(len(c.head)-3, 0):len(c.head):len(c.head)], p...), headEnd)
+			if i < 0 {
+				c.head = append(c.head, p...)
+				return n, nil
+			}
+			// the end of the head, relative to p
+			k = i + len(headEnd) - min(len(c.head), 3)
+			c.head = append(c.head, p[:k]...)
+			head := c.shuffle()
+			c.head = c.head[:0]
+			if _, err := c.Conn.Write(head); err != nil {
+				return 0, err
+			}
+			p = p[k:]
+			continue
+		}
+		if _, err := c.Conn.Write(p[:k]); err != nil {
+			return 0, err
+		}
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// shuffle returns the head with its header lines shuffled, and sets up
+// the writing of the body that follows.
+func (c *shuffleConn) shuffle() []byte {
+	lines := strings.Split(strings.TrimSuffix(string(c.head), "\r\n\r\n"), "\r\n")
+	headers := lines[1:]
+	for _, h := range headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.ToLower(kv[0]) {
+		case "content-length":
+			c.left, _ = strconv.ParseInt(v, 10, 64)
+		case "transfer-encoding":
+			if strings.Contains(strings.ToLower(v), "chunked") {
+				c.chunk = &chunkScanner{}
+			}
+		}
+	}
+	c.rnd.Shuffle(len(headers), func(i, j int) { headers[i], headers[j] = headers[j], headers[i] })
+	return []byte(strings.Join(lines, "\r\n") + "\r\n\r\n")
+}
+
+// chunkScanner finds the end of a chunked body.
+type chunkScanner struct {
+	line []byte // the size or trailer line being read
+	left int64  // bytes of the chunk data and its CRLF left
+	last bool   // the last chunk was read, the trailer follows
+}
+
+// scan reads the chunked body in p, returning how many bytes of p it spans
+// and whether it ends there.
+func (s *chunkScanner) scan(p []byte) (int, bool) {
+	i := 0
+	for i < len(p) {
+		if s.left > 0 {
+			k := len(p) - i
+			if int64(k) > s.left {
+				k = int(s.left)
+			}
+			i += k
+			s.left -= int64(k)
+			continue
+		}
+		b := p[i]
+		i++
+		if b != '\n' {
+			s.line = append(s.line, b)
+			continue
+		}
+		line := strings.TrimSpace(string(s.line))
+		s.line = s.line[:0]
+		if s.last {
+			if line == "" {
+				return i, true
+			}
+			continue
+		}
+		if j := strings.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+		size, _ := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if size == 0 {
+			s.last = true
+		} else {
+			s.left = size + 2
+		}
+	}
+	return i, false
+}
+
+// shuffleDial wraps dial to shuffle the headers of the requests sent on the
+// connections it opens.
+func shuffleDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), rnd *Rand) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &shuffleConn{Conn: conn, rnd: rnd}, nil
+	}
+}
+
+// shuffleDialTLS returns a dial function making the TLS handshake itself,
+// so that the headers are shuffled before being encrypted. It only speaks
+// HTTP/1.1.
+func shuffleDialTLS(dial func(ctx context.Context, network, addr string) (net.Conn, error), config *tls.Config, rnd *Rand) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	config = config.Clone()
+	config.NextProtos = []string{"http/1.1"}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &shuffleConn{Conn: tc, rnd: rnd}, nil
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// slowRequests keeps the n slowest results, in a heap whose root is the fastest
+// of them, so that it's the one replaced by a slower result.
+type slowRequests struct {
+	n   int
+	res []*result
+}
+
+func (s *slowRequests) Len() int           { return len(s.res) }
+func (s *slowRequests) Less(i, j int) bool { return s.res[i].duration < s.res[j].duration }
+func (s *slowRequests) Swap(i, j int)      { s.res[i], s.res[j] = s.res[j], s.res[i] }
+func (s *slowRequests) Push(x interface{}) { s.res = append(s.res, x.(*result)) }
+func (s *slowRequests) Pop() interface{} {
+	x := s.res[len(s.res)-1]
+	s.res = s.res[:len(s.res)-1]
+	return x
+}
+
+func (s *slowRequests) add(res *result) {
+	switch {
+	case len(s.res) < s.n:
+		heap.Push(s, res)
+	case res.duration > s.res[0].duration:
+		s.res[0] = res
+		heap.Fix(s, 0)
+	}
+}
+
+func (s *slowRequests) merge(o *slowRequests) {
+	for _, res := range o.res {
+		s.add(res)
+	}
+}
+
+func (s *slowRequests) snapshot() []SlowRequest {
+	srs := make([]SlowRequest, 0, len(s.res))
+	for _, res := range s.res {
+		sr := SlowRequest{
+			Method:     res.method,
+			URL:        res.url,
+			StatusCode: res.statusCode,
+			Offset:     res.offset,
+			Duration:   res.duration,
+			Conn:       res.connDuration,
+			Req:        res.reqDuration,
+			Delay:      res.delayDuration,
+			Res:        res.resDuration,
+		}
+		if res.err != nil {
+			sr.Error = res.err.Error()
+		}
+		srs = append(srs, sr)
+	}
+	sort.Slice(srs, func(i, j int) bool { return srs[i].Duration > srs[j].Duration })
+	return srs
+}
+
+// SlowRequest is one of the slowest requests of a run, see Work.SlowRequests.
+type SlowRequest struct {
+	Method, URL string
+	StatusCode  int
+	Error       string // if the request failed
+	// Offset is when the request started, relative to the start of the run.
+	Offset time.Duration
+	// Duration is the response time, and the others its phases:
+	// DNS+dialup, request write, response wait and response read.
+	Duration, Conn, Req, Delay, Res time.Duration
+}
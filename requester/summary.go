@@ -0,0 +1,159 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"time"
+)
+
+// Summary is the state of the report of a run that can be merged with the
+// summaries of other runs, e.g. of the same test run from several machines.
+// It serializes to json.
+type Summary struct {
+	Total          time.Duration
+	NumRes         int64
+	SizeTotal      int64
+	ErrorDist      map[string]int
+	StatusCodeDist map[int]int
+
+	// Errors is the number of requests without a response, and
+	// RespCheckFailures the number of responses failing RespCheck.
+	Errors            int64
+	RespCheckFailures int64
+
+	// Aborted is why the run was stopped by AbortErrorRate, if it was.
+	Aborted string
+
+	// Interrupted is set if the run was stopped by Interrupt.
+	Interrupted bool
+
+	// Distributions of the response times of the successful requests,
+	// and of their phases.
+	Lats, Conn, DNS, Req, Res, Delay Distribution
+}
+
+// Distribution is a distribution of durations in seconds, counted in
+// logarithmic buckets.
+type Distribution struct {
+	N        int64
+	Sum      float64
+	Min, Max float64
+	Buckets  map[int]int64
+}
+
+// Summary returns the summary of the run, once Run has returned. The
+// summary has no distributions if Exact is set.
+func (b *Work) Summary() *Summary {
+	r := b.report
+	n := float64(r.connEst.n)
+	return &Summary{
+		Total:          r.total,
+		NumRes:         r.numRes,
+		SizeTotal:      r.sizeTotal,
+		ErrorDist:      r.errorDist,
+		StatusCodeDist: r.statusCodeDist,
+
+		Errors:            r.numErrors,
+		RespCheckFailures: r.respCheckFailures,
+		Aborted:           r.aborted,
+		Interrupted:       r.interrupted,
+
+		// the phase averages are final at this point
+		Lats:  r.latEst.distribution(r.avgTotal),
+		Conn:  r.connEst.distribution(r.avgConn * n),
+		DNS:   r.dnsEst.distribution(r.avgDNS * n),
+		Req:   r.reqEst.distribution(r.avgReq * n),
+		Res:   r.resEst.distribution(r.avgRes * n),
+		Delay: r.delayEst.distribution(r.avgDelay * n),
+	}
+}
+
+// Rps returns the requests per second of the run.
+func (s *Summary) Rps() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return float64(s.NumRes) / s.Total.Seconds()
+}
+
+// Quantile returns the response time, in seconds, that the fraction q of
+// the successful requests didn't exceed.
+func (s *Summary) Quantile(q float64) float64 {
+	e := newEstimator(false)
+	e.merge(s.Lats)
+	return e.quantile(q)
+}
+
+// PrintSummaries merges the summaries and prints them to w as the report of
+// a single run, in the given output format. The total duration is the one
+// of the longest run.
+func PrintSummaries(w io.Writer, output string, summaries []*Summary) {
+	r := newReport(w, nil, output, 0, false)
+	var total time.Duration
+	for _, s := range summaries {
+		if s.Total > total {
+			total = s.Total
+		}
+		r.numRes += s.NumRes
+		r.numErrors += s.Errors
+		r.respCheckFailures += s.RespCheckFailures
+		r.successes += s.NumRes - s.Errors - s.RespCheckFailures
+		if s.Aborted != "" {
+			r.aborted = s.Aborted
+		}
+		r.interrupted = r.interrupted || s.Interrupted
+		r.sizeTotal += s.SizeTotal
+		for k, v := range s.ErrorDist {
+			r.errorDist[k] += v
+		}
+		for k, v := range s.StatusCodeDist {
+			r.statusCodeDist[k] += v
+		}
+		r.avgTotal += r.latEst.merge(s.Lats)
+		r.avgConn += r.connEst.merge(s.Conn)
+		r.avgDNS += r.dnsEst.merge(s.DNS)
+		r.avgReq += r.reqEst.merge(s.Req)
+		r.avgRes += r.resEst.merge(s.Res)
+		r.avgDelay += r.delayEst.merge(s.Delay)
+	}
+	r.finalize(total)
+}
+
+func (e *estimator) distribution(sum float64) Distribution {
+	d := Distribution{N: e.n, Sum: sum, Min: e.min, Max: e.max}
+	if !e.exact {
+		d.Buckets = e.counts
+	}
+	return d
+}
+
+// merge adds the values of d to e, and returns their sum.
+func (e *estimator) merge(d Distribution) float64 {
+	if d.N == 0 {
+		return 0
+	}
+	if e.n == 0 || d.Min < e.min {
+		e.min = d.Min
+	}
+	if e.n == 0 || d.Max > e.max {
+		e.max = d.Max
+	}
+	e.n += d.N
+	for i, c := range d.Buckets {
+		e.counts[i] += c
+	}
+	return d.Sum
+}
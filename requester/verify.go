@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"time"
+)
+
+// TLSCheck is the outcome of verifying the certificate chain of a host.
+type TLSCheck struct {
+	Addr       string // host:port dialed
+	ServerName string // sent as SNI and verified against the certificate
+
+	// Err is the error of the handshake or of the verification, nil if the
+	// chain is valid for ServerName. NameMismatch is set if the chain is
+	// otherwise valid but not for ServerName.
+	Err          error
+	NameMismatch bool
+
+	// Subject, Issuer and expiry of the leaf certificate, if the handshake
+	// completed.
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+}
+
+// CheckTLS performs a TLS handshake with addr, sending serverName as SNI,
+// and verifies the certificate chain against the system roots. Unlike the
+// load test, which skips verification, nothing is trusted blindly here, and
+// no HTTP request is sent. certs are the client certificates to present.
+func CheckTLS(addr, serverName string, certs []tls.Certificate, timeout time.Duration) TLSCheck {
+	check := TLSCheck{Addr: addr, ServerName: serverName}
+	// the chain is verified below, so that a name mismatch can be told apart
+	// from an invalid chain
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		Certificates:       certs,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	chain := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(chain) == 0 {
+		check.Err = errors.New("no certificate sent")
+		return check
+	}
+
+	leaf := chain[0]
+	check.Subject = leaf.Subject.String()
+	check.Issuer = leaf.Issuer.String()
+	check.NotAfter = leaf.NotAfter
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{Intermediates: intermediates}
+	if _, err := leaf.Verify(opts); err != nil {
+		check.Err = err
+		return check
+	}
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		check.Err = err
+		check.NameMismatch = true
+	}
+	return check
+}
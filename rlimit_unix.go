@@ -0,0 +1,52 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fileMargin are the open files needed besides the connections: stdio,
+// the files read or written and DNS lookups.
+const fileMargin = 64
+
+// checkFileLimit raises the soft limit of open files, up to the hard limit,
+// if conc connections may not fit in it, and returns an error if they still
+// may not.
+func checkFileLimit(conc int) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return nil
+	}
+	needed := uint64(conc) + fileMargin
+	if lim.Cur >= needed {
+		return nil
+	}
+	raised := lim
+	raised.Cur = needed
+	if raised.Cur > lim.Max {
+		raised.Cur = lim.Max
+	}
+	if raised.Cur > lim.Cur && syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised) == nil {
+		lim = raised
+	}
+	if lim.Cur >= needed {
+		return nil
+	}
+	return fmt.Errorf("-c %d may need %d open files, above the limit of %d (ulimit -n)", conc, needed, lim.Cur)
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// headerRotation cycles the value of a request header, in order.
+type headerRotation struct {
+	name   string
+	values []string
+	next   uint64
+}
+
+// parseHeaderRotation parses a header and its values, like
+// "X-Api-Key: key1,key2,key3".
+func parseHeaderRotation(s string) (*headerRotation, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("invalid -rotate-header %q, e.g. 'X-Api-Key: key1,key2'", s)
+	}
+	values := splitList(parts[1])
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no value in -rotate-header %q", s)
+	}
+	return &headerRotation{name: http.CanonicalHeaderKey(strings.TrimSpace(parts[0])), values: values}, nil
+}
+
+// set sets the header of r to the next value.
+func (hr *headerRotation) set(r *http.Request) {
+	i := (atomic.AddUint64(&hr.next, 1) - 1) % uint64(len(hr.values))
+	r.Header.Set(hr.name, hr.values[i])
+}
@@ -0,0 +1,387 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+const (
+	// maxSchemaDepth is the nesting below which only the required properties
+	// and the minItems of arrays are generated, so recursive schemas end.
+	maxSchemaDepth = 8
+	// maxSchemaItems caps the items of the arrays past minItems, and
+	// maxSchemaString the length of the strings past minLength.
+	maxSchemaItems  = 10
+	maxSchemaString = 32
+)
+
+// schemaGen generates random JSON documents valid against a JSON Schema,
+// for the bodies of -schema. It supports type, properties, required, items,
+// enum, const, anyOf, oneOf, allOf, local $ref and the bounds of strings,
+// numbers and arrays. pattern and the other keywords are ignored.
+type schemaGen struct {
+	root map[string]interface{}
+
+	// the values of a document are drawn in a row from rnd
+	mu  sync.Mutex
+	rnd *requester.Rand
+}
+
+// loadSchema reads the JSON Schema of file. The documents are generated
+// once withRand set their source.
+func loadSchema(file string) (*schemaGen, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	g := &schemaGen{root: root}
+	if err := g.checkRefs(root); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return g, nil
+}
+
+// withRand returns a generator of the documents of g drawn from rnd.
+func (g *schemaGen) withRand(rnd *requester.Rand) *schemaGen {
+	return &schemaGen{root: g.root, rnd: rnd}
+}
+
+// checkRefs checks that the $refs of node resolve.
+func (g *schemaGen) checkRefs(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := n["$ref"].(string); ok {
+			if g.resolve(ref) == nil {
+				return fmt.Errorf("unresolved $ref %q, only local ones like #/$defs/name are", ref)
+			}
+		}
+		for _, v := range n {
+			if err := g.checkRefs(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			if err := g.checkRefs(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the schema of a local $ref, a JSON pointer like
+// #/definitions/name, or nil.
+func (g *schemaGen) resolve(ref string) map[string]interface{} {
+	if !strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	var node interface{} = g.root
+	for _, tok := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+		if tok == "" {
+			continue
+		}
+		tok = strings.Replace(strings.Replace(tok, "~1", "/", -1), "~0", "~", -1)
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[tok]
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil
+			}
+			node = n[i]
+		default:
+			return nil
+		}
+	}
+	s, _ := node.(map[string]interface{})
+	return s
+}
+
+// generate returns a new document.
+func (g *schemaGen) generate() string {
+	g.mu.Lock()
+	v := g.value(g.root, 0)
+	g.mu.Unlock()
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (g *schemaGen) value(s map[string]interface{}, depth int) interface{} {
+	// $ref, anyOf, oneOf and allOf are levels too, so that the schemas
+	// cycling through them end
+	if depth >= 2*maxSchemaDepth {
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		return g.value(g.resolve(ref), depth+1)
+	}
+	if c, ok := s["const"]; ok {
+		return c
+	}
+	if e, ok := s["enum"].([]interface{}); ok && len(e) > 0 {
+		return e[g.rnd.Intn(len(e))]
+	}
+	for _, k := range []string{"anyOf", "oneOf"} {
+		if subs, ok := s[k].([]interface{}); ok && len(subs) > 0 {
+			sub, _ := subs[g.rnd.Intn(len(subs))].(map[string]interface{})
+			return g.value(sub, depth+1)
+		}
+	}
+	if subs, ok := s["allOf"].([]interface{}); ok {
+		return g.value(g.mergeAll(s, subs), depth+1)
+	}
+	switch g.typeOf(s) {
+	case "object":
+		return g.object(s, depth)
+	case "array":
+		return g.array(s, depth)
+	case "integer":
+		lo, hi := g.bounds(s, 1)
+		first, last := schemaInt(math.Ceil(lo)), schemaInt(math.Floor(hi))
+		if last < first {
+			last = first
+		}
+		if span := last - first + 1; span > 0 {
+			return first + g.rnd.Int63n(span)
+		}
+		// the range spans more than half of the int64s, drawn until in it
+		for {
+			if v := int64(g.rnd.Uint64()); v >= first && v <= last {
+				return v
+			}
+		}
+	case "number":
+		lo, hi := g.bounds(s, 0)
+		return lo + g.rnd.Float64()*(hi-lo)
+	case "boolean":
+		return g.rnd.Intn(2) == 0
+	case "null":
+		return nil
+	}
+	return g.str(s)
+}
+
+// typeOf returns the type of s, one at random if it has several, or the
+// one its keywords imply.
+func (g *schemaGen) typeOf(s map[string]interface{}) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		if len(t) > 0 {
+			name, _ := t[g.rnd.Intn(len(t))].(string)
+			return name
+		}
+	}
+	switch {
+	case s["properties"] != nil:
+		return "object"
+	case s["items"] != nil:
+		return "array"
+	}
+	return "string"
+}
+
+// mergeAll merges the subschemas of allOf into s.
+func (g *schemaGen) mergeAll(s map[string]interface{}, subs []interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	props := make(map[string]interface{})
+	var required []interface{}
+	for _, sub := range append([]interface{}{s}, subs...) {
+		m, _ := sub.(map[string]interface{})
+		if ref, ok := m["$ref"].(string); ok {
+			m = g.resolve(ref)
+		}
+		for k, v := range m {
+			switch k {
+			case "allOf", "$ref":
+			case "properties":
+				p, _ := v.(map[string]interface{})
+				for name, ps := range p {
+					props[name] = ps
+				}
+			case "required":
+				r, _ := v.([]interface{})
+				required = append(required, r...)
+			default:
+				merged[k] = v
+			}
+		}
+	}
+	if len(props) > 0 {
+		merged["properties"] = props
+	}
+	if required != nil {
+		merged["required"] = required
+	}
+	return merged
+}
+
+func (g *schemaGen) object(s map[string]interface{}, depth int) map[string]interface{} {
+	props, _ := s["properties"].(map[string]interface{})
+	required := make(map[string]bool)
+	if r, ok := s["required"].([]interface{}); ok {
+		for _, name := range r {
+			if n, ok := name.(string); ok {
+				required[n] = true
+			}
+		}
+	}
+	// in order, for the same documents from the same seed
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	obj := make(map[string]interface{})
+	for _, name := range names {
+		if !required[name] && (depth >= maxSchemaDepth || g.rnd.Intn(2) == 0) {
+			continue
+		}
+		ps, _ := props[name].(map[string]interface{})
+		obj[name] = g.value(ps, depth+1)
+	}
+	return obj
+}
+
+func (g *schemaGen) array(s map[string]interface{}, depth int) []interface{} {
+	lo := int(schemaNumber(s, "minItems", 0))
+	hi := int(schemaNumber(s, "maxItems", float64(lo+maxSchemaItems)))
+	if hi > lo+maxSchemaItems {
+		hi = lo + maxSchemaItems
+	}
+	n := lo
+	if depth < maxSchemaDepth && hi > lo {
+		n += g.rnd.Intn(hi - lo + 1)
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		var items map[string]interface{}
+		switch it := s["items"].(type) {
+		case map[string]interface{}:
+			items = it
+		case []interface{}:
+			// a tuple, the items past it are left unconstrained
+			if i < len(it) {
+				items, _ = it[i].(map[string]interface{})
+			}
+		}
+		arr[i] = g.value(items, depth+1)
+	}
+	return arr
+}
+
+// bounds returns the range of a number, or of an integer with step 1.
+func (g *schemaGen) bounds(s map[string]interface{}, step float64) (lo, hi float64) {
+	lo = schemaNumber(s, "minimum", math.NaN())
+	hi = schemaNumber(s, "maximum", math.NaN())
+	if x := schemaNumber(s, "exclusiveMinimum", math.NaN()); !math.IsNaN(x) {
+		lo = x + math.Max(step, 1e-9)
+	}
+	if x := schemaNumber(s, "exclusiveMaximum", math.NaN()); !math.IsNaN(x) {
+		hi = x - math.Max(step, 1e-9)
+	}
+	switch {
+	case math.IsNaN(lo) && math.IsNaN(hi):
+		lo, hi = 0, 1000
+	case math.IsNaN(lo):
+		lo = hi - 1000
+	case math.IsNaN(hi):
+		hi = lo + 1000
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+const schemaChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func (g *schemaGen) str(s map[string]interface{}) string {
+	switch s["format"] {
+	case "date-time":
+		return g.date().Format(time.RFC3339)
+	case "date":
+		return g.date().Format("2006-01-02")
+	case "email":
+		return g.chars(8) + "@example.com"
+	case "uuid":
+		u := g.rnd.Uint64()
+		return fmt.Sprintf("%08x-%04x-4%03x-a%03x-%012x", u>>32, u>>16&0xffff, u&0xfff, g.rnd.Intn(0x1000), g.rnd.Int63n(1<<48))
+	case "uri":
+		return "https://example.com/" + g.chars(8)
+	case "ipv4":
+		return fmt.Sprintf("10.%d.%d.%d", g.rnd.Intn(256), g.rnd.Intn(256), g.rnd.Intn(256))
+	}
+	lo := int(schemaNumber(s, "minLength", 0))
+	hi := int(schemaNumber(s, "maxLength", float64(lo+maxSchemaString)))
+	if hi > lo+maxSchemaString {
+		hi = lo + maxSchemaString
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return g.chars(lo + g.rnd.Intn(hi-lo+1))
+}
+
+func (g *schemaGen) chars(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = schemaChars[g.rnd.Intn(len(schemaChars))]
+	}
+	return string(b)
+}
+
+// date returns a time between 2020 and 2030.
+func (g *schemaGen) date() time.Time {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return start.Add(time.Duration(g.rnd.Int63n(int64(10 * 365 * 24 * time.Hour))).Truncate(time.Second))
+}
+
+// schemaInt converts f to an int64, saturating at the bounds of int64.
+func schemaInt(f float64) int64 {
+	switch {
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	case f <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+// schemaNumber returns the number keyword k of s, or def.
+func schemaNumber(s map[string]interface{}, k string, def float64) float64 {
+	if v, ok := s[k].(float64); ok {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// openStream opens the file of -D-stream and returns its size.
+func openStream(path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// streamRequest returns a clone of r whose body is the file at path, read
+// while it's sent rather than loaded in memory. The file is opened again
+// for each request, since sending consumes it.
+func streamRequest(r *http.Request, path string) *http.Request {
+	req := r.Clone(context.Background())
+	f, size, err := openStream(path)
+	if err != nil {
+		// the request fails with err when its body is sent
+		req.Body = ioutil.NopCloser(errReader{err})
+		return req
+	}
+	req.ContentLength = size
+	req.Body = f
+	if size == 0 {
+		f.Close()
+		req.Body = http.NoBody
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		f, _, err := openStream(path)
+		return f, err
+	}
+	return req
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
@@ -0,0 +1,99 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	gourl "net/url"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pengzhimou/hey/requester"
+)
+
+// verifyTLS checks the certificate chains of the https hosts of urls, with
+// up to conc handshakes at once, and prints the outcome for each host. It
+// returns false if any of them fails.
+func verifyTLS(w io.Writer, urls []string, serverName string, conc int, certs []tls.Certificate, timeout time.Duration) bool {
+	type target struct{ addr, serverName string }
+	var targets []target
+	seen := make(map[target]bool)
+	for _, u := range urls {
+		pu, err := gourl.Parse(u)
+		if err != nil || pu.Scheme != "https" {
+			fmt.Fprintf(w, "Skipping %s, not an https url.\n", u)
+			continue
+		}
+		port := pu.Port()
+		if port == "" {
+			port = "443"
+		}
+		t := target{net.JoinHostPort(pu.Hostname(), port), pu.Hostname()}
+		if serverName != "" {
+			t.serverName = serverName
+		}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+
+	checks := make([]requester.TLSCheck, len(targets))
+	sem := make(chan struct{}, conc)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t target) {
+			defer wg.Done()
+			checks[i] = requester.CheckTLS(t.addr, t.serverName, certs, timeout)
+			<-sem
+		}(i, t)
+	}
+	wg.Wait()
+	return printTLSChecks(w, checks, time.Now())
+}
+
+// printTLSChecks prints a line per check, and returns false if any failed.
+func printTLSChecks(w io.Writer, checks []requester.TLSCheck, now time.Time) bool {
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Host\tSNI\tStatus\tExpires\tDetails\n")
+	for _, c := range checks {
+		status := "OK"
+		switch {
+		case c.NameMismatch:
+			status = "SNI MISMATCH"
+		case c.Err != nil:
+			status = "INVALID"
+		}
+		expires := "-"
+		if !c.NotAfter.IsZero() {
+			expires = fmt.Sprintf("%s (%d days)", c.NotAfter.Format("2006-01-02"), int(c.NotAfter.Sub(now).Hours()/24))
+		}
+		details := "issuer " + c.Issuer
+		if c.Err != nil {
+			ok = false
+			details = c.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Addr, c.ServerName, status, expires, details)
+	}
+	tw.Flush()
+	return ok
+}